@@ -3,32 +3,55 @@ package api
 import (
 	"io"
 	"net/http"
+	"net/url"
+	"path/filepath"
 
 	// "github.com/dhowden/tag"
 	"github.com/zbo14/envoke/bigchain"
 	. "github.com/zbo14/envoke/common"
 	"github.com/zbo14/envoke/crypto/crypto"
 	"github.com/zbo14/envoke/crypto/ed25519"
+	"github.com/zbo14/envoke/crypto/keystore"
 	ld "github.com/zbo14/envoke/linked_data"
 	"github.com/zbo14/envoke/spec"
 )
 
+// Handlers sign as the party logged in to the request's session,
+// so each session gets a copy of the api with its party's keys
+
 type Api struct {
-	partyId string
-	logger  Logger
-	priv    crypto.PrivateKey
-	pub     crypto.PublicKey
+	cli         *bigchain.Client
+	keystoreDir string
+	partyId     string
+	logger      Logger
+	priv        crypto.PrivateKey
+	pub         crypto.PublicKey
+	sessions    *sessions
 }
 
-func NewApi() *Api {
+func NewApi(cli *bigchain.Client) *Api {
 	return &Api{
-		logger: NewLogger("api"),
+		cli:      cli,
+		logger:   NewLogger("api"),
+		sessions: newSessions(SESSION_TTL),
 	}
 }
 
+// Directory registered and recovered credentials are saved in, as
+// <partyId>.json. Recovery is disabled until it's set
+
+func (api *Api) SetKeystoreDir(dir string) {
+	api.keystoreDir = dir
+}
+
 func (api *Api) AddRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/login_handler", api.LoginHandler)
+	mux.HandleFunc("/logout_handler", api.LogoutHandler)
+	mux.HandleFunc("/login_challenge_handler", api.LoginChallengeHandler)
+	mux.HandleFunc("/login_signature_handler", api.LoginSignatureHandler)
+	mux.HandleFunc("/finalize_handler", api.FinalizeHandler)
 	mux.HandleFunc("/register_handler", api.RegisterHandler)
+	mux.HandleFunc("/recover_handler", api.RecoverHandler)
 	mux.HandleFunc("/compose_handler", api.ComposeHandler)
 	mux.HandleFunc("/record_handler", api.RecordHandler)
 	mux.HandleFunc("/right_handler", api.RightHandler)
@@ -36,9 +59,14 @@ func (api *Api) AddRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/release_handler", api.ReleaseHandler)
 	mux.HandleFunc("/license_handler", api.LicenseHandler)
 	mux.HandleFunc("/transfer_handler", api.TransferHandler)
+	mux.HandleFunc("/held_rights_handler", api.HeldRightsHandler)
+	mux.HandleFunc("/sign_handler", api.SignHandler)
+	mux.HandleFunc("/co_owned_transfer_handler", api.CoOwnedTransferHandler)
 	mux.HandleFunc("/search_handler", api.SearchHandler)
+	mux.HandleFunc("/provenance_handler", api.ProvenanceHandler)
 	mux.HandleFunc("/prove_handler", api.ProveHandler)
 	mux.HandleFunc("/verify_handler", api.VerifyHandler)
+	mux.HandleFunc("/verify_bundle_handler", api.VerifyBundleHandler)
 }
 
 func (api *Api) LoginHandler(w http.ResponseWriter, req *http.Request) {
@@ -52,24 +80,41 @@ func (api *Api) LoginHandler(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	credentials, err := form.File["credentials"][0].Open()
+	file, err := form.File["credentials"][0].Open()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	v := &struct {
-		Id         string `json:"id"`
-		PrivateKey string `json:"privateKey"`
-	}{}
-	if err = ReadJSON(credentials, v); err != nil {
+	var password string
+	if passwords := form.Value["password"]; len(passwords) > 0 {
+		password = passwords[0]
+	}
+	credentials := make(Data)
+	if err = ReadJSON(file, &credentials); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partyId, priv, err := keystore.Decrypt(credentials, password)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := api.Login(v.Id, v.PrivateKey); err != nil {
+	user, err := api.login(partyId, priv)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	// Plaintext credentials come back encrypted to replace the file
+	var res Data
+	if keystore.Plaintext(credentials) && !EmptyStr(password) {
+		encrypted, err := keystore.Encrypt(partyId, priv, password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res = Data{"keystore": encrypted}
+	}
+	api.writeSession(w, req, user, res)
 }
 
 func (api *Api) RegisterHandler(w http.ResponseWriter, req *http.Request) {
@@ -88,20 +133,59 @@ func (api *Api) RegisterHandler(w http.ResponseWriter, req *http.Request) {
 	memberIds := SplitStr(values.Get("memberIds"), ",")
 	name := values.Get("name")
 	password := values.Get("password")
-	path := values.Get("path")
 	pro := values.Get("pro")
 	sameAs := values.Get("sameAs")
 	_type := values.Get("type")
-	if _, err = api.Register(email, ipi, isni, memberIds, name, password, path, pro, sameAs, _type); err != nil {
+	metadata := spec.NewCreateMetadata(values.Get("client"), values.Get("clientVersion"))
+	// The client signs the party tx with its own key
+	if pubstr := values.Get("publicKey"); !EmptyStr(pubstr) {
+		pub := new(ed25519.PublicKey)
+		if err = pub.FromString(pubstr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prepared, err := api.PrepareRegister(email, ipi, isni, memberIds, metadata, name, pro, pub, sameAs, _type)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		WriteJSON(w, prepared)
+		return
+	}
+	registered, err := api.Register(email, ipi, isni, memberIds, metadata, name, password, pro, sameAs, _type)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The mnemonic is only shown once, for the party to back up its key
+	delete(registered, "privateKey")
+	WriteJSON(w, registered)
+}
+
+// Rewrites the credentials of "partyId" from the "mnemonic" of its key,
+// encrypted under "password", in the keystore directory
+
+func (api *Api) RecoverHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := UrlValues(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = api.Recover(values.Get("mnemonic"), values.Get("partyId"), values.Get("password")); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.Write([]byte("Registration successful!"))
+	WriteJSON(w, Data{"id": values.Get("partyId")})
 }
 
 func (api *Api) RightHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -113,6 +197,10 @@ func (api *Api) RightHandler(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var coOwnerIds []string
+	if str := values.Get("coOwnerIds"); !EmptyStr(str) {
+		coOwnerIds = SplitStr(str, ",")
+	}
 	var right Data
 	recipientId := values.Get("recipientId")
 	recipientShares := MustAtoi(values.Get("recipientShares"))
@@ -120,10 +208,11 @@ func (api *Api) RightHandler(w http.ResponseWriter, req *http.Request) {
 	_type := values.Get("type")
 	validFrom := values.Get("validFrom")
 	validThrough := values.Get("validThrough")
+	metadata := spec.NewCreateMetadata(values.Get("client"), values.Get("clientVersion"))
 	if _type == "composition_right" {
-		right, err = api.CompositionRight(recipientId, recipientShares, territory, validFrom, validThrough)
+		right, err = api.CompositionRight(coOwnerIds, metadata, recipientId, recipientShares, territory, validFrom, validThrough)
 	} else if _type == "recording_right" {
-		right, err = api.RecordingRight(recipientId, recipientShares, territory, validFrom, validThrough)
+		right, err = api.RecordingRight(coOwnerIds, metadata, recipientId, recipientShares, territory, validFrom, validThrough)
 	} else {
 		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
 		return
@@ -136,8 +225,9 @@ func (api *Api) RightHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (api *Api) ComposeHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -154,7 +244,8 @@ func (api *Api) ComposeHandler(w http.ResponseWriter, req *http.Request) {
 	lang := values.Get("lang")
 	sameAs := values.Get("sameAs")
 	title := values.Get("title")
-	composition, err := api.Compose(hfa, iswc, lang, sameAs, title)
+	metadata := spec.NewCreateMetadata(values.Get("client"), values.Get("clientVersion"))
+	composition, err := api.Compose(hfa, iswc, lang, metadata, sameAs, title)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -163,8 +254,9 @@ func (api *Api) ComposeHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (api *Api) RecordHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -188,7 +280,8 @@ func (api *Api) RecordHandler(w http.ResponseWriter, req *http.Request) {
 	mechanicalLicenseId := form.Value["mechanicalLicenseId"][0]
 	performerId := form.Value["performerId"][0]
 	publicationId := form.Value["publicationId"][0]
-	recording, err := api.Record(compositionId, compositionRightId, duration, file, isrc, mechanicalLicenseId, performerId, publicationId)
+	metadata := spec.NewCreateMetadata(req.FormValue("client"), req.FormValue("clientVersion"))
+	recording, err := api.Record(compositionId, compositionRightId, duration, file, isrc, mechanicalLicenseId, metadata, performerId, publicationId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -197,8 +290,9 @@ func (api *Api) RecordHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (api *Api) PublishHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -214,7 +308,8 @@ func (api *Api) PublishHandler(w http.ResponseWriter, req *http.Request) {
 	compositionRightIds := SplitStr(values.Get("compositionRightIds"), ",")
 	publisherId := values.Get("publisherId")
 	title := values.Get("title")
-	composition, err := api.Publish(compositionsId, compositionRightIds, publisherId, title)
+	metadata := spec.NewCreateMetadata(values.Get("client"), values.Get("clientVersion"))
+	composition, err := api.Publish(compositionsId, compositionRightIds, metadata, publisherId, title)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -223,8 +318,9 @@ func (api *Api) PublishHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (api *Api) ReleaseHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -240,7 +336,8 @@ func (api *Api) ReleaseHandler(w http.ResponseWriter, req *http.Request) {
 	recordingRightIds := SplitStr(values.Get("recordingRightIds"), ",")
 	recordLabelId := values.Get("recordLabelId")
 	title := values.Get("title")
-	release, err := api.Release(recordingIds, recordingRightIds, recordLabelId, title)
+	metadata := spec.NewCreateMetadata(values.Get("client"), values.Get("clientVersion"))
+	release, err := api.Release(metadata, recordingIds, recordingRightIds, recordLabelId, title)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -249,8 +346,9 @@ func (api *Api) ReleaseHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (api *Api) LicenseHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -272,14 +370,15 @@ func (api *Api) LicenseHandler(w http.ResponseWriter, req *http.Request) {
 	usage := SplitStr(values.Get("usage"), ",")
 	validFrom := values.Get("validFrom")
 	validThrough := values.Get("validThrough")
+	metadata := spec.NewCreateMetadata(values.Get("client"), values.Get("clientVersion"))
 	if _type == "mechanical_license" {
 		compositionIds := SplitStr(values.Get("compositionIds"), ",")
 		publicationId := values.Get("publicationId")
-		license, err = api.MechanicalLicense(compositionIds, rightId, transferId, publicationId, recipientId, territory, usage, validFrom, validThrough)
+		license, err = api.MechanicalLicense(compositionIds, rightId, transferId, metadata, publicationId, recipientId, territory, usage, validFrom, validThrough)
 	} else if _type == "master_license" {
 		recordingIds := SplitStr(values.Get("recordingIds"), ",")
 		releaseId := values.Get("releaseId")
-		license, err = api.MasterLicense(recipientId, recordingIds, rightId, transferId, releaseId, territory, usage, validFrom, validThrough)
+		license, err = api.MasterLicense(metadata, recipientId, recordingIds, rightId, transferId, releaseId, territory, usage, validFrom, validThrough)
 	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -289,8 +388,9 @@ func (api *Api) LicenseHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (api *Api) ProveHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -302,126 +402,179 @@ func (api *Api) ProveHandler(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	var sig crypto.Signature
+	if api.priv == nil {
+		proof, err := api.proveClientSide(values)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		WriteJSON(w, proof)
+		return
+	}
+	var proof Data
 	challenge := values.Get("challenge")
 	_type := values.Get("type")
 	switch _type {
 	case "composition":
 		compositionId := values.Get("compositionId")
-		sig, err = ld.ProveComposer(challenge, compositionId, api.priv)
+		proof, err = ld.ProveComposer(api.cli, challenge, compositionId, api.priv)
 	case "composition_right":
 		rightId := values.Get("rightId")
 		publicationId := values.Get("publicationReleaseId")
-		sig, err = ld.ProveCompositionRightHolder(challenge, rightId, api.priv, publicationId)
+		proof, err = ld.ProveCompositionRightHolder(api.cli, challenge, rightId, api.priv, publicationId)
 	case "composition_right_transfer":
 		transferId := values.Get("transferId")
 		publicationId := values.Get("publicationReleaseId")
-		sig, err = ld.ProveCompositionRightTransferHolder(challenge, transferId, api.partyId, api.priv, publicationId)
+		proof, err = ld.ProveCompositionRightTransferHolder(api.cli, challenge, transferId, api.partyId, api.priv, publicationId)
 	case "master_license":
 		licenseId := values.Get("licenseId")
-		sig, err = ld.ProveMasterLicenseHolder(challenge, licenseId, api.priv)
+		proof, err = ld.ProveMasterLicenseHolder(api.cli, challenge, licenseId, api.priv)
 	case "mechanical_license":
 		licenseId := values.Get("licenseId")
-		sig, err = ld.ProveMechanicalLicenseHolder(challenge, licenseId, api.priv)
+		proof, err = ld.ProveMechanicalLicenseHolder(api.cli, challenge, licenseId, api.priv)
 	case "publication":
 		publicationId := values.Get("publicationId")
-		sig, err = ld.ProvePublisher(challenge, api.priv, publicationId)
+		proof, err = ld.ProvePublisher(api.cli, challenge, api.priv, publicationId)
 	case "recording":
 		recordingId := values.Get("recordingId")
-		sig, err = ld.ProvePerformer(challenge, api.priv, recordingId)
+		proof, err = ld.ProvePerformer(api.cli, challenge, api.priv, recordingId)
 	case "recording_right":
 		rightId := values.Get("rightId")
 		releaseId := values.Get("publicationReleaseId")
-		sig, err = ld.ProveRecordingRightHolder(challenge, api.priv, rightId, releaseId)
+		proof, err = ld.ProveRecordingRightHolder(api.cli, challenge, api.priv, rightId, releaseId)
 	case "recording_right_transfer":
 		transferId := values.Get("transferId")
 		releaseId := values.Get("publicationReleaseId")
-		sig, err = ld.ProveRecordingRightTransferHolder(challenge, api.partyId, api.priv, transferId, releaseId)
+		proof, err = ld.ProveRecordingRightTransferHolder(api.cli, challenge, api.partyId, api.priv, transferId, releaseId)
 	case "release":
 		releaseId := values.Get("releaseId")
-		sig, err = ld.ProveRecordLabel(challenge, api.priv, releaseId)
+		proof, err = ld.ProveRecordLabel(api.cli, challenge, api.priv, releaseId)
 	default:
 		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
 		return
 	}
+	if err == nil && values.Get("bundle") == "true" {
+		proof, err = ld.BundleProof(api.cli, proof)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	WriteJSON(w, sig)
+	WriteJSON(w, proof)
 }
 
-func (api *Api) VerifyHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
-	if req.Method != http.MethodPost {
-		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
-		return
-	}
-	values, err := UrlValues(req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+// For a party that signs client-side, the unsigned proof and its hash,
+// or the finalized proof once "signature" of the hash is passed with
+// the "proof"
+
+func (api *Api) proveClientSide(values url.Values) (Data, error) {
+	if sigstr := values.Get("signature"); !EmptyStr(sigstr) {
+		proof := make(Data)
+		if err := UnmarshalJSON([]byte(values.Get("proof")), &proof); err != nil {
+			return nil, err
+		}
+		proof, err := api.FinalizeProof(proof, sigstr)
+		if err == nil && values.Get("bundle") == "true" {
+			proof, err = ld.BundleProof(api.cli, proof)
+		}
+		return proof, err
 	}
 	challenge := values.Get("challenge")
-	sig := new(ed25519.Signature)
-	signature := values.Get("signature")
-	if err := sig.FromString(signature); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+	publicationReleaseId := values.Get("publicationReleaseId")
 	_type := values.Get("type")
 	switch _type {
 	case "composition":
-		compositionId := values.Get("compositionId")
-		err = ld.VerifyComposer(challenge, compositionId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_COMPOSER, values.Get("compositionId"), nil)
 	case "composition_right":
-		rightId := values.Get("rightId")
-		publicationId := values.Get("publicationReleaseId")
-		err = ld.VerifyCompositionRightHolder(challenge, rightId, publicationId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_COMPOSITION_RIGHT_HOLDER, values.Get("rightId"), Data{"publicationId": publicationReleaseId})
 	case "composition_right_transfer":
-		transferId := values.Get("transferId")
-		publicationId := values.Get("publicationReleaseId")
-		err = ld.VerifyCompositionRightTransferHolder(challenge, transferId, api.partyId, publicationId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_COMPOSITION_RIGHT_TRANSFER_HOLDER, values.Get("transferId"), Data{"holderId": api.partyId, "publicationId": publicationReleaseId})
 	case "master_license":
-		licenseId := values.Get("licenseId")
-		err = ld.VerifyMasterLicenseHolder(challenge, licenseId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_MASTER_LICENSE_HOLDER, values.Get("licenseId"), nil)
 	case "mechanical_license":
-		licenseId := values.Get("licenseId")
-		err = ld.VerifyMechanicalLicenseHolder(challenge, licenseId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_MECHANICAL_LICENSE_HOLDER, values.Get("licenseId"), nil)
 	case "publication":
-		publicationId := values.Get("publicationId")
-		err = ld.VerifyPublisher(challenge, publicationId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_PUBLISHER, values.Get("publicationId"), nil)
 	case "recording":
-		recordingId := values.Get("recordingId")
-		err = ld.VerifyPerformer(challenge, recordingId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_PERFORMER, values.Get("recordingId"), nil)
 	case "recording_right":
-		rightId := values.Get("rightId")
-		releaseId := values.Get("publicationReleaseId")
-		err = ld.VerifyRecordingRightHolder(challenge, rightId, releaseId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_RECORDING_RIGHT_HOLDER, values.Get("rightId"), Data{"releaseId": publicationReleaseId})
 	case "recording_right_transfer":
-		transferId := values.Get("transferId")
-		releaseId := values.Get("publicationReleaseId")
-		err = ld.VerifyRecordingRightTransferHolder(challenge, api.partyId, transferId, releaseId, sig)
+		return api.PrepareProof(challenge, ld.ROLE_RECORDING_RIGHT_TRANSFER_HOLDER, values.Get("transferId"), Data{"holderId": api.partyId, "releaseId": publicationReleaseId})
 	case "release":
-		releaseId := values.Get("releaseId")
-		err = ld.VerifyRecordLabel(challenge, releaseId, sig)
-	default:
-		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
+		return api.PrepareProof(challenge, ld.ROLE_RECORD_LABEL, values.Get("releaseId"), nil)
+	}
+	return nil, ErrorAppend(ErrInvalidType, _type)
+}
+
+// Verifies a proof from the prove handler, passed as JSON in "proof"
+// If a challenge is passed, the proof must be for it
+
+func (api *Api) VerifyHandler(w http.ResponseWriter, req *http.Request) {
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
 		return
 	}
+	values, err := UrlValues(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	proof := make(Data)
+	if err = UnmarshalJSON([]byte(values.Get("proof")), &proof); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if challenge := values.Get("challenge"); !EmptyStr(challenge) && challenge != ld.GetProofChallenge(proof) {
+		http.Error(w, ErrorAppend(ErrCriteriaNotMet, "proof is for another challenge").Error(), http.StatusBadRequest)
+		return
+	}
+	if err = ld.VerifyProof(api.cli, proof); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	WriteJSON(w, "Verified signature!")
 }
 
+// Verifies a bundle from the prove handler, passed as JSON in "bundle",
+// without reading the ledger, so no login is needed
+
+func (api *Api) VerifyBundleHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := UrlValues(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bundle := make(Data)
+	if err = UnmarshalJSON([]byte(values.Get("bundle")), &bundle); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proof := bundle.GetData("proof")
+	if challenge := values.Get("challenge"); !EmptyStr(challenge) && (proof == nil || challenge != ld.GetProofChallenge(proof)) {
+		http.Error(w, ErrorAppend(ErrCriteriaNotMet, "proof is for another challenge").Error(), http.StatusBadRequest)
+		return
+	}
+	if err = ld.VerifyBundle(bundle); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, "Verified bundle!")
+}
+
 func (api *Api) SearchHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -436,25 +589,36 @@ func (api *Api) SearchHandler(w http.ResponseWriter, req *http.Request) {
 	var model interface{}
 	field := values.Get("field")
 	_type := values.Get("type")
+	// Free-text mode
+	if query := values.Get("query"); !EmptyStr(query) {
+		limit, _ := Atoi(values.Get("limit"))
+		model, err = api.Search(query, limit, _type)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		WriteJSON(w, model)
+		return
+	}
 	switch _type {
 	case "composition":
 		compositionId := values.Get("compositionId")
-		model, err = ld.QueryCompositionField(compositionId, field)
+		model, err = ld.QueryCompositionField(api.cli, compositionId, field)
 	case "master_license":
 		licenseId := values.Get("licenseId")
-		model, err = ld.QueryMasterLicenseField(field, licenseId)
+		model, err = ld.QueryMasterLicenseField(api.cli, field, licenseId)
 	case "mechanical_license":
 		licenseId := values.Get("licenseId")
-		model, err = ld.QueryMechanicalLicenseField(field, licenseId)
+		model, err = ld.QueryMechanicalLicenseField(api.cli, field, licenseId)
 	case "publication":
 		publicationId := values.Get("publicationId")
-		model, err = ld.QueryPublicationField(field, publicationId)
+		model, err = ld.QueryPublicationField(api.cli, field, publicationId)
 	case "recording":
 		recordingId := values.Get("recordingId")
-		model, err = ld.QueryRecordingField(field, recordingId)
+		model, err = ld.QueryRecordingField(api.cli, field, recordingId)
 	case "release":
 		releaseId := values.Get("releaseId")
-		model, err = ld.QueryReleaseField(field, releaseId)
+		model, err = ld.QueryReleaseField(api.cli, field, releaseId)
 	default:
 		http.Error(w, "Expected publicationId or releaseId", http.StatusBadRequest)
 		return
@@ -466,9 +630,138 @@ func (api *Api) SearchHandler(w http.ResponseWriter, req *http.Request) {
 	WriteJSON(w, model)
 }
 
+func (api *Api) HeldRightsHandler(w http.ResponseWriter, req *http.Request) {
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodGet {
+		http.Error(w, ErrExpectedGet.Error(), http.StatusBadRequest)
+		return
+	}
+	rights, err := api.HeldRights()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, rights)
+}
+
+// Provenance graph of a model as Graphviz DOT if format is "dot",
+// otherwise as JSON-LD
+
+func (api *Api) ProvenanceHandler(w http.ResponseWriter, req *http.Request) {
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := UrlValues(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	graph, err := ld.BuildProvenanceGraph(api.cli, values.Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if values.Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write([]byte(graph.DOT()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/ld+json")
+	WriteJSON(w, graph.JSONLD())
+}
+
+func (api *Api) SignHandler(w http.ResponseWriter, req *http.Request) {
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := UrlValues(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tx := make(Data)
+	if err = UnmarshalJSON([]byte(values.Get("tx")), &tx); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var signed Data
+	if sigstrs := values.Get("signatures"); !EmptyStr(sigstrs) {
+		signed, err = api.SignTxWithSignatures(tx, SplitStr(sigstrs, ","))
+	} else {
+		signed, err = api.SignTx(tx)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, signed)
+}
+
+// Exports an unsigned transfer of output "output" of tx "consumeId" to
+// the comma-separated "recipientIds" with "recipientShares"
+
+func (api *Api) CoOwnedTransferHandler(w http.ResponseWriter, req *http.Request) {
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := UrlValues(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	output, err := Atoi(values.Get("output"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var recipientShares []int
+	for _, str := range SplitStr(values.Get("recipientShares"), ",") {
+		shares, err := Atoi(str)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recipientShares = append(recipientShares, shares)
+	}
+	metadata, err := spec.NewTransferMetadata(values.Get("contract"), values.Get("effectiveDate"), values.Get("reason"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prepared, err := api.PrepareCoOwnedTransfer(values.Get("consumeId"), metadata, output, SplitStr(values.Get("recipientIds"), ","), recipientShares)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, prepared)
+}
+
 func (api *Api) TransferHandler(w http.ResponseWriter, req *http.Request) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	api, err := api.Session(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if req.Method != http.MethodPost {
@@ -482,17 +775,40 @@ func (api *Api) TransferHandler(w http.ResponseWriter, req *http.Request) {
 	}
 	var transfer Data
 	recipientId := values.Get("recipientId")
+	_type := values.Get("type")
+	// The second round of a transfer signed client-side, with the id of
+	// the finalized TRANSFER tx
+	if txId := values.Get("txId"); !EmptyStr(txId) {
+		switch _type {
+		case "composition_right_transfer":
+			transfer, err = api.CompositionRightTransfer(values.Get("publicationReleaseId"), recipientId, txId)
+		case "recording_right_transfer":
+			transfer, err = api.RecordingRightTransfer(recipientId, values.Get("publicationReleaseId"), txId)
+		default:
+			err = ErrorAppend(ErrInvalidType, _type)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		WriteJSON(w, transfer)
+		return
+	}
 	recipientShares := MustAtoi(values.Get("recipientShares"))
 	rightId := values.Get("rightId")
 	transferId := values.Get("transferId")
-	_type := values.Get("type")
+	metadata, err := spec.NewTransferMetadata(values.Get("contract"), values.Get("effectiveDate"), values.Get("reason"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	switch _type {
 	case "composition_right_transfer":
 		publicationId := values.Get("publicationReleaseId")
-		transfer, err = api.TransferCompositionRight(rightId, transferId, publicationId, recipientId, recipientShares)
+		transfer, err = api.TransferCompositionRight(rightId, transferId, metadata, publicationId, recipientId, recipientShares)
 	case "recording_right_transfer":
 		releaseId := values.Get("publicationReleaseId")
-		transfer, err = api.TransferRecordingRight(recipientId, recipientShares, rightId, transferId, releaseId)
+		transfer, err = api.TransferRecordingRight(metadata, recipientId, recipientShares, rightId, transferId, releaseId)
 	default:
 		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
 		return
@@ -508,8 +824,6 @@ func (api *Api) LoggedIn() bool {
 	switch {
 	case api.partyId == "":
 		api.logger.Warn("Party ID is not set")
-	case api.priv == nil:
-		api.logger.Warn("Private-key is not set")
 	case api.pub == nil:
 		api.logger.Warn("Public-key is not set")
 	default:
@@ -519,55 +833,102 @@ func (api *Api) LoggedIn() bool {
 	return false
 }
 
-func (api *Api) Login(partyId, privstr string) error {
+// Returns a copy of the api that signs as the party
+
+func (api *Api) Login(partyId, privstr string) (*Api, error) {
 	priv := new(ed25519.PrivateKey)
 	if err := priv.FromString(privstr); err != nil {
-		return err
+		return nil, err
 	}
-	tx, err := ld.QueryAndValidateModel(partyId, "party")
+	return api.login(partyId, priv)
+}
+
+func (api *Api) login(partyId string, priv crypto.PrivateKey) (*Api, error) {
+	tx, err := ld.QueryAndValidateModel(api.cli, partyId, "party")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	party := bigchain.GetTxData(tx)
 	pub := bigchain.DefaultGetTxSender(tx)
 	if !pub.Equals(priv.Public()) {
-		return ErrInvalidKey
+		return nil, ErrInvalidKey
 	}
-	api.partyId = partyId
-	api.priv = priv
-	api.pub = pub
+	user := *api
+	user.partyId = partyId
+	user.priv = priv
+	user.pub = pub
 	partyName := spec.GetName(party)
 	api.logger.Info(Sprintf("SUCCESS %s is logged in", partyName))
-	return nil
+	return &user, nil
 }
 
-func (api *Api) Register(email, ipi, isni string, memberIds []string, name, password, path, pro, sameAs, _type string) (Data, error) {
-	priv, pub := ed25519.GenerateKeypairFromPassword(password)
+// Registers a party with a new key, returning its credentials with
+// the "keystore" encrypted under the password, also saved in the
+// keystore directory if it's set
+
+func (api *Api) Register(email, ipi, isni string, memberIds []string, metadata Data, name, password, pro, sameAs, _type string) (Data, error) {
+	if EmptyStr(password) {
+		return nil, ErrorAppend(ErrEmptyStr, "password")
+	}
+	priv, pub := ed25519.GenerateKeypair()
 	party := spec.NewParty(email, ipi, isni, memberIds, name, pro, sameAs, _type)
-	tx := bigchain.DefaultIndividualCreateTx(party, pub)
+	tx := api.cli.DefaultIndividualCreateTx(party, metadata, pub)
 	bigchain.FulfillTx(tx, priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
+	// So the party can login right away
+	if err = api.cli.WaitForCommit(id, bigchain.DEFAULT_TIMEOUT); err != nil {
+		return nil, err
+	}
 	api.logger.Info("SUCCESS registered new party: " + name)
-	file, err := CreateFile(path + "/credentials.json")
+	// Credentials are encrypted under the password
+	encrypted, err := keystore.Encrypt(id, priv, password)
 	if err != nil {
 		return nil, err
 	}
-	v := Data{
+	if !EmptyStr(api.keystoreDir) {
+		if err = keystore.SaveEncrypted(filepath.Join(api.keystoreDir, id+".json"), encrypted); err != nil {
+			return nil, err
+		}
+	}
+	return Data{
 		"id":         id,
+		"keystore":   encrypted,
+		"mnemonic":   priv.ToMnemonic(),
 		"privateKey": priv.String(),
+	}, nil
+}
+
+// Recovers a party's private key from its mnemonic and saves the
+// credentials encrypted under the new password in the keystore directory
+
+func (api *Api) Recover(mnemonic, partyId, password string) error {
+	if EmptyStr(api.keystoreDir) {
+		return ErrorAppend(ErrInvalidRequest, "no keystore directory for recovered credentials")
+	}
+	if !spec.MatchId(partyId) {
+		return ErrorAppend(ErrInvalidId, partyId)
+	}
+	priv := new(ed25519.PrivateKey)
+	if err := priv.FromMnemonic(mnemonic); err != nil {
+		return err
 	}
-	WriteJSON(file, &v)
-	return v, nil
+	if _, err := api.login(partyId, priv); err != nil {
+		return err
+	}
+	return keystore.Save(filepath.Join(api.keystoreDir, partyId+".json"), partyId, priv, password)
 }
 
-func (api *Api) Compose(hfa, iswc, lang, sameAs, title string) (Data, error) {
+func (api *Api) Compose(hfa, iswc, lang string, metadata Data, sameAs, title string) (Data, error) {
 	composition := spec.NewComposition(api.partyId, hfa, iswc, lang, title, sameAs)
-	tx := bigchain.DefaultIndividualCreateTx(composition, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(composition, metadata, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "composition", composition)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -578,7 +939,7 @@ func (api *Api) Compose(hfa, iswc, lang, sameAs, title string) (Data, error) {
 	}, nil
 }
 
-func (api *Api) Record(compositionId, compositionRightId, duration string, file io.Reader, isrc, mechanicalLicenseId, performerId, publicationId string) (Data, error) {
+func (api *Api) Record(compositionId, compositionRightId, duration string, file io.Reader, isrc, mechanicalLicenseId string, metadata Data, performerId, publicationId string) (Data, error) {
 	// rs := MustReadSeeker(file)
 	// meta, err := tag.ReadFrom(rs)
 	// if err != nil {
@@ -586,9 +947,12 @@ func (api *Api) Record(compositionId, compositionRightId, duration string, file
 	// }
 	// metadata := meta.Raw()
 	recording := spec.NewRecording(compositionId, compositionRightId, duration, isrc, mechanicalLicenseId, performerId, publicationId)
-	tx := bigchain.DefaultIndividualCreateTx(recording, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(recording, metadata, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "recording", recording)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -599,11 +963,14 @@ func (api *Api) Record(compositionId, compositionRightId, duration string, file
 	}, nil
 }
 
-func (api *Api) Publish(compositionIds, compositionRightIds []string, publisherId, title string) (Data, error) {
+func (api *Api) Publish(compositionIds, compositionRightIds []string, metadata Data, publisherId, title string) (Data, error) {
 	publication := spec.NewPublication(compositionIds, compositionRightIds, title, publisherId)
-	tx := bigchain.DefaultIndividualCreateTx(publication, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(publication, metadata, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "publication", publication)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -614,11 +981,14 @@ func (api *Api) Publish(compositionIds, compositionRightIds []string, publisherI
 	}, nil
 }
 
-func (api *Api) Release(recordingIds, recordingRightIds []string, recordLabelId, title string) (Data, error) {
+func (api *Api) Release(metadata Data, recordingIds, recordingRightIds []string, recordLabelId, title string) (Data, error) {
 	release := spec.NewRelease(title, recordingIds, recordingRightIds, recordLabelId)
-	tx := bigchain.DefaultIndividualCreateTx(release, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(release, metadata, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "release", release)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -629,16 +999,20 @@ func (api *Api) Release(recordingIds, recordingRightIds []string, recordLabelId,
 	}, nil
 }
 
-func (api *Api) CompositionRight(recipientId string, recipientShares int, territory []string, validFrom, validThrough string) (Data, error) {
-	tx, err := bigchain.GetTx(recipientId)
+// Parties in coOwnerIds share the recipient's output, so its shares
+// are only transferred once they all sign
+
+func (api *Api) CompositionRight(coOwnerIds []string, metadata Data, recipientId string, recipientShares int, territory []string, validFrom, validThrough string) (Data, error) {
+	compositionRight := spec.NewCompositionRight(recipientId, api.partyId, territory, validFrom, validThrough)
+	tx, err := api.rightTx(coOwnerIds, metadata, recipientId, recipientShares, compositionRight)
 	if err != nil {
 		return nil, err
 	}
-	recipientPub := bigchain.DefaultGetTxSender(tx)
-	compositionRight := spec.NewCompositionRight(recipientId, api.partyId, territory, validFrom, validThrough)
-	tx = bigchain.IndividualCreateTx(recipientShares, compositionRight, recipientPub, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "compositionRight", compositionRight)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -649,16 +1023,17 @@ func (api *Api) CompositionRight(recipientId string, recipientShares int, territ
 	}, nil
 }
 
-func (api *Api) RecordingRight(recipientId string, recipientShares int, territory []string, validFrom, validThrough string) (Data, error) {
-	tx, err := bigchain.GetTx(recipientId)
+func (api *Api) RecordingRight(coOwnerIds []string, metadata Data, recipientId string, recipientShares int, territory []string, validFrom, validThrough string) (Data, error) {
+	recordingRight := spec.NewRecordingRight(recipientId, api.partyId, territory, validFrom, validThrough)
+	tx, err := api.rightTx(coOwnerIds, metadata, recipientId, recipientShares, recordingRight)
 	if err != nil {
 		return nil, err
 	}
-	recipientPub := bigchain.DefaultGetTxSender(tx)
-	recordingRight := spec.NewRecordingRight(recipientId, api.partyId, territory, validFrom, validThrough)
-	tx = bigchain.IndividualCreateTx(recipientShares, recordingRight, recipientPub, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "recordingRight", recordingRight)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -669,11 +1044,47 @@ func (api *Api) RecordingRight(recipientId string, recipientShares int, territor
 	}, nil
 }
 
-func (api *Api) MechanicalLicense(compositionIds []string, compositionRightId, compositionRightTransferId, publicationId, recipientId string, territory, usage []string, validFrom, validThrough string) (Data, error) {
+// Unsigned CREATE tx of a right. The recipient comes first in the
+// output's owners, so the right validates against the recipient
+
+func (api *Api) rightTx(coOwnerIds []string, metadata Data, recipientId string, recipientShares int, right Data) (Data, error) {
+	recipientPub, err := api.partyPub(recipientId)
+	if err != nil {
+		return nil, err
+	}
+	if len(coOwnerIds) == 0 {
+		return api.cli.IndividualCreateTx(recipientShares, right, metadata, recipientPub, api.pub), nil
+	}
+	owners := []crypto.PublicKey{recipientPub}
+	for _, coOwnerId := range coOwnerIds {
+		if coOwnerId == recipientId {
+			return nil, ErrorAppend(ErrCriteriaNotMet, "recipient cannot be a co-owner")
+		}
+		pub, err := api.partyPub(coOwnerId)
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, pub)
+	}
+	return api.cli.MultipleOwnersCreateTx([]int{recipientShares}, right, metadata, owners, api.pub), nil
+}
+
+func (api *Api) partyPub(partyId string) (crypto.PublicKey, error) {
+	tx, err := ld.QueryAndValidateModel(api.cli, partyId, "party")
+	if err != nil {
+		return nil, err
+	}
+	return bigchain.DefaultGetTxSender(tx), nil
+}
+
+func (api *Api) MechanicalLicense(compositionIds []string, compositionRightId, compositionRightTransferId string, metadata Data, publicationId, recipientId string, territory, usage []string, validFrom, validThrough string) (Data, error) {
 	mechanicalLicense := spec.NewMechanicalLicense(compositionIds, compositionRightId, compositionRightTransferId, publicationId, recipientId, api.partyId, territory, usage, validFrom, validThrough)
-	tx := bigchain.DefaultIndividualCreateTx(mechanicalLicense, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(mechanicalLicense, metadata, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "mechanicalLicense", mechanicalLicense)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -684,11 +1095,14 @@ func (api *Api) MechanicalLicense(compositionIds []string, compositionRightId, c
 	}, nil
 }
 
-func (api *Api) MasterLicense(recipientId string, recordingIds []string, recordingRightId, recordingRightTransferId, releaseId string, territory, usage []string, validFrom, validThrough string) (Data, error) {
+func (api *Api) MasterLicense(metadata Data, recipientId string, recordingIds []string, recordingRightId, recordingRightTransferId, releaseId string, territory, usage []string, validFrom, validThrough string) (Data, error) {
 	masterLicense := spec.NewMasterLicense(recipientId, recordingIds, recordingRightId, recordingRightTransferId, releaseId, api.partyId, territory, usage, validFrom, validThrough)
-	tx := bigchain.DefaultIndividualCreateTx(masterLicense, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(masterLicense, metadata, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "masterLicense", masterLicense)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -699,57 +1113,173 @@ func (api *Api) MasterLicense(recipientId string, recordingIds []string, recordi
 	}, nil
 }
 
+// Adds the party's signature to an exported tx with co-owned inputs
+// The tx is sent once every threshold is met, otherwise it's returned
+// so the next co-owner can sign
+
+// A party that signs client-side gets the messages to sign instead, as
+// Data{"messages", "sent", "tx"}, for SignTxWithSignatures
+
+func (api *Api) SignTx(tx Data) (Data, error) {
+	if api.priv == nil {
+		msgs, err := bigchain.PartialTxMessages(tx, api.pub)
+		if err != nil {
+			return nil, err
+		}
+		return Data{
+			"messages": encodeMessages(msgs),
+			"sent":     false,
+			"tx":       tx,
+		}, nil
+	}
+	if err := bigchain.PartiallyFulfillTx(tx, api.priv); err != nil {
+		return nil, err
+	}
+	return api.sendSignedTx(tx)
+}
+
+func (api *Api) sendSignedTx(tx Data) (Data, error) {
+	if !bigchain.FulfilledTx(tx) {
+		api.logger.Info("SUCCESS signed tx, waiting for co-owners")
+		return Data{
+			"sent": false,
+			"tx":   tx,
+		}, nil
+	}
+	id, err := api.cli.PostTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	api.logger.Info("SUCCESS sent tx signed by co-owners")
+	return Data{
+		"id":   id,
+		"sent": true,
+		"tx":   tx,
+	}, nil
+}
+
+// Unsigned TRANSFER tx of an output the party co-owns, to be signed by
+// each owner with SignTx. recipientShares must add up to the output's
+// amount; a single recipient share is held by all the recipients
+
+func (api *Api) PrepareCoOwnedTransfer(consumeId string, metadata Data, output int, recipientIds []string, recipientShares []int) (Data, error) {
+	consumed, err := api.cli.GetTx(consumeId)
+	if err != nil {
+		return nil, err
+	}
+	outputs := bigchain.GetTxOutputs(consumed)
+	if output < 0 || output >= len(outputs) {
+		return nil, ErrorAppend(ErrInvalidSize, "output index out of range")
+	}
+	ownersBefore := bigchain.GetOutputPublicKeys(outputs[output])
+	owner := false
+	for _, pub := range ownersBefore {
+		if api.pub.Equals(pub) {
+			owner = true
+		}
+	}
+	if !owner {
+		return nil, ErrorAppend(ErrCriteriaNotMet, "party does not own output")
+	}
+	if len(recipientIds) == 0 || (len(recipientShares) != 1 && len(recipientShares) != len(recipientIds)) {
+		return nil, ErrorAppend(ErrInvalidSize, "expected shares for each recipient or one share for all")
+	}
+	total := 0
+	for _, shares := range recipientShares {
+		if shares <= 0 {
+			return nil, ErrorAppend(ErrCriteriaNotMet, "recipient shares must be greater than 0")
+		}
+		total += shares
+	}
+	if total != bigchain.GetOutputAmount(outputs[output]) {
+		return nil, ErrorAppend(ErrCriteriaNotMet, Sprintf("recipient shares %d do not equal output amount", total))
+	}
+	recipientPubs := make([]crypto.PublicKey, len(recipientIds))
+	for i, recipientId := range recipientIds {
+		if recipientPubs[i], err = api.partyPub(recipientId); err != nil {
+			return nil, err
+		}
+	}
+	assetId := bigchain.GetTxAssetIdOrId(consumed)
+	tx := api.cli.MultipleOwnersTransferTx(recipientShares, assetId, consumeId, metadata, output, recipientPubs, ownersBefore)
+	return Data{
+		"sent": false,
+		"tx":   tx,
+	}, nil
+}
+
+// Models of a type whose text matches query, e.g. compositions by
+// title, parties by name or IPI and recordings by ISRC
+
+func (api *Api) Search(query string, limit int, _type string) ([]Data, error) {
+	switch _type {
+	case "composition":
+		return ld.SearchCompositions(api.cli, query, limit)
+	case "party":
+		return ld.SearchParties(api.cli, query, limit)
+	case "recording":
+		return ld.SearchRecordings(api.cli, query, limit)
+	case "master_license", "mechanical_license", "publication", "release", "right":
+		return ld.SearchModels(api.cli, query, limit, _type)
+	}
+	return nil, ErrorAppend(ErrInvalidType, _type)
+}
+
+// Rights the party holds shares in, with the unspent outputs
+
+func (api *Api) HeldRights() ([]Data, error) {
+	return ld.QueryHeldRights(api.cli, api.pub)
+}
+
 // Note: output 0 for sender shares and output 1 for recipient shares
+// metadata is attached to the TRANSFER tx
 
-func (api *Api) TransferCompositionRight(compositionRightId, compositionRightTransferId, publicationId, recipientId string, recipientShares int) (Data, error) {
-	var output, totalShares int
-	var txId string
+func (api *Api) TransferCompositionRight(compositionRightId, compositionRightTransferId string, metadata Data, publicationId, recipientId string, recipientShares int) (Data, error) {
 	if !EmptyStr(compositionRightTransferId) {
-		compositionRightTransfer, err := ld.ValidateCompositionRightTransfer(compositionRightTransferId)
+		compositionRightTransfer, err := ld.ValidateCompositionRightTransfer(api.cli, compositionRightTransferId)
 		if err != nil {
 			return nil, err
 		}
-		if api.partyId == spec.GetRecipientId(compositionRightTransfer) {
-			totalShares = spec.GetRecipientShares(compositionRightTransfer)
-			output = 1
-		} else if api.partyId == spec.GetSenderId(compositionRightTransfer) {
-			totalShares = spec.GetSenderShares(compositionRightTransfer)
-		} else {
+		if api.partyId != spec.GetRecipientId(compositionRightTransfer) && api.partyId != spec.GetSenderId(compositionRightTransfer) {
 			return nil, ErrorAppend(ErrCriteriaNotMet, "partyId does not match recipientId or senderId of TRANSFER tx")
 		}
 		compositionRightId = spec.GetCompositionRightId(compositionRightTransfer)
-		txId = spec.GetTxId(compositionRightTransfer)
-	} else {
-		tx, err := bigchain.GetTx(compositionRightId)
-		if err != nil {
-			return nil, err
-		}
-		totalShares = bigchain.GetTxShares(tx)
-		txId = compositionRightId
 	}
-	tx, err := bigchain.GetTx(recipientId)
+	tx, err := api.cli.GetTx(recipientId)
 	if err != nil {
 		return nil, err
 	}
 	recipientPub := bigchain.DefaultGetTxSender(tx)
-	senderShares := totalShares - recipientShares
-	if senderShares < 0 {
-		return nil, ErrorAppend(ErrCriteriaNotMet, "cannot transfer this many shares")
+	tx, err = api.transferTx(metadata, recipientPub, recipientShares, compositionRightId)
+	if err != nil {
+		return nil, err
 	}
-	if senderShares == 0 {
-		tx = bigchain.IndividualTransferTx(recipientShares, compositionRightId, txId, output, recipientPub, api.pub)
-	} else {
-		tx = bigchain.DivisibleTransferTx([]int{senderShares, recipientShares}, compositionRightId, txId, output, []crypto.PublicKey{api.pub, recipientPub}, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "", nil)
 	}
 	bigchain.FulfillTx(tx, api.priv)
-	txId, err = bigchain.PostTx(tx)
+	txId, err := api.cli.PostTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return api.CompositionRightTransfer(publicationId, recipientId, txId)
+}
+
+// Transfer model for a TRANSFER tx the party sent, the second round of
+// a transfer signed client-side
+
+func (api *Api) CompositionRightTransfer(publicationId, recipientId, txId string) (Data, error) {
+	compositionRightId, err := api.sentTransfer(txId)
 	if err != nil {
 		return nil, err
 	}
 	compositionRightTransfer := spec.NewCompositionRightTransfer(compositionRightId, publicationId, recipientId, api.partyId, txId)
-	tx = bigchain.DefaultIndividualCreateTx(compositionRightTransfer, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(compositionRightTransfer, nil, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "compositionRightTransfer", compositionRightTransfer)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -760,55 +1290,52 @@ func (api *Api) TransferCompositionRight(compositionRightId, compositionRightTra
 	}, nil
 }
 
-func (api *Api) TransferRecordingRight(recipientId string, recipientShares int, recordingRightId, recordingRightTransferId, releaseId string) (Data, error) {
-	var output, totalShares int
-	var txId string
+func (api *Api) TransferRecordingRight(metadata Data, recipientId string, recipientShares int, recordingRightId, recordingRightTransferId, releaseId string) (Data, error) {
 	if !EmptyStr(recordingRightTransferId) {
-		recordingRightTransfer, err := ld.ValidateRecordingRightTransfer(recordingRightTransferId)
+		recordingRightTransfer, err := ld.ValidateRecordingRightTransfer(api.cli, recordingRightTransferId)
 		if err != nil {
 			return nil, err
 		}
-		if api.partyId == spec.GetRecipientId(recordingRightTransfer) {
-			totalShares = spec.GetRecipientShares(recordingRightTransfer)
-			output = 1
-		} else if api.partyId == spec.GetSenderId(recordingRightTransfer) {
-			totalShares = spec.GetSenderShares(recordingRightTransfer)
-		} else {
+		if api.partyId != spec.GetRecipientId(recordingRightTransfer) && api.partyId != spec.GetSenderId(recordingRightTransfer) {
 			return nil, ErrorAppend(ErrCriteriaNotMet, "partyId does not match recipientId or senderId of TRANSFER tx")
 		}
 		recordingRightId = spec.GetRecordingRightId(recordingRightTransfer)
-		txId = spec.GetTxId(recordingRightTransfer)
-	} else {
-		tx, err := bigchain.GetTx(recordingRightId)
-		if err != nil {
-			return nil, err
-		}
-		totalShares = bigchain.GetTxShares(tx)
-		txId = recordingRightId
 	}
-	tx, err := bigchain.GetTx(recipientId)
+	tx, err := api.cli.GetTx(recipientId)
 	if err != nil {
 		return nil, err
 	}
 	recipientPub := bigchain.DefaultGetTxSender(tx)
-	senderShares := totalShares - recipientShares
-	if senderShares < 0 {
-		return nil, ErrorAppend(ErrCriteriaNotMet, "cannot transfer this many shares")
+	tx, err = api.transferTx(metadata, recipientPub, recipientShares, recordingRightId)
+	if err != nil {
+		return nil, err
 	}
-	if senderShares == 0 {
-		tx = bigchain.IndividualTransferTx(recipientShares, recordingRightId, txId, output, recipientPub, api.pub)
-	} else {
-		tx = bigchain.DivisibleTransferTx([]int{senderShares, recipientShares}, recordingRightId, txId, output, []crypto.PublicKey{api.pub, recipientPub}, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "", nil)
 	}
 	bigchain.FulfillTx(tx, api.priv)
-	txId, err = bigchain.PostTx(tx)
+	txId, err := api.cli.PostTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return api.RecordingRightTransfer(recipientId, releaseId, txId)
+}
+
+// Transfer model for a TRANSFER tx the party sent, the second round of
+// a transfer signed client-side
+
+func (api *Api) RecordingRightTransfer(recipientId, releaseId, txId string) (Data, error) {
+	recordingRightId, err := api.sentTransfer(txId)
 	if err != nil {
 		return nil, err
 	}
 	recordingRightTransfer := spec.NewRecordingRightTransfer(recipientId, recordingRightId, releaseId, api.partyId, txId)
-	tx = bigchain.DefaultIndividualCreateTx(recordingRightTransfer, api.pub)
+	tx := api.cli.DefaultIndividualCreateTx(recordingRightTransfer, nil, api.pub)
+	if api.priv == nil {
+		return prepareTx(tx, "recordingRightTransfer", recordingRightTransfer)
+	}
 	bigchain.FulfillTx(tx, api.priv)
-	id, err := bigchain.PostTx(tx)
+	id, err := api.cli.PostTx(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -818,3 +1345,63 @@ func (api *Api) TransferRecordingRight(recipientId string, recipientShares int,
 		"recordingRightTransfer": recordingRightTransfer,
 	}, nil
 }
+
+// Right id of a TRANSFER tx sent by the party
+
+func (api *Api) sentTransfer(txId string) (string, error) {
+	// The TRANSFER may still be in the node's backlog
+	if err := api.cli.WaitForCommit(txId, bigchain.DEFAULT_TIMEOUT); err != nil {
+		return "", err
+	}
+	tx, err := api.cli.GetTx(txId)
+	if err != nil {
+		return "", err
+	}
+	if bigchain.GetTxOperation(tx) != bigchain.TRANSFER {
+		return "", ErrorAppend(ErrInvalidType, "expected TRANSFER tx")
+	}
+	for _, senders := range bigchain.GetTxSenders(tx) {
+		if len(senders) != 1 || !api.pub.Equals(senders[0]) {
+			return "", ErrorAppend(ErrCriteriaNotMet, "party did not send TRANSFER tx")
+		}
+	}
+	return bigchain.GetTxAssetId(tx), nil
+}
+
+// Unsigned TRANSFER tx of recipientShares from the outputs of the right
+// held by the party. If the shares are spread over several outputs,
+// they're consolidated into one transfer
+
+func (api *Api) transferTx(metadata Data, recipientPub crypto.PublicKey, recipientShares int, rightId string) (Data, error) {
+	if recipientShares <= 0 {
+		return nil, ErrorAppend(ErrCriteriaNotMet, "recipient shares must be greater than 0")
+	}
+	right, err := ld.QueryHeldRight(api.cli, api.pub, rightId)
+	if err != nil {
+		return nil, err
+	}
+	held := right.Get("outputs").([]Data)
+	n := len(held)
+	consumeAmounts := make([]int, n)
+	consumeIds := make([]string, n)
+	outputs := make([]int, n)
+	for i, output := range held {
+		consumeAmounts[i] = output.GetInt("amount")
+		consumeIds[i] = output.GetStr("txid")
+		outputs[i] = output.GetInt("output")
+	}
+	senderShares := right.GetInt("shares") - recipientShares
+	if senderShares < 0 {
+		return nil, ErrorAppend(ErrCriteriaNotMet, "cannot transfer this many shares")
+	}
+	if n == 1 {
+		if senderShares == 0 {
+			return api.cli.IndividualTransferTx(recipientShares, rightId, consumeIds[0], metadata, outputs[0], recipientPub, api.pub), nil
+		}
+		return api.cli.DivisibleTransferTx([]int{senderShares, recipientShares}, rightId, consumeIds[0], metadata, outputs[0], []crypto.PublicKey{api.pub, recipientPub}, api.pub), nil
+	}
+	if senderShares == 0 {
+		return api.cli.ConsolidatedTransferTx([]int{recipientShares}, rightId, consumeAmounts, consumeIds, metadata, outputs, []crypto.PublicKey{recipientPub}, api.pub), nil
+	}
+	return api.cli.ConsolidatedTransferTx([]int{senderShares, recipientShares}, rightId, consumeAmounts, consumeIds, metadata, outputs, []crypto.PublicKey{api.pub, recipientPub}, api.pub), nil
+}
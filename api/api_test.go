@@ -1,9 +1,22 @@
 package api
 
 import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/zbo14/envoke/bigchain"
 	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/crypto/ed25519"
+	"github.com/zbo14/envoke/crypto/keystore"
+	ld "github.com/zbo14/envoke/linked_data"
+	"github.com/zbo14/envoke/royalty"
+	"github.com/zbo14/envoke/spec"
 )
 
 func GetId(data Data) string {
@@ -15,23 +28,37 @@ func GetPrivateKey(data Data) string {
 }
 
 func TestApi(t *testing.T) {
-	api := NewApi()
+	server := httptest.NewServer(bigchain.NewLedger())
+	defer server.Close()
+	cli := bigchain.NewClient(server.URL + "/")
+	api := NewApi(cli)
+	dir, err := ioutil.TempDir("", "envoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	index, err := ld.OpenIndex(dir + "/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	cli.SetCache(index)
 	output := MustOpenWriteFile("output.json")
-	composer, err := api.Register("composer@email.com", "", "", nil, "composer", "itsasecret", "/Users/zach/Desktop/envoke/composer", "", "www.composer.com", "Person")
+	composer, err := api.Register("composer@email.com", "", "", nil, nil, "composer", "itsasecret", "", "www.composer.com", "Person")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, composer)
 	composerId := GetId(composer)
 	composerPriv := GetPrivateKey(composer)
-	recordLabel, err := api.Register("record_label@email.com", "", "", nil, "record_label", "shhhh", "/Users/zach/Desktop/envoke/record_label", "", "www.record_label.com", "Organization")
+	recordLabel, err := api.Register("record_label@email.com", "", "", nil, nil, "record_label", "shhhh", "", "www.record_label.com", "Organization")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, recordLabel)
 	recordLabelId := GetId(recordLabel)
 	recordLabelPriv := GetPrivateKey(recordLabel)
-	performer, err := api.Register("performer@email.com", "123456789", "", nil, "performer", "makeitup", "/Users/zach/Desktop/envoke/performer", "ASCAP", "www.performer.com", "MusicGroup")
+	performer, err := api.Register("performer@email.com", "123456789", "", nil, nil, "performer", "makeitup", "ASCAP", "www.performer.com", "MusicGroup")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -44,140 +71,915 @@ func TestApi(t *testing.T) {
 	// }
 	// WriteJSON(output, producer)
 	// producerId := GetId(producer)
-	publisher, err := api.Register("publisher@email.com", "", "", nil, "publisher", "didyousaysomething?", "/Users/zach/Desktop/envoke/publisher", "", "www.soundcloud_page.com", "MusicGroup")
+	publisher, err := api.Register("publisher@email.com", "", "", nil, nil, "publisher", "didyousaysomething?", "", "www.soundcloud_page.com", "MusicGroup")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, publisher)
 	publisherId := GetId(publisher)
 	publisherPriv := GetPrivateKey(publisher)
-	radio, err := api.Register("radio@email.com", "", "", nil, "radio", "waves", "/Users/zach/Desktop/envoke/radio", "", "www.radio_station.com", "Organization")
+	radio, err := api.Register("radio@email.com", "", "", nil, nil, "radio", "waves", "", "www.radio_station.com", "Organization")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, radio)
 	radioId := GetId(radio)
-	if err = api.Login(composerId, composerPriv); err != nil {
+	if api, err = api.Login(composerId, composerPriv); err != nil {
 		t.Fatal(err)
 	}
-	composition, err := api.Compose("B3107S", "T-034.524.680-1", "EN", "www.url_to_composition.com", "untitled")
+	composition, err := api.Compose("B3107S", "T-034.524.680-1", "EN", spec.NewCreateMetadata("envoke", "0.1"), "www.url_to_composition.com", "untitled")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, composition)
 	compositionId := GetId(composition)
-	composerRight, err := api.CompositionRight(composerId, 20, []string{"GB", "US"}, "2020-01-01", "2096-01-01")
+	compositions, err := api.Search("untitled", 1, "composition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compositions) != 1 || GetId(compositions[0]) != compositionId {
+		t.Error("Expected composition from search")
+	}
+	parties, err := api.Search("publisher", 0, "party")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parties) != 1 || GetId(parties[0]) != publisherId {
+		t.Error("Expected publisher from search")
+	}
+	metadata, err := ld.QueryMetadata(api.cli, compositionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.GetClient(metadata) != "envoke" {
+		t.Error("Expected create metadata with client")
+	}
+	composerRight, err := api.CompositionRight(nil, nil, composerId, 20, []string{"GB", "US"}, "2020-01-01", "2096-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, composerRight)
 	composerRightId := GetId(composerRight)
-	publisherRight, err := api.CompositionRight(publisherId, 80, []string{"GB", "US"}, "2020-01-01", "2096-01-01")
+	publisherRight, err := api.CompositionRight(nil, nil, publisherId, 80, []string{"GB", "US"}, "2020-01-01", "2096-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, publisherRight)
 	publisherRightId := GetId(publisherRight)
-	publication, err := api.Publish([]string{compositionId}, []string{composerRightId, publisherRightId}, publisherId, "publication_title")
+	publication, err := api.Publish([]string{compositionId}, []string{composerRightId, publisherRightId}, nil, publisherId, "publication_title")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, publication)
 	publicationId := GetId(publication)
-	if err = api.Login(publisherId, publisherPriv); err != nil {
+	if api, err = api.Login(publisherId, publisherPriv); err != nil {
 		t.Fatal(err)
 	}
-	mechanicalLicense, err := api.MechanicalLicense(nil, publisherRightId, "", publicationId, performerId, []string{"US"}, nil, "2020-01-01", "2024-01-01")
+	mechanicalLicense, err := api.MechanicalLicense(nil, publisherRightId, "", nil, publicationId, performerId, []string{"US"}, nil, "2020-01-01", "2024-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, mechanicalLicense)
 	mechanicalLicenseId := GetId(mechanicalLicense)
-	file, err := OpenFile(Getenv("PATH_TO_AUDIO_FILE"))
-	if err != nil {
-		t.Fatal(err)
+	// Only licenses issued to the performer reach its handler
+	dispatcher := ld.NewDispatcher(api.cli)
+	var licenseIds []string
+	dispatcher.HandleLicensesTo(performerId, func(model, tx Data) error {
+		licenseIds = append(licenseIds, bigchain.GetId(tx))
+		return nil
+	})
+	for _, txId := range []string{compositionId, publisherRightId, mechanicalLicenseId} {
+		if err = dispatcher.Dispatch(Data{"transaction_id": txId}); err != nil {
+			t.Fatal(err)
+		}
 	}
-	if err = api.Login(performerId, performerPriv); err != nil {
+	if len(licenseIds) != 1 || licenseIds[0] != mechanicalLicenseId {
+		t.Error("Expected mechanical license dispatched to performer")
+	}
+	file := new(bytes.Buffer)
+	if api, err = api.Login(performerId, performerPriv); err != nil {
 		t.Fatal(err)
 	}
-	recording, err := api.Record(compositionId, "", "PT2M43S", file, "US-S1Z-99-00001", mechanicalLicenseId, performerId, "")
+	recording, err := api.Record(compositionId, "", "PT2M43S", file, "US-S1Z-99-00001", mechanicalLicenseId, nil, performerId, "")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, recording)
 	recordingId := GetId(recording)
-	performerRight, err := api.RecordingRight(performerId, 30, []string{"GB", "US"}, "2020-01-01", "2080-01-01")
+	if _, err = ld.ValidateRecording(cli, recordingId); err != nil {
+		t.Fatal(err)
+	}
+	if ids := index.LinkedIds("recordingOf", compositionId); len(ids) != 1 || ids[0] != recordingId {
+		t.Error("Expected recording of composition in index")
+	}
+	if ids := index.ModelIds("mechanical_license"); len(ids) != 1 || ids[0] != mechanicalLicenseId {
+		t.Error("Expected mechanical license in index")
+	}
+	// Validators read through the index
+	offline := bigchain.NewClient("http://127.0.0.1:1/")
+	offline.SetCache(index)
+	if _, err = ld.ValidateRecording(offline, recordingId); err != nil {
+		t.Fatal(err)
+	}
+	// Licenses are checked against the evaluation time
+	if _, _, err = ld.ValidateMechanicalLicenseAt(cli, mechanicalLicenseId, Date(2022, time.June, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ld.ValidateMechanicalLicenseAt(cli, mechanicalLicenseId, Date(2024, time.January, 2)); err == nil {
+		t.Error("Expected expired mechanical license")
+	}
+	if _, _, err = ld.ValidateMechanicalLicenseAt(cli, mechanicalLicenseId, Date(2019, time.December, 31)); err == nil {
+		t.Error("Expected mechanical license not yet valid")
+	}
+	if api, err = api.Login(publisherId, publisherPriv); err != nil {
+		t.Fatal(err)
+	}
+	earlyLicense, err := api.MechanicalLicense(nil, publisherRightId, "", nil, publicationId, performerId, []string{"US"}, nil, "2019-01-01", "2024-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ld.ValidateMechanicalLicenseAt(cli, GetId(earlyLicense), Date(2022, time.June, 1)); err == nil {
+		t.Error("Expected license window outside right window")
+	}
+	if api, err = api.Login(performerId, performerPriv); err != nil {
+		t.Fatal(err)
+	}
+	performerRight, err := api.RecordingRight(nil, nil, performerId, 30, []string{"GB", "US"}, "2020-01-01", "2080-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, performerRight)
 	performerRightId := GetId(performerRight)
-	recordLabelRight, err := api.RecordingRight(recordLabelId, 70, []string{"GB", "US"}, "2020-01-01", "2080-01-01")
+	recordLabelRight, err := api.RecordingRight(nil, nil, recordLabelId, 70, []string{"GB", "US"}, "2020-01-01", "2080-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, recordLabelRight)
 	recordLabelRightId := GetId(recordLabelRight)
-	release, err := api.Release([]string{recordingId}, []string{performerRightId, recordLabelRightId}, recordLabelId, "release_title")
+	release, err := api.Release(nil, []string{recordingId}, []string{performerRightId, recordLabelRightId}, recordLabelId, "release_title")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, release)
 	releaseId := GetId(release)
-	if err = api.Login(recordLabelId, recordLabelPriv); err != nil {
+	if api, err = api.Login(recordLabelId, recordLabelPriv); err != nil {
 		t.Fatal(err)
 	}
-	masterLicense, err := api.MasterLicense(radioId, nil, recordLabelRightId, "", releaseId, []string{"US"}, nil, "2020-01-01", "2022-01-01")
+	masterLicense, err := api.MasterLicense(nil, radioId, nil, recordLabelRightId, "", releaseId, []string{"US"}, nil, "2020-01-01", "2022-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, masterLicense)
-	// masterLicenseId := GetId(masterLicense)
-	if err = api.Login(composerId, composerPriv); err != nil {
+	masterLicenseId := GetId(masterLicense)
+	if api, err = api.Login(composerId, composerPriv); err != nil {
 		t.Fatal(err)
 	}
-	SleepSeconds(2)
-	compositionRightTransfer, err := api.TransferCompositionRight(composerRightId, "", publicationId, publisherId, 10)
+	if _, err = spec.NewTransferMetadata("contract.pdf", "01/01/2020", "assignment"); err == nil {
+		t.Error("Expected malformed effective date to be rejected")
+	}
+	metadata, err = spec.NewTransferMetadata("contract.pdf", "2020-01-01", "assignment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compositionRightTransfer, err := api.TransferCompositionRight(composerRightId, "", metadata, publicationId, publisherId, 10)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, compositionRightTransfer)
 	compositionRightTransferId := GetId(compositionRightTransfer)
-	if err = api.Login(publisherId, publisherPriv); err != nil {
+	metadata, err = ld.GetTransferMetadata(api.cli, compositionRightTransfer.GetData("compositionRightTransfer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.GetReason(metadata) != "assignment" || spec.GetEffectiveDate(metadata) != "2020-01-01" {
+		t.Error("Expected transfer metadata with reason and effective date")
+	}
+	if api, err = api.Login(publisherId, publisherPriv); err != nil {
 		t.Fatal(err)
 	}
-	SleepSeconds(2)
-	compositionRightTransfer, err = api.TransferCompositionRight(composerRightId, compositionRightTransferId, publicationId, composerId, 5)
+	compositionRightTransfer, err = api.TransferCompositionRight(composerRightId, compositionRightTransferId, nil, publicationId, composerId, 5)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, compositionRightTransfer)
 	compositionRightTransferId = GetId(compositionRightTransfer)
-	if err = api.Login(performerId, performerPriv); err != nil {
+	if api, err = api.Login(performerId, performerPriv); err != nil {
 		t.Fatal(err)
 	}
-	SleepSeconds(2)
-	recordingRightTransfer, err := api.TransferRecordingRight(recordLabelId, 10, performerRightId, "", releaseId)
+	recordingRightTransfer, err := api.TransferRecordingRight(nil, recordLabelId, 10, performerRightId, "", releaseId)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, recordingRightTransfer)
 	recordingRightTransferId := GetId(recordingRightTransfer)
-	if err = api.Login(recordLabelId, recordLabelPriv); err != nil {
+	if api, err = api.Login(recordLabelId, recordLabelPriv); err != nil {
 		t.Fatal(err)
 	}
-	SleepSeconds(2)
-	recordingRightTransfer, err = api.TransferRecordingRight(performerId, 5, performerRightId, recordingRightTransferId, releaseId)
+	recordingRightTransfer, err = api.TransferRecordingRight(nil, performerId, 5, performerRightId, recordingRightTransferId, releaseId)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, recordingRightTransfer)
-	if err = api.Login(composerId, composerPriv); err != nil {
+	if api, err = api.Login(composerId, composerPriv); err != nil {
 		t.Fatal(err)
 	}
-	mechanicalLicenseFromTransfer, err := api.MechanicalLicense(nil, "", compositionRightTransferId, publicationId, radioId, []string{"US"}, nil, "2020-01-01", "2030-01-01")
+	mechanicalLicenseFromTransfer, err := api.MechanicalLicense(nil, "", compositionRightTransferId, nil, publicationId, radioId, []string{"US"}, nil, "2020-01-01", "2030-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, mechanicalLicenseFromTransfer)
+	// Composer's shares are now spread over two outputs
+	rights, err := api.HeldRights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := false
+	for _, right := range rights {
+		if right.GetStr("rightId") == composerRightId {
+			held = true
+			if shares, n := right.GetInt("shares"), len(right.Get("outputs").([]Data)); shares != 15 || n != 2 {
+				t.Errorf("Expected 15 shares in 2 outputs, got %d shares in %d outputs", shares, n)
+			}
+		}
+	}
+	if !held {
+		t.Error("Expected composer to hold composition right")
+	}
+	compositionRightTransfer, err = api.TransferCompositionRight(composerRightId, "", nil, publicationId, publisherId, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteJSON(output, compositionRightTransfer)
+	tx, err := api.cli.GetTx(spec.GetTxId(compositionRightTransfer.GetData("compositionRightTransfer")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(bigchain.GetTxInputs(tx)); n != 2 {
+		t.Errorf("Expected consolidated transfer with 2 inputs, got %d", n)
+	}
+	if _, err = ld.ValidateCompositionRightTransfer(api.cli, GetId(compositionRightTransfer)); err != nil {
+		t.Fatal(err)
+	}
+	// All of the remaining shares
+	if _, err = api.TransferCompositionRight(composerRightId, "", nil, publicationId, publisherId, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.TransferCompositionRight(composerRightId, "", nil, publicationId, publisherId, 1); err == nil {
+		t.Error("Expected transfer without shares to fail")
+	}
+	if rights, err = api.HeldRights(); err != nil {
+		t.Fatal(err)
+	}
+	for _, right := range rights {
+		if right.GetStr("rightId") == composerRightId {
+			t.Error("Expected composer to hold no shares in composition right")
+		}
+	}
+	// Composer and publisher co-own a right
+	coOwnedRight, err := api.CompositionRight([]string{publisherId}, nil, composerId, 5, []string{"GB", "US"}, "2020-01-01", "2096-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTxId := GetId(coOwnedRight)
+	if _, _, _, err = ld.ValidateRight(api.cli, createTxId); err != nil {
+		t.Fatal(err)
+	}
+	tx, err = api.cli.GetTx(composerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	composerPub := bigchain.DefaultGetTxSender(tx)
+	tx, err = api.cli.GetTx(publisherId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisherPub := bigchain.DefaultGetTxSender(tx)
+	if _, err = api.PrepareCoOwnedTransfer(createTxId, nil, 0, []string{publisherId}, []int{4}); err == nil {
+		t.Error("Expected transfer of part of the output to fail")
+	}
+	prepared, err := api.PrepareCoOwnedTransfer(createTxId, nil, 0, []string{publisherId}, []int{5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := api.SignTx(prepared.GetData("tx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.GetBool("sent") {
+		t.Error("Expected tx to wait for publisher's signature")
+	}
+	exported := MustMarshalJSON(signed.Get("tx"))
+	if api, err = api.Login(publisherId, publisherPriv); err != nil {
+		t.Fatal(err)
+	}
+	tx = make(Data)
+	MustUnmarshalJSON(exported, &tx)
+	if signed, err = api.SignTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	if !signed.GetBool("sent") {
+		t.Error("Expected tx signed by co-owners to be sent")
+	}
+	if _, err = api.cli.GetTx(GetId(signed)); err != nil {
+		t.Fatal(err)
+	}
+	// Composer's right ended up with the publisher, since the transfers
+	// without metadata apply at every time
+	ownership, err := ld.ResolveOwnership(api.cli, composerRightId, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	holders := ownership.Get("holders").([]Data)
+	if len(holders) != 1 || holders[0].GetStr("partyId") != publisherId || holders[0].GetInt("shares") != 20 {
+		t.Errorf("Expected publisher to hold 20 shares, got %v", holders)
+	}
+	// They're flagged, and the dated transfer isn't
+	undated := ownership.Get("inconsistencies").([]Data)
+	if len(undated) != 3 {
+		t.Errorf("Expected 3 undated transfers flagged, got %v", undated)
+	}
+	for _, inconsistency := range undated {
+		tx, err := api.cli.GetTx(inconsistency.GetStr("id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bigchain.GetTxMetadata(tx) != nil {
+			t.Errorf("Expected only undated transfers flagged, got %v", inconsistency)
+		}
+	}
+	// Before the first transfer took effect
+	if ownership, err = ld.ResolveOwnership(api.cli, composerRightId, Date(2019, time.December, 31)); err != nil {
+		t.Fatal(err)
+	}
+	holders = ownership.Get("holders").([]Data)
+	if len(holders) != 1 || holders[0].GetStr("partyId") != composerId || holders[0].GetInt("shares") != 20 {
+		t.Errorf("Expected composer to hold 20 shares, got %v", holders)
+	}
+	// Transfer model whose txId isn't a TRANSFER of the right
+	tx = api.cli.IndividualCreateTx(1, spec.NewCompositionRightTransfer(composerRightId, publicationId, publisherId, composerId, createTxId), nil, publisherPub, publisherPub)
+	if signed, err = api.SignTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	if ownership, err = ld.ResolveOwnership(api.cli, composerRightId, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	inconsistencies := ownership.Get("inconsistencies").([]Data)
+	flagged := false
+	for _, inconsistency := range inconsistencies {
+		flagged = flagged || inconsistency.GetStr("id") == GetId(signed)
+	}
+	if len(inconsistencies) != len(undated)+1 || !flagged {
+		t.Errorf("Expected inconsistent transfer model, got %v", inconsistencies)
+	}
+	// Provenance of the license issued under the composer's transfer
+	graph, err := ld.BuildProvenanceGraph(api.cli, GetId(mechanicalLicenseFromTransfer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]int)
+	for _, node := range graph.Nodes {
+		types[node.GetStr("type")]++
+	}
+	for _, _type := range []string{"composition", "composition_right_transfer", "mechanical_license", "party", "publication", "right", "transfer"} {
+		if types[_type] == 0 {
+			t.Errorf("Expected %s in provenance graph", _type)
+		}
+	}
+	evidence := false
+	for _, edge := range graph.Edges {
+		if edge.GetStr("field") == "compositionRightTransfer" && edge.GetStr("from") == GetId(mechanicalLicenseFromTransfer) {
+			evidence = edge.GetStr("txId") == GetId(mechanicalLicenseFromTransfer) && edge.GetStr("signer") == composerPub.String()
+		}
+	}
+	if !evidence {
+		t.Error("Expected license edge signed by composer")
+	}
+	if dot := graph.DOT(); !bytes.Contains([]byte(dot), []byte(Sprintf("%q -> ", GetId(mechanicalLicenseFromTransfer)))) {
+		t.Error("Expected license edges in DOT")
+	}
+	if items := graph.JSONLD().Get("@graph").([]Data); len(items) != len(graph.Nodes)+len(graph.Edges) {
+		t.Error("Expected JSON-LD nodes and links")
+	}
+	// Models that link to the composition are found by search
+	if graph, err = ld.BuildProvenanceGraph(api.cli, compositionId); err != nil {
+		t.Fatal(err)
+	}
+	reached := false
+	for _, node := range graph.Nodes {
+		reached = reached || node.GetStr("id") == masterLicenseId
+	}
+	if !reached {
+		t.Error("Expected master license in provenance graph of composition")
+	}
+	// An exclusive license overlapping the others in the US
+	exclusiveLicense, err := api.MechanicalLicense(nil, publisherRightId, "", nil, publicationId, radioId, []string{"GB", "US"}, []string{ld.USAGE_EXCLUSIVE, "stream"}, "2021-01-01", "2021-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis, err := ld.AnalyzeLicenses(api.cli, publicationId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if licenseIds := analysis.GetStrSlice("licenseIds"); len(licenseIds) != 4 {
+		t.Errorf("Expected 4 licenses of publication, got %d", len(licenseIds))
+	}
+	if invalid := analysis.GetDataSlice("invalid"); len(invalid) != 0 {
+		t.Errorf("Expected no invalid licenses, got %v", invalid)
+	}
+	overlaps := analysis.GetDataSlice("overlaps")
+	conflicts := 0
+	for _, overlap := range overlaps {
+		if overlap.GetBool("conflict") {
+			conflicts++
+			if ids := overlap.GetStrSlice("licenseIds"); ids[0] != GetId(exclusiveLicense) && ids[1] != GetId(exclusiveLicense) {
+				t.Error("Expected conflicts with exclusive license")
+			}
+		}
+	}
+	if len(overlaps) != 6 || conflicts != 3 {
+		t.Errorf("Expected 6 overlaps and 3 conflicts, got %d and %d", len(overlaps), conflicts)
+	}
+	// The composer transferred all its shares
+	analysis, err = ld.AnalyzeLicenses(api.cli, composerRightId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if licenseIds := analysis.GetStrSlice("licenseIds"); len(licenseIds) != 1 || licenseIds[0] != GetId(mechanicalLicenseFromTransfer) {
+		t.Errorf("Expected license issued under transfer, got %v", licenseIds)
+	}
+	if unheld := analysis.GetDataSlice("unheld"); len(unheld) != 1 || unheld[0].GetStr("senderId") != composerId {
+		t.Errorf("Expected license issued by composer without shares, got %v", unheld)
+	}
+	// Royalties for a usage report, split between the current holders
+	// of the composition and recording rights
+	usages, err := royalty.ReadCSVReport(bytes.NewBufferString("isrc,territory,period,revenue\nUSS1Z9900001,us,2026-09,100.00\nUS-S1Z-99-00001,FR,2026-09,5\nUS-S1Z-99-99999,GB,2026-09,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payouts, err := royalty.Calculate(api.cli, 50, usages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals := make(map[string]int64)
+	for _, statement := range payouts.GetDataSlice("statements") {
+		totals[statement.GetStr("partyId")] = statement.GetInt64("total")
+	}
+	if totals[publisherId] != 5000 || totals[recordLabelId] != 3750 || totals[performerId] != 1250 || len(totals) != 3 {
+		t.Errorf("Expected payouts to publisher, record label and performer, got %v", totals)
+	}
+	if unpaid := payouts.GetDataSlice("unpaid"); len(unpaid) != 3 {
+		t.Errorf("Expected unpaid revenue for territory and unknown isrc, got %v", unpaid)
+	}
+	// An undated transfer of the performer's shares applies now, so the
+	// record label gets paid for them
+	performerApi, err := api.Login(performerId, performerPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rights, err = performerApi.HeldRights(); err != nil {
+		t.Fatal(err)
+	}
+	performerShares := 0
+	for _, right := range rights {
+		if right.GetStr("rightId") == performerRightId {
+			performerShares = right.GetInt("shares")
+		}
+	}
+	if _, err = performerApi.TransferRecordingRight(nil, recordLabelId, performerShares, performerRightId, "", releaseId); err != nil {
+		t.Fatal(err)
+	}
+	if payouts, err = royalty.Calculate(api.cli, 50, usages); err != nil {
+		t.Fatal(err)
+	}
+	totals = make(map[string]int64)
+	for _, statement := range payouts.GetDataSlice("statements") {
+		totals[statement.GetStr("partyId")] = statement.GetInt64("total")
+	}
+	if totals[publisherId] != 5000 || totals[recordLabelId] != 5000 || len(totals) != 2 {
+		t.Errorf("Expected payouts to publisher and record label, got %v", totals)
+	}
+	// Proofs carry their salt and parameters, so they verify
+	// after a round trip through JSON, e.g. in another process
+	challenge := Base64UrlEncode([]byte("challenge"))
+	proof, err := ld.ProvePublisher(api.cli, challenge, api.priv, publicationId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(Data)
+	if err = UnmarshalJSON(MustMarshalJSON(proof), &received); err != nil {
+		t.Fatal(err)
+	}
+	if err = ld.VerifyProof(api.cli, received); err != nil {
+		t.Error(err)
+	}
+	received.Set("role", ld.ROLE_COMPOSER)
+	if err = ld.VerifyProof(api.cli, received); err == nil {
+		t.Error("Expected invalid proof for another role")
+	}
+	received.Set("role", ld.ROLE_PUBLISHER)
+	received.Set("salt", Base64UrlEncode([]byte("salt")))
+	if err = ld.VerifyPublisher(api.cli, received); err == nil {
+		t.Error("Expected invalid proof with another salt")
+	}
+	// Bundles verify without the ledger
+	bundle, err := ld.BundleProof(api.cli, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received = make(Data)
+	if err = UnmarshalJSON(MustMarshalJSON(bundle), &received); err != nil {
+		t.Fatal(err)
+	}
+	if err = ld.VerifyBundle(received); err != nil {
+		t.Error(err)
+	}
+	txs := received.GetInterfaceSlice("txs")
+	if len(txs) < 4 {
+		t.Errorf("Expected parties, composition and publication in bundle, got %d txs", len(txs))
+	}
+	received.Set("txs", txs[1:])
+	if err = ld.VerifyBundle(received); err == nil {
+		t.Error("Expected invalid bundle without tx")
+	}
+	// An unsigned tx whose id matches its body
+	unsigned := make(Data)
+	MustUnmarshalJSON(MustMarshalJSON(txs[0]), &unsigned)
+	unsigned.Set("inputs", []Data{})
+	unsigned.Set("id", bigchain.ComputeTxId(unsigned))
+	received.Set("txs", append([]interface{}{unsigned}, txs[1:]...))
+	if err = ld.VerifyBundle(received); err == nil {
+		t.Error("Expected invalid bundle with unsigned tx")
+	}
+	// Re-signed by another key, with the same owners before
+	resigned := make(Data)
+	MustUnmarshalJSON(MustMarshalJSON(txs[0]), &resigned)
+	inputs := bigchain.GetTxInputs(resigned)
+	for _, input := range inputs {
+		input.Clear("fulfillment")
+	}
+	resigned.Set("inputs", inputs)
+	otherPriv, _ := ed25519.GenerateKeypair()
+	bigchain.FulfillTx(resigned, otherPriv)
+	if bigchain.GetId(resigned) != bigchain.GetId(AssertData(txs[0])) {
+		t.Fatal("Expected re-signed tx to keep its id")
+	}
+	received.Set("txs", append([]interface{}{resigned}, txs[1:]...))
+	if err = ld.VerifyBundle(received); err == nil {
+		t.Error("Expected invalid bundle with tx signed by non-owner")
+	}
+	bigchain.GetTxData(AssertData(txs[0])).Set("name", "name")
+	received.Set("txs", txs)
+	if err = ld.VerifyBundle(received); err == nil {
+		t.Error("Expected invalid bundle with altered tx")
+	}
+	if api, err = api.Login(composerId, composerPriv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ld.ProvePublisher(api.cli, challenge, api.priv, publicationId); err == nil {
+		t.Error("Expected composer cannot prove publisher")
+	}
+	if proof, err = ld.ProveComposer(api.cli, challenge, compositionId, api.priv); err != nil {
+		t.Fatal(err)
+	}
+	proof.GetData("balloon").Set("sCost", ld.MAX_BALLOON_S_COST+1)
+	if err = ld.VerifyComposer(api.cli, proof); err == nil {
+		t.Error("Expected balloon parameters out of range")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	server := httptest.NewServer(bigchain.NewLedger())
+	defer server.Close()
+	api := NewApi(bigchain.NewClient(server.URL + "/"))
+	dir, err := ioutil.TempDir("", "envoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	api.SetKeystoreDir(dir)
+	// Uploads the encrypted credentials, or plaintext ones as
+	// written before keystores, which come back encrypted
+	login := func(name string, plaintext bool) (*http.Cookie, Data) {
+		credentials, err := api.Register(name+"@email.com", "", "", nil, nil, name, name, "", "", "Person")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		part, err := form.CreateFormFile("credentials", "credentials.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext {
+			WriteJSON(part, Data{"id": GetId(credentials), "privateKey": GetPrivateKey(credentials)})
+		} else {
+			part.Write(MustReadFile(dir + "/" + GetId(credentials) + ".json"))
+		}
+		form.WriteField("password", name)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/login_handler", body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		api.LoginHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatal(w.Body.String())
+		}
+		res := make(Data)
+		if err = ReadJSON(w.Body, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.GetStr("partyId") != GetId(credentials) {
+			t.Errorf("Expected session for %s", name)
+		}
+		if migrated := res.GetMapData("keystore"); plaintext != (migrated != nil) {
+			t.Errorf("Expected encrypted credentials only for plaintext upload, got %v", migrated)
+		} else if migrated != nil {
+			if partyId, _, err := keystore.Decrypt(migrated, name); err != nil || partyId != GetId(credentials) {
+				t.Error("Expected keystore encrypted under password")
+			}
+		}
+		return w.Result().Cookies()[0], res
+	}
+	composerCookie, composer := login("composer", false)
+	_, publisher := login("publisher", true)
+	if bytes.Contains(MustReadFile(dir+"/"+composer.GetStr("partyId")+".json"), []byte("privateKey")) {
+		t.Error("Expected credentials file to be encrypted")
+	}
+	if composerCookie.SameSite != http.SameSiteStrictMode || composerCookie.Secure {
+		t.Error("Expected strict session cookie, not secure over plain HTTP")
+	}
+	if !sessionCookie(httptest.NewRequest(http.MethodPost, "https://localhost/login_handler", nil), "").Secure {
+		t.Error("Expected secure session cookie over TLS")
+	}
+	// Each request signs as the party of its own session
+	req := httptest.NewRequest(http.MethodGet, "/held_rights_handler", nil)
+	req.AddCookie(composerCookie)
+	if user, err := api.Session(req); err != nil || user.partyId != composer.GetStr("partyId") {
+		t.Error("Expected composer session from cookie")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/held_rights_handler", nil)
+	req.Header.Set("Authorization", "Bearer "+publisher.GetStr("token"))
+	if user, err := api.Session(req); err != nil || user.partyId != publisher.GetStr("partyId") {
+		t.Error("Expected publisher session from bearer token")
+	}
+	if api.LoggedIn() {
+		t.Error("Expected no party logged in to the shared api")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/held_rights_handler", nil)
+	req.Header.Set("Authorization", "Bearer "+publisher.GetStr("token")+"x")
+	if _, err = api.Session(req); err == nil {
+		t.Error("Expected forged token rejected")
+	}
+	w := httptest.NewRecorder()
+	api.HeldRightsHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized, got %d", w.Code)
+	}
+	// Logout ends the session
+	req = httptest.NewRequest(http.MethodPost, "/logout_handler", nil)
+	req.AddCookie(composerCookie)
+	w = httptest.NewRecorder()
+	api.LogoutHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Body.String())
+	}
+	if cookie := w.Result().Cookies()[0]; cookie.MaxAge >= 0 || cookie.SameSite != http.SameSiteStrictMode {
+		t.Error("Expected logout to clear the strict session cookie")
+	}
+	if _, err = api.Session(req); err == nil {
+		t.Error("Expected no session after logout")
+	}
+	// Sessions expire
+	api.SetSessionTTL(0)
+	_, publisher = login("publisher2", false)
+	req.Header.Set("Authorization", "Bearer "+publisher.GetStr("token"))
+	if _, err = api.Session(req); err == nil {
+		t.Error("Expected expired session")
+	}
+}
+
+func TestClientSigning(t *testing.T) {
+	server := httptest.NewServer(bigchain.NewLedger())
+	defer server.Close()
+	api := NewApi(bigchain.NewClient(server.URL + "/"))
+	// The private key stays with the client
+	priv, pub := ed25519.GenerateKeypair()
+	finalize := func(prepared Data) string {
+		received := make(Data)
+		MustUnmarshalJSON(MustMarshalJSON(prepared), &received)
+		if received.GetBool("sent") {
+			t.Fatal("Expected unsigned tx")
+		}
+		var sigs []string
+		for _, msg := range received.GetInterfaceSlice("messages") {
+			sigs = append(sigs, priv.Sign(MustBase64UrlDecode(AssertStr(msg))).String())
+		}
+		sent, err := api.FinalizeTx(received.GetData("tx"), sigs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return GetId(sent)
+	}
+	prepared, err := api.PrepareRegister("client@email.com", "", "", nil, nil, "client", "", pub, "", "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.FinalizeTx(prepared.GetData("tx"), []string{ed25519.NewSignature(make([]byte, 64)).String()}); err == nil {
+		t.Error("Expected invalid signature to be rejected")
+	}
+	partyId := finalize(prepared)
+	challenge, err := api.LoginChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := priv.Sign([]byte(challenge)).String()
+	user, err := api.LoginWithSignature(partyId, challenge, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.LoginWithSignature(partyId, challenge, sig); err == nil {
+		t.Error("Expected challenge to be used once")
+	}
+	if prepared, err = user.Compose("", "", "EN", nil, "", "untitled"); err != nil {
+		t.Fatal(err)
+	}
+	compositionId := finalize(prepared)
+	if _, err = ld.ValidateComposition(api.cli, compositionId); err != nil {
+		t.Fatal(err)
+	}
+	// Proofs are signed over the hash
+	challenge = Base64UrlEncode([]byte("challenge"))
+	prepared, err = user.PrepareProof(challenge, ld.ROLE_COMPOSER, compositionId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := prepared.GetData("proof")
+	if _, err = user.FinalizeProof(proof, priv.Sign([]byte(challenge)).String()); err == nil {
+		t.Error("Expected signature of another message to be rejected")
+	}
+	if proof, err = user.FinalizeProof(proof, priv.Sign(MustBase64UrlDecode(prepared.GetStr("hash"))).String()); err != nil {
+		t.Fatal(err)
+	}
+	if err = ld.VerifyComposer(api.cli, proof); err != nil {
+		t.Fatal(err)
+	}
+	// The client co-owns a right with a party that signs server-side
+	publisher, err := api.Register("publisher@email.com", "", "", nil, nil, "publisher", "itsasecret", "", "", "Organization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisherId := GetId(publisher)
+	if prepared, err = user.CompositionRight([]string{publisherId}, nil, partyId, 10, []string{"US"}, "2020-01-01", "2096-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	coOwnedRightId := finalize(prepared)
+	metadata, err := spec.NewTransferMetadata("", "2020-01-01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepared, err = user.PrepareCoOwnedTransfer(coOwnedRightId, metadata, 0, []string{partyId}, []int{10}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := user.SignTx(prepared.GetData("tx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sigs []string
+	for _, msg := range signed.GetStrSlice("messages") {
+		sigs = append(sigs, priv.Sign(MustBase64UrlDecode(msg)).String())
+	}
+	if signed, err = user.SignTxWithSignatures(signed.GetData("tx"), sigs); err != nil {
+		t.Fatal(err)
+	}
+	if signed.GetBool("sent") {
+		t.Error("Expected tx to wait for publisher's signature")
+	}
+	publisherApi, err := api.Login(publisherId, GetPrivateKey(publisher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed, err = publisherApi.SignTx(signed.GetData("tx")); err != nil {
+		t.Fatal(err)
+	}
+	if !signed.GetBool("sent") {
+		t.Error("Expected tx signed by co-owners to be sent")
+	}
+	// Transfers take two rounds
+	if prepared, err = user.TransferCompositionRight(coOwnedRightId, "", metadata, "", publisherId, 4); err != nil {
+		t.Fatal(err)
+	}
+	txId := finalize(prepared)
+	if prepared, err = user.CompositionRightTransfer("", publisherId, txId); err != nil {
+		t.Fatal(err)
+	}
+	if spec.GetTxId(prepared.GetData("compositionRightTransfer")) != txId {
+		t.Error("Expected transfer model to link to TRANSFER tx")
+	}
+	finalize(prepared)
+	if _, err = publisherApi.CompositionRightTransfer("", partyId, txId); err == nil {
+		t.Error("Expected transfer model for another party's TRANSFER to fail")
+	}
+	rights, err := publisherApi.HeldRights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rights) != 1 || rights[0].GetInt("shares") != 4 {
+		t.Errorf("Expected publisher to hold 4 shares, got %v", rights)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	server := httptest.NewServer(bigchain.NewLedger())
+	defer server.Close()
+	api := NewApi(bigchain.NewClient(server.URL + "/"))
+	dir, err := ioutil.TempDir("", "envoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	composer, err := api.Register("composer@email.com", "", "", nil, nil, "composer", "itsasecret", "", "", "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	composerId := GetId(composer)
+	if partyId, _, err := keystore.Decrypt(composer.GetData("keystore"), "itsasecret"); err != nil || partyId != composerId {
+		t.Error("Expected registration to return the keystore")
+	}
+	if _, err = os.Stat(dir + "/" + composerId + ".json"); err == nil {
+		t.Error("Expected no keystore saved without keystore directory")
+	}
+	mnemonic := composer.GetStr("mnemonic")
+	if err = api.Recover(mnemonic, composerId, "newsecret"); err == nil {
+		t.Error("Expected recovery without keystore directory to fail")
+	}
+	api.SetKeystoreDir(dir)
+	if err = api.Recover(mnemonic, "../"+composerId, "newsecret"); err == nil {
+		t.Error("Expected party id with a path to be rejected")
+	}
+	if err = api.Recover(mnemonic, composerId, "newsecret"); err != nil {
+		t.Fatal(err)
+	}
+	partyId, priv, err := keystore.Load(dir+"/"+composerId+".json", "newsecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partyId != composerId || priv.(*ed25519.PrivateKey).String() != GetPrivateKey(composer) {
+		t.Error("Expected private key recovered from mnemonic")
+	}
+	// The mnemonic must be for the party's key
+	other, err := api.Register("other@email.com", "", "", nil, nil, "other", "itsasecret", "", "", "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = api.Recover(other.GetStr("mnemonic"), composerId, "newsecret"); err == nil {
+		t.Error("Expected mnemonic for another key to fail")
+	}
+}
+
+func TestDelayedCommit(t *testing.T) {
+	ledger := bigchain.NewLedger()
+	server := httptest.NewServer(ledger)
+	defer server.Close()
+	api := NewApi(bigchain.NewClient(server.URL + "/"))
+	composer, err := api.Register("composer@email.com", "", "", nil, nil, "composer", "itsasecret", "", "", "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	composerId := GetId(composer)
+	publisher, err := api.Register("publisher@email.com", "", "", nil, nil, "publisher", "didyousaysomething?", "", "", "Organization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisherId := GetId(publisher)
+	if api, err = api.Login(composerId, GetPrivateKey(composer)); err != nil {
+		t.Fatal(err)
+	}
+	composerRight, err := api.CompositionRight(nil, nil, composerId, 20, []string{"US"}, "2020-01-01", "2096-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	composerRightId := GetId(composerRight)
+	// Txs posted from here on sit in the backlog, as on a node in async mode
+	ledger.SetCommitDelay(time.Second)
+	compositionRightTransfer, err := api.TransferCompositionRight(composerRightId, "", nil, "", publisherId, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := compositionRightTransfer.GetData("compositionRightTransfer")
+	if spec.GetCompositionRightId(model) != composerRightId {
+		t.Error("Expected transfer model to link the right")
+	}
+	if _, err = api.cli.GetTx(GetId(compositionRightTransfer)); err == nil {
+		t.Error("Expected transfer model to be in the backlog")
+	}
+	if err = api.cli.WaitForCommit(GetId(compositionRightTransfer), bigchain.DEFAULT_TIMEOUT); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := api.cli.GetTx(spec.GetTxId(model))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bigchain.GetTxAssetId(tx) != composerRightId {
+		t.Error("Expected TRANSFER of the right")
+	}
 }
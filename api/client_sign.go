@@ -0,0 +1,226 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/crypto/crypto"
+	"github.com/zbo14/envoke/crypto/ed25519"
+	ld "github.com/zbo14/envoke/linked_data"
+	"github.com/zbo14/envoke/spec"
+)
+
+// Client-side signing
+//
+// A party can log in by signing a challenge, so the server only holds its
+// public key. Models it creates are returned unsigned, as
+// Data{model, "messages", "sent", "tx"}; the client signs each message
+// (base64url) and sends the base58 signatures with the tx to finalize
+// Transfers take two rounds, since the transfer model links to the id of
+// the TRANSFER tx: the TRANSFER is returned unsigned without a model, and
+// once it's finalized the client sends its id for the transfer model
+// Co-owners sign exported txs with SignTxWithSignatures, and proofs are
+// returned with the hash the client signs, as Data{"hash", "proof"}
+
+func prepareTx(tx Data, key string, model Data) (Data, error) {
+	msgs, err := bigchain.TxMessages(tx)
+	if err != nil {
+		return nil, err
+	}
+	prepared := Data{
+		"messages": encodeMessages(msgs),
+		"sent":     false,
+		"tx":       tx,
+	}
+	if model != nil {
+		prepared.Set(key, model)
+	}
+	return prepared, nil
+}
+
+func encodeMessages(msgs [][]byte) []string {
+	encoded := make([]string, len(msgs))
+	for i, msg := range msgs {
+		encoded[i] = Base64UrlEncode(msg)
+	}
+	return encoded
+}
+
+func decodeSignatures(sigstrs []string) ([]crypto.Signature, error) {
+	sigs := make([]crypto.Signature, len(sigstrs))
+	for i, sigstr := range sigstrs {
+		sig := new(ed25519.Signature)
+		if err := sig.FromString(sigstr); err != nil {
+			return nil, ErrorAppend(ErrInvalidSignature, sigstr)
+		}
+		sigs[i] = sig
+	}
+	return sigs, nil
+}
+
+// Unsigned tx with a new party, for registering without a server-side key
+
+func (api *Api) PrepareRegister(email, ipi, isni string, memberIds []string, metadata Data, name, pro string, pub crypto.PublicKey, sameAs, _type string) (Data, error) {
+	party := spec.NewParty(email, ipi, isni, memberIds, name, pro, sameAs, _type)
+	tx := api.cli.DefaultIndividualCreateTx(party, metadata, pub)
+	return prepareTx(tx, "party", party)
+}
+
+// Attaches a client's signatures of the tx messages and sends the tx
+
+func (api *Api) FinalizeTx(tx Data, sigstrs []string) (Data, error) {
+	sigs, err := decodeSignatures(sigstrs)
+	if err != nil {
+		return nil, err
+	}
+	if err = bigchain.FulfillTxWithSignatures(tx, sigs); err != nil {
+		return nil, err
+	}
+	id, err := api.cli.PostTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	api.logger.Info("SUCCESS sent tx signed client-side")
+	return Data{
+		"id":   id,
+		"sent": true,
+		"tx":   tx,
+	}, nil
+}
+
+// Adds the party's signatures of the messages SignTx returned for an
+// exported tx with co-owned inputs, then sends it as SignTx does
+
+func (api *Api) SignTxWithSignatures(tx Data, sigstrs []string) (Data, error) {
+	sigs, err := decodeSignatures(sigstrs)
+	if err != nil {
+		return nil, err
+	}
+	if err = bigchain.PartiallyFulfillTxWithSignatures(tx, api.pub, sigs); err != nil {
+		return nil, err
+	}
+	return api.sendSignedTx(tx)
+}
+
+// Unsigned proof of the party's role for a model, with any other fields
+// the role needs, e.g. "publicationId", and the hash the client signs
+
+func (api *Api) PrepareProof(challenge, role, modelId string, fields Data) (Data, error) {
+	proof := ld.NewProof(challenge, role, modelId)
+	for k, v := range fields {
+		proof.Set(k, v)
+	}
+	hash, err := ld.ProofHash(proof)
+	if err != nil {
+		return nil, err
+	}
+	return Data{
+		"hash":  Base64UrlEncode(hash),
+		"proof": proof,
+	}, nil
+}
+
+// Attaches the client's signature of the hash from PrepareProof,
+// returning the proof once it verifies
+
+func (api *Api) FinalizeProof(proof Data, sigstr string) (Data, error) {
+	proof.Set("signature", sigstr)
+	if err := ld.VerifyProof(api.cli, proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+func (api *Api) LoginChallenge() (string, error) {
+	return api.sessions.challenge()
+}
+
+// Returns a copy of the api for the party that signed the challenge,
+// without its private key
+
+func (api *Api) LoginWithSignature(partyId, challenge, sigstr string) (*Api, error) {
+	sig := new(ed25519.Signature)
+	if err := sig.FromString(sigstr); err != nil {
+		return nil, ErrorAppend(ErrInvalidSignature, sigstr)
+	}
+	tx, err := ld.QueryAndValidateModel(api.cli, partyId, "party")
+	if err != nil {
+		return nil, err
+	}
+	pub := bigchain.DefaultGetTxSender(tx)
+	if !pub.Verify([]byte(challenge), sig) {
+		return nil, ErrorAppend(ErrInvalidSignature, sigstr)
+	}
+	if err = api.sessions.useChallenge(challenge); err != nil {
+		return nil, err
+	}
+	user := *api
+	user.partyId = partyId
+	user.priv = nil
+	user.pub = pub
+	partyName := spec.GetName(bigchain.GetTxData(tx))
+	api.logger.Info(Sprintf("SUCCESS %s is logged in, signing client-side", partyName))
+	return &user, nil
+}
+
+func (api *Api) LoginChallengeHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, ErrExpectedGet.Error(), http.StatusBadRequest)
+		return
+	}
+	challenge, err := api.LoginChallenge()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, Data{"challenge": challenge})
+}
+
+// Logs in with "partyId", a "challenge" from the challenge handler and
+// the party's base58 "signature" of it
+
+func (api *Api) LoginSignatureHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := UrlValues(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := api.LoginWithSignature(values.Get("partyId"), values.Get("challenge"), values.Get("signature"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.writeSession(w, req, user, nil)
+}
+
+// Sends a tx from the prepare step, passed as JSON in "tx", with the
+// comma-separated signatures of its messages. No session is needed
+// since the signatures must be from the owners before
+
+func (api *Api) FinalizeHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := UrlValues(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tx := make(Data)
+	if err = UnmarshalJSON([]byte(values.Get("tx")), &tx); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sent, err := api.FinalizeTx(tx, SplitStr(values.Get("signatures"), ","))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, sent)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/zbo14/envoke/common"
+)
+
+const (
+	CHALLENGE_TTL  = 5 * time.Minute
+	SESSION_COOKIE = "envoke_session"
+	SESSION_TTL    = 12 * time.Hour
+)
+
+// Logged-in parties by session id
+// Tokens are a random id and its HMAC under a key generated at startup,
+// so forged tokens are rejected and sessions end when the server restarts
+
+type sessions struct {
+	byId map[string]*session
+	key  []byte
+	mtx  sync.Mutex
+	ttl  time.Duration
+	used map[string]time.Time
+}
+
+type session struct {
+	expires time.Time
+	user    *Api
+}
+
+func newSessions(ttl time.Duration) *sessions {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &sessions{
+		byId: make(map[string]*session),
+		key:  key,
+		ttl:  ttl,
+		used: make(map[string]time.Time),
+	}
+}
+
+func (s *sessions) sign(id string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(id))
+	return Base64UrlEncode(mac.Sum(nil))
+}
+
+// Starts a session for user, removing expired ones
+
+func (s *sessions) open(user *Api) (string, time.Time, error) {
+	p := make([]byte, 32)
+	if _, err := rand.Read(p); err != nil {
+		return "", time.Time{}, err
+	}
+	id := Base64UrlEncode(p)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := Now()
+	expires := now.Add(s.ttl)
+	for sessionId, sess := range s.byId {
+		if !now.Before(sess.expires) {
+			delete(s.byId, sessionId)
+		}
+	}
+	s.byId[id] = &session{expires, user}
+	return id + "." + s.sign(id), expires, nil
+}
+
+func (s *sessions) get(token string) (*Api, error) {
+	id, err := s.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sess, ok := s.byId[id]
+	if !ok {
+		return nil, ErrorAppend(ErrInvalidLogin, "no session")
+	}
+	if !Now().Before(sess.expires) {
+		delete(s.byId, id)
+		return nil, ErrorAppend(ErrInvalidLogin, "session expired")
+	}
+	return sess.user, nil
+}
+
+func (s *sessions) close(token string) error {
+	id, err := s.verify(token)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	delete(s.byId, id)
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *sessions) verify(token string) (string, error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(s.sign(token[:i]))) {
+		return "", ErrorAppend(ErrInvalidLogin, "invalid token")
+	}
+	return token[:i], nil
+}
+
+// Login challenges are a random nonce and expiry with their HMAC,
+// so they needn't be stored until used
+
+func (s *sessions) challenge() (string, error) {
+	p := make([]byte, 40)
+	if _, err := rand.Read(p[:32]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(p[32:], uint64(Now().Add(CHALLENGE_TTL).Unix()))
+	nonce := Base64UrlEncode(p)
+	return nonce + "." + s.sign(nonce), nil
+}
+
+// Each challenge can be used once before it expires
+
+func (s *sessions) useChallenge(challenge string) error {
+	nonce, err := s.verify(challenge)
+	if err != nil {
+		return err
+	}
+	p, err := Base64UrlDecode(nonce)
+	if err != nil || len(p) != 40 {
+		return ErrorAppend(ErrInvalidLogin, "invalid challenge")
+	}
+	now := Now()
+	expires := time.Unix(int64(binary.BigEndian.Uint64(p[32:])), 0)
+	if !now.Before(expires) {
+		return ErrorAppend(ErrInvalidLogin, "challenge expired")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for used, usedExpires := range s.used {
+		if !now.Before(usedExpires) {
+			delete(s.used, used)
+		}
+	}
+	if _, ok := s.used[nonce]; ok {
+		return ErrorAppend(ErrInvalidLogin, "challenge already used")
+	}
+	s.used[nonce] = expires
+	return nil
+}
+
+// Bearer token in the Authorization header, else the session cookie
+
+func sessionToken(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	if cookie, err := req.Cookie(SESSION_COOKIE); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (api *Api) SetSessionTTL(ttl time.Duration) {
+	api.sessions.mtx.Lock()
+	api.sessions.ttl = ttl
+	api.sessions.mtx.Unlock()
+}
+
+// Starts a session for a logged-in party, returning its token
+
+func (api *Api) OpenSession(user *Api) (string, time.Time, error) {
+	if !user.LoggedIn() {
+		return "", time.Time{}, ErrInvalidLogin
+	}
+	return api.sessions.open(user)
+}
+
+// The session cookie, only sent back over TLS if the request came over TLS
+
+func sessionCookie(req *http.Request, token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   req.TLS != nil,
+	}
+}
+
+// Sets the session cookie and writes the token for bearer clients,
+// with any other fields in res
+
+func (api *Api) writeSession(w http.ResponseWriter, req *http.Request, user *Api, res Data) {
+	token, expires, err := api.OpenSession(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cookie := sessionCookie(req, token)
+	cookie.Expires = expires
+	http.SetCookie(w, cookie)
+	if res == nil {
+		res = make(Data)
+	}
+	res.Set("expires", expires.UTC().Format(time.RFC3339))
+	res.Set("partyId", user.partyId)
+	res.Set("token", token)
+	WriteJSON(w, res)
+}
+
+// The logged-in party for the request's session
+
+func (api *Api) Session(req *http.Request) (*Api, error) {
+	token := sessionToken(req)
+	if EmptyStr(token) {
+		return nil, ErrorAppend(ErrInvalidLogin, "not logged in")
+	}
+	return api.sessions.get(token)
+}
+
+func (api *Api) Logout(token string) error {
+	return api.sessions.close(token)
+}
+
+func (api *Api) LogoutHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, ErrExpectedPost.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := api.Logout(sessionToken(req)); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	cookie := sessionCookie(req, "")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+	w.WriteHeader(http.StatusOK)
+}
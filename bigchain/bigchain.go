@@ -2,6 +2,11 @@ package bigchain
 
 import (
 	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
 
 	. "github.com/zbo14/envoke/common"
 	conds "github.com/zbo14/envoke/crypto/conditions"
@@ -9,65 +14,681 @@ import (
 	"github.com/zbo14/envoke/crypto/ed25519"
 )
 
+// Client
+
+type Client struct {
+	appId    string
+	appKey   string
+	cache    TxCache
+	ctx      context.Context
+	endpoint string
+	http     *http.Client
+	mode     string
+	version  string
+	workers  chan struct{}
+}
+
+// Verified txs, read before and written after fetching from the node
+// Committed txs never change, so entries needn't be invalidated
+
+type TxCache interface {
+	GetTx(txId string) (Data, bool)
+	PutTx(txId string, tx Data) error
+}
+
+const (
+	DEFAULT_TIMEOUT = 30 * time.Second
+	DEFAULT_WORKERS = 8
+	POLL_INTERVAL   = 250 * time.Millisecond
+
+	// POST modes
+	MODE_ASYNC  = "async"
+	MODE_COMMIT = "commit"
+	MODE_SYNC   = "sync"
+
+	// Tx statuses
+	STATUS_BACKLOG   = "backlog"
+	STATUS_INVALID   = "invalid"
+	STATUS_UNDECIDED = "undecided"
+	STATUS_VALID     = "valid"
+)
+
+func NewClient(endpoint string) *Client {
+	return &Client{
+		ctx:      context.Background(),
+		endpoint: endpoint,
+		http:     &http.Client{Timeout: DEFAULT_TIMEOUT},
+		version:  VERSION_09,
+		workers:  make(chan struct{}, DEFAULT_WORKERS),
+	}
+}
+
+// IPDB_ENDPOINT, IPDB_APP_ID, IPDB_APP_KEY, IPDB_MODE and IPDB_VERSION,
+// e.g. 2.0 for current nodes
+
+func NewClientFromEnv() *Client {
+	cli := NewClient(Getenv("IPDB_ENDPOINT"))
+	cli.SetAuth(Getenv("IPDB_APP_ID"), Getenv("IPDB_APP_KEY"))
+	Check(cli.SetMode(Getenv("IPDB_MODE")))
+	if v := Getenv("IPDB_VERSION"); !EmptyStr(v) {
+		Check(cli.SetVersion(v))
+	}
+	return cli
+}
+
+func (cli *Client) Context() context.Context {
+	return cli.ctx
+}
+
+func (cli *Client) Endpoint() string {
+	return cli.endpoint
+}
+
+func (cli *Client) SetAuth(appId, appKey string) {
+	cli.appId = appId
+	cli.appKey = appKey
+}
+
+// Mode txs are posted with, the node's default if empty
+// async returns once the tx is checked, sync once it's in the backlog
+// and commit once it's in a block
+
+func (cli *Client) SetMode(mode string) error {
+	switch mode {
+	case "", MODE_ASYNC, MODE_COMMIT, MODE_SYNC:
+		cli.mode = mode
+		return nil
+	}
+	return ErrorAppend(ErrInvalidType, "mode "+mode)
+}
+
+// Slots for goroutines doing work for the client, e.g. validating linked
+// models, shared with the client's copies so nested work is bounded too
+
+func (cli *Client) Workers() chan struct{} {
+	return cli.workers
+}
+
+func (cli *Client) Cache() TxCache {
+	return cli.cache
+}
+
+func (cli *Client) SetCache(cache TxCache) {
+	cli.cache = cache
+}
+
+func (cli *Client) SetHttpClient(httpCli *http.Client) {
+	cli.http = httpCli
+}
+
+func (cli *Client) SetTimeout(timeout time.Duration) {
+	cli.http.Timeout = timeout
+}
+
+// Returns a shallow copy of the client that issues requests with ctx
+
+func (cli *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		panic("nil context")
+	}
+	cpy := *cli
+	cpy.ctx = ctx
+	return &cpy
+}
+
+// Returns a shallow copy of the client that reads through cache
+
+func (cli *Client) WithCache(cache TxCache) *Client {
+	cpy := *cli
+	cpy.cache = cache
+	return &cpy
+}
+
+func (cli *Client) Do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, cli.endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(cli.ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if !EmptyStr(cli.appId) {
+		req.Header.Set("app_id", cli.appId)
+		req.Header.Set("app_key", cli.appKey)
+	}
+	return cli.http.Do(req)
+}
+
 // GET
 
-func GetTx(txId string) (Data, error) {
-	url := Getenv("IPDB_ENDPOINT") + "transactions/" + txId
-	response, err := HttpGet(url)
+func (cli *Client) GetTx(txId string) (Data, error) {
+	if cli.cache != nil {
+		if tx, ok := cli.cache.GetTx(txId); ok {
+			return tx, nil
+		}
+	}
+	tx, err := cli.getTx(txId)
 	if err != nil {
 		return nil, err
 	}
+	if err = cli.VerifyTx(txId, tx); err != nil {
+		return nil, err
+	}
+	if cli.cache != nil {
+		if err = cli.cache.PutTx(txId, tx); err != nil {
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+func (cli *Client) getTx(txId string) (Data, error) {
+	response, err := cli.Do(http.MethodGet, "transactions/"+txId, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
 	tx := make(Data)
 	if err = ReadJSON(response.Body, &tx); err != nil {
 		return nil, err
 	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return tx, nil
+	case http.StatusNotFound:
+		return nil, &TxNotFoundError{txId}
+	}
+	return nil, ErrorAppend(ErrInvalidRequest, tx.GetStr("message"))
+}
+
+// Status of the tx, empty if the node doesn't know it yet
+// Nodes without a statuses endpoint (2.0) only serve committed txs
+
+func (cli *Client) GetTxStatus(txId string) (string, error) {
+	response, err := cli.Do(http.MethodGet, "statuses?transaction_id="+txId, nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	data := make(Data)
+	switch response.StatusCode {
+	case http.StatusOK:
+		if err = ReadJSON(response.Body, &data); err != nil {
+			return "", err
+		}
+		return data.GetStr("status"), nil
+	case http.StatusNotFound:
+		tx, err := cli.getTx(txId)
+		if _, ok := err.(*TxNotFoundError); ok {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if err = cli.VerifyTx(txId, tx); err != nil {
+			return "", err
+		}
+		return STATUS_VALID, nil
+	}
+	ReadJSON(response.Body, &data)
+	return "", ErrorAppend(ErrInvalidRequest, data.GetStr("message"))
+}
+
+// Polls the status of the tx until it's valid
+
+func (cli *Client) WaitForCommit(txId string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		status, err := cli.GetTxStatus(txId)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case STATUS_VALID:
+			return nil
+		case STATUS_INVALID:
+			return ErrorAppend(ErrCriteriaNotMet, "tx "+txId+" is invalid")
+		}
+		select {
+		case <-cli.ctx.Done():
+			return cli.ctx.Err()
+		case <-deadline:
+			return ErrorAppend(ErrTimeout, "tx "+txId+" is not committed")
+		case <-time.After(POLL_INTERVAL):
+		}
+	}
+}
+
+// Verify
+
+type TxIdError struct {
+	Expected string
+	Actual   string
+}
+
+func (err *TxIdError) Error() string {
+	return Sprintf("Invalid tx id: expected %s, got %s", err.Expected, err.Actual)
+}
+
+type TxNotFoundError struct {
+	TxId string
+}
+
+func (err *TxNotFoundError) Error() string {
+	return "Tx not found: " + err.TxId
+}
+
+type AssetIdError struct {
+	TxId     string
+	Expected string
+	Actual   string
+}
+
+func (err *AssetIdError) Error() string {
+	return Sprintf("Invalid asset id in tx %s: expected %s, got %s", err.TxId, err.Expected, err.Actual)
+}
+
+type InputError struct {
+	TxId    string
+	Output  string
+	Message string
+}
+
+func (err *InputError) Error() string {
+	return Sprintf("Invalid input in tx %s for output %s: %s", err.TxId, err.Output, err.Message)
+}
+
+// Checks that tx is the document with txId: the id field and the
+// checksum of the canonical body must both equal txId
+
+func VerifyTxId(txId string, tx Data) error {
+	if id := GetId(tx); txId != id {
+		return &TxIdError{txId, id}
+	}
+	if id := ComputeTxId(tx); txId != id {
+		return &TxIdError{txId, id}
+	}
+	return nil
+}
+
+// Checks what the tx shows by itself: the id, that it has inputs with
+// owners and outputs, as a node requires, and the fulfillments, which
+// must be signed by the owners before of their inputs
+
+func VerifyTxSigned(txId string, tx Data) error {
+	if err := VerifyTxId(txId, tx); err != nil {
+		return err
+	}
+	inputs := GetTxInputs(tx)
+	if len(inputs) == 0 {
+		return ErrorAppend(ErrInvalidSize, "tx must have inputs")
+	}
+	for _, input := range inputs {
+		if len(GetInputPublicKeys(input)) == 0 {
+			return ErrorAppend(ErrInvalidSize, "input must have owners before")
+		}
+	}
+	if len(GetTxOutputs(tx)) == 0 {
+		return ErrorAppend(ErrInvalidSize, "tx must have outputs")
+	}
 	if !FulfilledTx(tx) {
-		return nil, ErrInvalidFulfillment
+		return ErrInvalidFulfillment
+	}
+	txVersion := GetTxVersion(tx)
+	for _, input := range inputs {
+		condition, err := fulfillmentCondition(txVersion, input.GetStr("fulfillment"))
+		if err != nil {
+			return err
+		}
+		if condition != ownersCondition(txVersion, GetInputPublicKeys(input)) {
+			return ErrorAppend(ErrInvalidFulfillment, "fulfillment does not match owners before")
+		}
+	}
+	return nil
+}
+
+// Checks the tx with VerifyTxSigned and, for a TRANSFER, that every
+// consumed tx belongs to the same asset and that the owners before
+// of each input hold the output it consumes. Consumed txs are fetched
+// without verifying their own inputs, so the cost doesn't grow with the chain
+
+func (cli *Client) VerifyTx(txId string, tx Data) error {
+	if err := VerifyTxSigned(txId, tx); err != nil {
+		return err
+	}
+	return VerifyTxInputs(txId, tx, cli.getConsumedTx)
+}
+
+// Consumed tx checked against its id but not its own inputs
+
+func (cli *Client) getConsumedTx(txId string) (Data, error) {
+	tx, err := cli.getTx(txId)
+	if err != nil {
+		return nil, err
+	}
+	if err = VerifyTxId(txId, tx); err != nil {
+		return nil, err
 	}
 	return tx, nil
 }
 
+// Checks that the txs a TRANSFER consumes, read with getTx, belong to
+// its asset and that the owners before of each input hold the output
+// it consumes, by public keys and condition. Other txs pass
+
+func VerifyTxInputs(txId string, tx Data, getTx func(string) (Data, error)) error {
+	if GetTxOperation(tx) != TRANSFER {
+		return nil
+	}
+	assetId := GetTxAssetId(tx)
+	txVersion := GetTxVersion(tx)
+	for _, input := range GetTxInputs(tx) {
+		consumeId, n := GetInputFulfills(input)
+		consumed, err := getTx(consumeId)
+		if err != nil {
+			return err
+		}
+		if id := GetTxAssetIdOrId(consumed); assetId != id {
+			return &AssetIdError{txId, id, assetId}
+		}
+		output := outputKey(consumeId, n)
+		outputs := GetTxOutputs(consumed)
+		if n < 0 || n >= len(outputs) {
+			return &InputError{txId, output, "output index out of range"}
+		}
+		pubs := GetInputPublicKeys(input)
+		if !samePublicKeys(pubs, GetOutputPublicKeys(outputs[n])) {
+			return &InputError{txId, output, "owners before do not match output public keys"}
+		}
+		if ownersCondition(txVersion, pubs) != GetOutputCondition(outputs[n]).GetStr("uri") {
+			return &InputError{txId, output, "owners before do not match output condition"}
+		}
+	}
+	return nil
+}
+
+func fulfillmentCondition(txVersion, fulfillment string) (string, error) {
+	if txVersion == VERSION_20 {
+		f, err := conds.FulfillmentV2FromString(fulfillment)
+		if err != nil {
+			return "", err
+		}
+		return f.Condition().String(), nil
+	}
+	f, err := conds.UnmarshalURI(fulfillment, 1)
+	if err != nil {
+		return "", err
+	}
+	return conds.GetCondition(f).String(), nil
+}
+
+func ownersCondition(txVersion string, pubs []crypto.PublicKey) string {
+	if txVersion == VERSION_20 {
+		return conds.FulfillmentV2FromPubKeys(pubs).Condition().String()
+	}
+	if len(pubs) == 1 {
+		return conds.GetCondition(conds.DefaultFulfillmentFromPubKey(pubs[0])).String()
+	}
+	return conds.GetCondition(conds.DefaultFulfillmentThresholdFromPubKeys(pubs)).String()
+}
+
+func samePublicKeys(pubs, others []crypto.PublicKey) bool {
+	if len(pubs) != len(others) {
+		return false
+	}
+	for i := range pubs {
+		if !pubs[i].Equals(others[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Txs of an asset, optionally filtered by operation
+// Each tx is checked with VerifyTxSigned and against the asset id, and
+// each TRANSFER against the txs it consumes, listed or fetched
+
+func (cli *Client) ListTxs(assetId, operation string) ([]Data, error) {
+	path := "transactions?asset_id=" + assetId
+	if !EmptyStr(operation) {
+		path += "&operation=" + operation
+	}
+	response, err := cli.Do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		data := make(Data)
+		ReadJSON(response.Body, &data)
+		return nil, ErrorAppend(ErrInvalidRequest, data.GetStr("message"))
+	}
+	var txs []Data
+	if err = ReadJSON(response.Body, &txs); err != nil {
+		return nil, err
+	}
+	listed := make(map[string]Data, len(txs))
+	for _, tx := range txs {
+		txId := GetId(tx)
+		if err = VerifyTxSigned(txId, tx); err != nil {
+			return nil, err
+		}
+		if id := GetTxAssetIdOrId(tx); assetId != id {
+			return nil, &AssetIdError{txId, assetId, id}
+		}
+		listed[txId] = tx
+	}
+	for _, tx := range txs {
+		err = VerifyTxInputs(GetId(tx), tx, func(consumeId string) (Data, error) {
+			if consumed, ok := listed[consumeId]; ok {
+				return consumed, nil
+			}
+			return cli.getConsumedTx(consumeId)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return txs, nil
+}
+
+// Assets with data matching the text query, as Data{"data", "id"}
+// Every match is returned if limit <= 0
+
+func (cli *Client) SearchAssets(query string, limit int) ([]Data, error) {
+	path := "assets?search=" + QueryEscape(query)
+	if limit > 0 {
+		path += "&limit=" + Itoa(limit)
+	}
+	response, err := cli.Do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		data := make(Data)
+		ReadJSON(response.Body, &data)
+		return nil, ErrorAppend(ErrInvalidRequest, data.GetStr("message"))
+	}
+	var assets []Data
+	if err = ReadJSON(response.Body, &assets); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// Unspent outputs with pub in their public keys, as Data{"output", "txid"}
+// Nodes return links, e.g. "../transactions/<txid>/outputs/<n>",
+// or objects, e.g. {"output_index": n, "transaction_id": txid}
+
+func (cli *Client) ListUnspentOutputs(pub crypto.PublicKey) ([]Data, error) {
+	response, err := cli.Do(http.MethodGet, "outputs?public_key="+pub.String()+"&spent=false", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		data := make(Data)
+		ReadJSON(response.Body, &data)
+		return nil, ErrorAppend(ErrInvalidRequest, data.GetStr("message"))
+	}
+	var links []interface{}
+	if err = ReadJSON(response.Body, &links); err != nil {
+		return nil, err
+	}
+	outputs := make([]Data, len(links))
+	for i, link := range links {
+		txId, n, err := parseOutputLink(link)
+		if err != nil {
+			return nil, err
+		}
+		outputs[i] = Data{"output": n, "txid": txId}
+	}
+	return outputs, nil
+}
+
+func parseOutputLink(link interface{}) (string, int, error) {
+	switch link := link.(type) {
+	case string:
+		parts := SplitStr(link, "/")
+		i := len(parts) - 4
+		if i < 0 || parts[i] != "transactions" || parts[i+2] != "outputs" {
+			return "", 0, ErrorAppend(ErrInvalidUrl, link)
+		}
+		n, err := Atoi(parts[i+3])
+		if err != nil {
+			return "", 0, err
+		}
+		return parts[i+1], n, nil
+	case map[string]interface{}:
+		output := Data(link)
+		txId := output.GetStr("transaction_id")
+		if EmptyStr(txId) {
+			return "", 0, ErrorAppend(ErrInvalidId, "output link missing transaction_id")
+		}
+		return txId, output.GetInt("output_index"), nil
+	}
+	return "", 0, ErrorAppend(ErrInvalidType, Sprintf("%v", link))
+}
+
 // POST
 
 // BigchainDB transaction type
 // docs.bigchaindb.com/projects/py-driver/en/latest/handcraft.html
 
-func PostTx(tx Data) (string, error) {
-	url := Getenv("IPDB_ENDPOINT") + "transactions/"
+func (cli *Client) PostTx(tx Data) (string, error) {
 	buf := new(bytes.Buffer)
 	buf.Write(MustMarshalJSON(tx))
-	response, err := HttpPost(url, "application/json", buf)
+	path := "transactions/"
+	if !EmptyStr(cli.mode) {
+		path += "?mode=" + cli.mode
+	}
+	response, err := cli.Do(http.MethodPost, path, buf)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	p, err := ReadAll(response.Body)
 	if err != nil {
 		return "", err
 	}
 	data := make(Data)
-	if err := ReadJSON(response.Body, &data); err != nil {
+	if response.StatusCode != http.StatusAccepted && response.StatusCode != http.StatusOK {
+		message := http.StatusText(response.StatusCode)
+		if err = UnmarshalJSON(p, &data); err == nil && !EmptyStr(data.GetStr("message")) {
+			message = data.GetStr("message")
+		}
+		return "", NewTxError(response.StatusCode, message)
+	}
+	if err = UnmarshalJSON(p, &data); err != nil {
 		return "", err
 	}
-	return GetId(tx), nil
+	// The node echoes the tx it accepted
+	txId := GetId(tx)
+	if id := GetId(data); txId != id {
+		return "", &TxIdError{txId, id}
+	}
+	return txId, nil
+}
+
+// BigchainDB exceptions
+const (
+	AMOUNT_ERROR          = "AmountError"
+	ASSET_ID_MISMATCH     = "AssetIdMismatch"
+	DOUBLE_SPEND          = "DoubleSpend"
+	DUPLICATE_TRANSACTION = "DuplicateTransaction"
+	INPUT_DOES_NOT_EXIST  = "InputDoesNotExist"
+	INVALID_HASH          = "InvalidHash"
+	INVALID_SIGNATURE     = "InvalidSignature"
+	VALIDATION_ERROR      = "ValidationError"
+)
+
+// Tx rejected by the node, Type is the exception if the message
+// looks like "Invalid transaction (DoubleSpend): ..."
+
+type TxError struct {
+	Status  int
+	Type    string
+	Message string
+}
+
+func NewTxError(status int, message string) *TxError {
+	err := &TxError{Status: status, Message: message}
+	if rest := strings.TrimPrefix(message, "Invalid transaction ("); rest != message {
+		if i := strings.Index(rest, "): "); i > 0 {
+			err.Type = rest[:i]
+			err.Message = rest[i+3:]
+		}
+	}
+	return err
+}
+
+func (err *TxError) Error() string {
+	if EmptyStr(err.Type) {
+		return Sprintf("Tx rejected with status %d: %s", err.Status, err.Message)
+	}
+	return Sprintf("Tx rejected with status %d (%s): %s", err.Status, err.Type, err.Message)
 }
 
 const (
 	CREATE   = "CREATE"
 	GENESIS  = "GENESIS"
 	TRANSFER = "TRANSFER"
-	VERSION  = "0.9"
+
+	VERSION_09 = "0.9"
+	VERSION_20 = "2.0"
 )
 
-func DefaultIndividualCreateTx(data Data, owner crypto.PublicKey) Data {
-	return IndividualCreateTx(1, data, owner, owner)
+// The client builds txs in the version set with SetVersion, 0.9 by
+// default; txs are read according to their own version field
+
+func (cli *Client) SetVersion(v string) error {
+	if v != VERSION_09 && v != VERSION_20 {
+		return ErrorAppend(ErrInvalidType, "unsupported tx version "+v)
+	}
+	cli.version = v
+	return nil
+}
+
+func (cli *Client) Version() string {
+	return cli.version
+}
+
+func (cli *Client) DefaultIndividualCreateTx(data, metadata Data, owner crypto.PublicKey) Data {
+	return cli.IndividualCreateTx(1, data, metadata, owner, owner)
 }
 
-func IndividualCreateTx(amount int, data Data, ownerAfter, ownerBefore crypto.PublicKey) Data {
+func (cli *Client) IndividualCreateTx(amount int, data, metadata Data, ownerAfter, ownerBefore crypto.PublicKey) Data {
 	amounts := []int{amount}
 	asset := Data{"data": data}
 	fulfills := []Data{nil}
 	ownersAfter := [][]crypto.PublicKey{[]crypto.PublicKey{ownerAfter}}
 	ownersBefore := [][]crypto.PublicKey{[]crypto.PublicKey{ownerBefore}}
-	return CreateTx(amounts, asset, fulfills, ownersAfter, ownersBefore)
+	return cli.CreateTx(amounts, asset, fulfills, metadata, ownersAfter, ownersBefore)
 }
 
-func MultipleOwnersCreateTx(amounts []int, data Data, ownersAfter []crypto.PublicKey, ownerBefore crypto.PublicKey) Data {
+func (cli *Client) MultipleOwnersCreateTx(amounts []int, data, metadata Data, ownersAfter []crypto.PublicKey, ownerBefore crypto.PublicKey) Data {
 	asset := Data{"data": data}
 	fulfills := []Data{nil}
 	ownersBefore := []crypto.PublicKey{ownerBefore}
@@ -86,23 +707,23 @@ func MultipleOwnersCreateTx(amounts []int, data Data, ownersAfter []crypto.Publi
 			owners[i] = []crypto.PublicKey{owner}
 		}
 	}
-	return CreateTx(amounts, asset, fulfills, owners, [][]crypto.PublicKey{ownersBefore})
+	return cli.CreateTx(amounts, asset, fulfills, metadata, owners, [][]crypto.PublicKey{ownersBefore})
 }
 
-func DefaultIndividualTransferTx(assetId, consumeId string, output int, ownerAfter, ownerBefore crypto.PublicKey) Data {
-	return IndividualTransferTx(1, assetId, consumeId, output, ownerAfter, ownerBefore)
+func (cli *Client) DefaultIndividualTransferTx(assetId, consumeId string, metadata Data, output int, ownerAfter, ownerBefore crypto.PublicKey) Data {
+	return cli.IndividualTransferTx(1, assetId, consumeId, metadata, output, ownerAfter, ownerBefore)
 }
 
-func IndividualTransferTx(amount int, assetId, consumeId string, output int, ownerAfter, ownerBefore crypto.PublicKey) Data {
+func (cli *Client) IndividualTransferTx(amount int, assetId, consumeId string, metadata Data, output int, ownerAfter, ownerBefore crypto.PublicKey) Data {
 	amounts := []int{amount}
 	asset := Data{"id": assetId}
 	fulfills := []Data{Data{"txid": consumeId, "output": output}}
 	ownersAfter := [][]crypto.PublicKey{[]crypto.PublicKey{ownerAfter}}
 	ownersBefore := [][]crypto.PublicKey{[]crypto.PublicKey{ownerBefore}}
-	return TransferTx(amounts, asset, fulfills, ownersAfter, ownersBefore)
+	return cli.TransferTx(amounts, asset, fulfills, metadata, ownersAfter, ownersBefore)
 }
 
-func DivisibleTransferTx(amounts []int, assetId, consumeId string, output int, ownersAfter []crypto.PublicKey, ownerBefore crypto.PublicKey) Data {
+func (cli *Client) DivisibleTransferTx(amounts []int, assetId, consumeId string, metadata Data, output int, ownersAfter []crypto.PublicKey, ownerBefore crypto.PublicKey) Data {
 	n := len(amounts)
 	if n <= 1 || n != len(ownersAfter) {
 		panic(ErrInvalidSize)
@@ -114,39 +735,136 @@ func DivisibleTransferTx(amounts []int, assetId, consumeId string, output int, o
 		owners[i] = []crypto.PublicKey{owner}
 	}
 	ownersBefore := [][]crypto.PublicKey{[]crypto.PublicKey{ownerBefore}}
-	return TransferTx(amounts, asset, fulfills, owners, ownersBefore)
+	return cli.TransferTx(amounts, asset, fulfills, metadata, owners, ownersBefore)
+}
+
+// Consumes an output with several owners, who each sign with PartiallyFulfillTx
+// If there is one amount, the output is shared by ownersAfter
+
+func (cli *Client) MultipleOwnersTransferTx(amounts []int, assetId, consumeId string, metadata Data, output int, ownersAfter, ownersBefore []crypto.PublicKey) Data {
+	n := len(amounts)
+	if n == 0 {
+		panic(ErrorAppend(ErrCriteriaNotMet, "must have at least one amount"))
+	}
+	if len(ownersBefore) == 0 {
+		panic(ErrorAppend(ErrCriteriaNotMet, "must have at least one owner before"))
+	}
+	asset := Data{"id": assetId}
+	fulfills := []Data{Data{"txid": consumeId, "output": output}}
+	owners := make([][]crypto.PublicKey, n)
+	if n == 1 {
+		owners[0] = ownersAfter
+	} else {
+		if n != len(ownersAfter) {
+			panic(ErrorAppend(ErrCriteriaNotMet, "must have same number of amounts as owners if number > 1"))
+		}
+		for i, owner := range ownersAfter {
+			owners[i] = []crypto.PublicKey{owner}
+		}
+	}
+	return cli.TransferTx(amounts, asset, fulfills, metadata, owners, [][]crypto.PublicKey{ownersBefore})
+}
+
+// Consumes several outputs of the same asset held by ownerBefore,
+// consumeAmounts are the amounts of the consumed outputs
+
+func (cli *Client) ConsolidatedTransferTx(amounts []int, assetId string, consumeAmounts []int, consumeIds []string, metadata Data, outputs []int, ownersAfter []crypto.PublicKey, ownerBefore crypto.PublicKey) Data {
+	n := len(consumeIds)
+	if n == 0 || n != len(consumeAmounts) || n != len(outputs) {
+		panic(ErrorAppend(ErrInvalidSize, "slices are different sizes"))
+	}
+	if len(amounts) == 0 || len(amounts) != len(ownersAfter) {
+		panic(ErrorAppend(ErrInvalidSize, "slices are different sizes"))
+	}
+	amountIn, amountOut := 0, 0
+	for _, amount := range consumeAmounts {
+		amountIn += amount
+	}
+	for _, amount := range amounts {
+		amountOut += amount
+	}
+	if amountIn != amountOut {
+		panic(ErrorAppend(ErrCriteriaNotMet, Sprintf("consumed amount %d does not equal produced amount %d", amountIn, amountOut)))
+	}
+	asset := Data{"id": assetId}
+	fulfills := make([]Data, n)
+	ownersBefore := make([][]crypto.PublicKey, n)
+	for i, consumeId := range consumeIds {
+		fulfills[i] = Data{"txid": consumeId, "output": outputs[i]}
+		ownersBefore[i] = []crypto.PublicKey{ownerBefore}
+	}
+	owners := make([][]crypto.PublicKey, len(ownersAfter))
+	for i, owner := range ownersAfter {
+		owners[i] = []crypto.PublicKey{owner}
+	}
+	return cli.TransferTx(amounts, asset, fulfills, metadata, owners, ownersBefore)
 }
 
-func CreateTx(amounts []int, asset Data, fulfills []Data, ownersAfter, ownersBefore [][]crypto.PublicKey) Data {
-	return GenerateTx(amounts, asset, fulfills, nil, CREATE, ownersAfter, ownersBefore)
+func (cli *Client) CreateTx(amounts []int, asset Data, fulfills []Data, metadata Data, ownersAfter, ownersBefore [][]crypto.PublicKey) Data {
+	return cli.GenerateTx(amounts, asset, fulfills, metadata, CREATE, ownersAfter, ownersBefore)
 }
 
-func TransferTx(amounts []int, asset Data, fulfills []Data, ownersAfter, ownersBefore [][]crypto.PublicKey) Data {
-	return GenerateTx(amounts, asset, fulfills, nil, TRANSFER, ownersAfter, ownersBefore)
+func (cli *Client) TransferTx(amounts []int, asset Data, fulfills []Data, metadata Data, ownersAfter, ownersBefore [][]crypto.PublicKey) Data {
+	return cli.GenerateTx(amounts, asset, fulfills, metadata, TRANSFER, ownersAfter, ownersBefore)
 }
 
-func GenerateTx(amounts []int, asset Data, fulfills []Data, metadata Data, operation string, ownersAfter, ownersBefore [][]crypto.PublicKey) Data {
-	inputs := NewInputs(fulfills, ownersBefore)
-	outputs := NewOutputs(amounts, ownersAfter)
-	return NewTx(asset, inputs, metadata, operation, outputs)
+func (cli *Client) GenerateTx(amounts []int, asset Data, fulfills []Data, metadata Data, operation string, ownersAfter, ownersBefore [][]crypto.PublicKey) Data {
+	inputs := NewInputs(cli.version, fulfills, ownersBefore)
+	outputs := NewOutputs(cli.version, amounts, ownersAfter)
+	return NewTx(cli.version, asset, inputs, metadata, operation, outputs)
 }
 
-func NewTx(asset Data, inputs []Data, metadata Data, operation string, outputs []Data) Data {
+func NewTx(version string, asset Data, inputs []Data, metadata Data, operation string, outputs []Data) Data {
 	tx := Data{
 		"asset":     asset,
 		"inputs":    inputs,
 		"metadata":  metadata,
 		"operation": operation,
 		"outputs":   outputs,
-		"version":   VERSION,
+		"version":   version,
+	}
+	if version == VERSION_20 {
+		// The id covers the fulfillments so it's set by FulfillTx
+		tx.Set("id", nil)
+	} else {
+		tx.Set("id", ComputeTxId(tx))
 	}
-	sum := Checksum256(MustMarshalJSON(tx))
-	tx.Set("id", BytesToHex(sum))
 	return tx
 }
 
+// Version 0.9: checksum of the canonical tx without id and fulfillments
+// Version 2.0: checksum of the canonical tx with null id
+
+func ComputeTxId(tx Data) string {
+	if GetTxVersion(tx) == VERSION_20 {
+		return BytesToHex(Checksum256(canonicalTxV2(tx)))
+	}
+	body := make(Data)
+	for k, v := range tx {
+		if k != "id" {
+			body[k] = v
+		}
+	}
+	inputs := GetTxInputs(tx)
+	bodyInputs := make([]Data, len(inputs))
+	for i, input := range inputs {
+		bodyInputs[i] = Data{
+			"fulfillment":   nil,
+			"fulfills":      input.Get("fulfills"),
+			"owners_before": input.Get("owners_before"),
+		}
+	}
+	body.Set("inputs", bodyInputs)
+	sum := Checksum256(MustMarshalCanonicalJSON(body))
+	return BytesToHex(sum)
+}
+
 func FulfillTx(tx Data, priv crypto.PrivateKey) {
-	json := MustMarshalJSON(tx)
+	if GetTxVersion(tx) == VERSION_20 {
+		fulfillTxV2(tx, priv)
+		return
+	}
+	json := MustMarshalCanonicalJSON(tx)
 	inputs := tx.Get("inputs").([]Data)
 	for _, input := range inputs {
 		input.Set("fulfillment", conds.DefaultFulfillmentFromPrivKey(json, priv).String())
@@ -154,28 +872,392 @@ func FulfillTx(tx Data, priv crypto.PrivateKey) {
 }
 
 func FulfilledTx(tx Data) bool {
-	var err error
-	inputs := tx.GetInterfaceSlice("inputs")
-	fulfillments := make([]conds.Fulfillment, len(inputs))
+	if GetTxVersion(tx) == VERSION_20 {
+		return fulfilledTxV2(tx)
+	}
+	inputs := GetTxInputs(tx)
+	i := 0
+	return fulfilledTx(tx, func(uri string, json []byte) bool {
+		f, err := conds.UnmarshalURI(uri, 1)
+		if err != nil {
+			return false
+		}
+		n := len(GetInputPublicKeys(inputs[i]))
+		i++
+		if n <= 1 {
+			return f.Validate(json)
+		}
+		// Threshold subfulfillments each read their own message
+		buf := new(bytes.Buffer)
+		for j := 0; j < n; j++ {
+			WriteVarOctet(buf, json)
+		}
+		return f.Validate(buf.Bytes())
+	})
+}
+
+// Clears the fulfillments, calls validate with each fulfillment and
+// the canonical tx, then restores them so the tx can be verified again
+
+func fulfilledTx(tx Data, validate func(string, []byte) bool) (fulfilled bool) {
+	inputs := GetTxInputs(tx)
+	fulfillments := make([]string, len(inputs))
 	for i, input := range inputs {
-		mapData := AssertMapData(input)
-		uri := mapData.GetStr("fulfillment")
-		fulfillments[i], err = conds.UnmarshalURI(uri, 1)
-		Check(err)
-		mapData.Clear("fulfillment")
-	}
-	fulfilled := true
-	json := MustMarshalJSON(tx)
-	for _, f := range fulfillments {
-		if !f.Validate(json) {
+		fulfillments[i] = input.GetStr("fulfillment")
+		input.Clear("fulfillment")
+	}
+	defer func() {
+		for i, input := range inputs {
+			input.Set("fulfillment", fulfillments[i])
+		}
+		if r := recover(); r != nil {
 			fulfilled = false
-			break
 		}
+	}()
+	json := MustMarshalCanonicalJSON(tx)
+	for _, fulfillment := range fulfillments {
+		if !validate(fulfillment, json) {
+			return false
+		}
+	}
+	return true
+}
+
+// Version 2.0 signs the sha3 of the canonical tx with null id and
+// fulfillments, followed by the id and index of the consumed output
+
+func canonicalTxV2(tx Data) []byte {
+	body := make(Data)
+	for k, v := range tx {
+		body[k] = v
+	}
+	body.Set("id", nil)
+	return MustMarshalCanonicalJSON(body)
+}
+
+func inputV2Message(json []byte, input Data) []byte {
+	msg := json
+	if consumeId, output := GetInputFulfills(input); !EmptyStr(consumeId) {
+		msg = append(append([]byte{}, json...), consumeId+Itoa(output)...)
+	}
+	return Checksum256(msg)
+}
+
+func fulfillTxV2(tx Data, priv crypto.PrivateKey) {
+	privEd25519, ok := priv.(*ed25519.PrivateKey)
+	if !ok {
+		panic(ErrInvalidKey)
+	}
+	pubEd25519 := privEd25519.Public().(*ed25519.PublicKey)
+	inputs := GetTxInputs(tx)
+	for _, input := range inputs {
+		input.Clear("fulfillment")
+	}
+	json := canonicalTxV2(tx)
+	for _, input := range inputs {
+		f := conds.NewEd25519V2(pubEd25519, nil)
+		f.Sign(inputV2Message(json, input), privEd25519)
+		input.Set("fulfillment", f.String())
+	}
+	tx.Set("id", ComputeTxId(tx))
+}
+
+func fulfilledTxV2(tx Data) bool {
+	id := tx.Get("id")
+	tx.Set("id", nil)
+	defer tx.Set("id", id)
+	inputs := GetTxInputs(tx)
+	i := 0
+	return fulfilledTx(tx, func(fulfillment string, json []byte) bool {
+		f, err := conds.FulfillmentV2FromString(fulfillment)
+		if err != nil {
+			return false
+		}
+		msg := inputV2Message(json, inputs[i])
+		pubs := GetInputPublicKeys(inputs[i])
+		i++
+		// A partially fulfilled threshold has a lower threshold
+		if !f.Condition().Equals(conds.FulfillmentV2FromPubKeys(pubs).Condition()) {
+			return false
+		}
+		return f.Validate(msg)
+	})
+}
+
+// Multi-signature
+
+// Signs the inputs that list the public key of priv in owners_before.
+// Inputs with several owners get a threshold fulfillment that keeps the
+// subfulfillments of other owners, so each owner can sign the exported
+// tx in turn. The tx is fulfilled once every threshold is met
+
+func PartiallyFulfillTx(tx Data, priv crypto.PrivateKey) error {
+	return partiallyFulfillTx(tx, priv.Public(), func(msg []byte) (crypto.Signature, error) {
+		return priv.Sign(msg), nil
+	})
+}
+
+// Messages pub signs for the inputs it's an owner before of, in order of
+// the inputs, so a co-owner can sign a tx without sending its private key
+
+func PartialTxMessages(tx Data, pub crypto.PublicKey) ([][]byte, error) {
+	var msgs [][]byte
+	inputs := GetTxInputs(tx)
+	for i, msg := range inputMessages(tx) {
+		if hasOwnerBefore(inputs[i], pub) {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil, ErrorAppend(ErrInvalidKey, pub.String()+" is not an owner before of any input")
+	}
+	return msgs, nil
+}
+
+// As PartiallyFulfillTx, with signatures of the messages from PartialTxMessages
+
+func PartiallyFulfillTxWithSignatures(tx Data, pub crypto.PublicKey, sigs []crypto.Signature) error {
+	n := 0
+	err := partiallyFulfillTx(tx, pub, func(msg []byte) (crypto.Signature, error) {
+		if n == len(sigs) {
+			return nil, ErrorAppend(ErrInvalidSize, "expected a signature for each input")
+		}
+		sig := sigs[n]
+		n++
+		if !pub.Verify(msg, sig) {
+			return nil, ErrorAppend(ErrInvalidSignature, sig.String())
+		}
+		return sig, nil
+	})
+	if err == nil && n != len(sigs) {
+		return ErrorAppend(ErrInvalidSize, "expected a signature for each input")
+	}
+	return err
+}
+
+func hasOwnerBefore(input Data, pub crypto.PublicKey) bool {
+	for _, ownerBefore := range GetInputPublicKeys(input) {
+		if pub.Equals(ownerBefore) {
+			return true
+		}
+	}
+	return false
+}
+
+func partiallyFulfillTx(tx Data, pub crypto.PublicKey, sign func([]byte) (crypto.Signature, error)) (err error) {
+	pubEd25519, ok := pub.(*ed25519.PublicKey)
+	if !ok {
+		return ErrInvalidKey
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = Errorf("%v", r)
+		}
+	}()
+	txVersion := GetTxVersion(tx)
+	inputs := GetTxInputs(tx)
+	msgs := inputMessages(tx)
+	fulfillments := make([]string, len(inputs))
+	signed := false
+	for i, input := range inputs {
+		fulfillments[i] = input.GetStr("fulfillment")
+		if !hasOwnerBefore(input, pub) {
+			continue
+		}
+		sig, err := sign(msgs[i])
+		if err != nil {
+			return err
+		}
+		sigEd25519, ok := sig.(*ed25519.Signature)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		pubs := GetInputPublicKeys(input)
+		if txVersion == VERSION_20 {
+			fulfillments[i], err = partialFulfillmentV2(fulfillments[i], pubs, pubEd25519, sigEd25519)
+		} else {
+			fulfillments[i], err = partialFulfillment(fulfillments[i], pubs, pubEd25519, sigEd25519)
+		}
+		if err != nil {
+			return err
+		}
+		signed = true
+	}
+	if !signed {
+		return ErrorAppend(ErrInvalidKey, pub.String()+" is not an owner before of any input")
+	}
+	for i, input := range inputs {
+		if !EmptyStr(fulfillments[i]) {
+			input.Set("fulfillment", fulfillments[i])
+		}
+	}
+	if txVersion == VERSION_20 {
+		tx.Set("id", ComputeTxId(tx))
+	}
+	return nil
+}
+
+func partialFulfillment(uri string, pubs []crypto.PublicKey, pub *ed25519.PublicKey, sig *ed25519.Signature) (string, error) {
+	f := conds.NewFulfillmentEd25519(pub, sig, 1)
+	if len(pubs) == 1 {
+		return f.String(), nil
+	}
+	signed := make(map[string]conds.Fulfillment)
+	if !EmptyStr(uri) {
+		f, err := conds.UnmarshalURI(uri, 1)
+		if err != nil {
+			return "", err
+		}
+		for _, sub := range conds.GetSubfulfillments(f) {
+			if !sub.IsCondition() && sub.Signature() != nil {
+				signed[sub.PublicKey().String()] = sub
+			}
+		}
+	}
+	subs := make(conds.Fulfillments, len(pubs))
+	for i, ownerBefore := range pubs {
+		if sub, ok := signed[ownerBefore.String()]; ok {
+			subs[i] = sub
+		} else if pub.Equals(ownerBefore) {
+			subs[i] = f
+		} else {
+			subs[i] = conds.GetCondition(conds.DefaultFulfillmentFromPubKey(ownerBefore))
+		}
+	}
+	return conds.NewFulfillmentThreshold(subs, len(pubs), 1).String(), nil
+}
+
+// Until every owner has signed, the fulfillment is that of a threshold
+// with the subfulfillments so far, which doesn't match the output condition
+
+func partialFulfillmentV2(fulfillment string, pubs []crypto.PublicKey, pub *ed25519.PublicKey, sig *ed25519.Signature) (string, error) {
+	f := conds.NewEd25519V2(pub, sig)
+	if len(pubs) == 1 {
+		return f.String(), nil
+	}
+	subs := make([]conds.FulfillmentV2, len(pubs))
+	for i, ownerBefore := range pubs {
+		subs[i] = conds.Ed25519V2FromPubKey(ownerBefore)
+	}
+	var prev []conds.FulfillmentV2
+	if !EmptyStr(fulfillment) {
+		threshold, err := conds.FulfillmentV2FromString(fulfillment)
+		if err != nil {
+			return "", err
+		}
+		prev = conds.GetSubfulfillmentsV2(threshold)
+	}
+	n := 0
+	for i, sub := range subs {
+		c := sub.Condition()
+		if c.Equals(f.Condition()) {
+			subs[i] = f
+		}
+		for _, p := range prev {
+			if p.IsFulfilled() && c.Equals(p.Condition()) {
+				subs[i] = p
+			}
+		}
+		if subs[i].IsFulfilled() {
+			n++
+		}
+	}
+	return conds.NewThresholdV2(subs, n).String(), nil
+}
+
+// Client-side signing
+
+// Messages the owner before of each input signs, in order of the inputs,
+// so a client can sign a tx without sending its private key
+// Inputs must have one well-formed owner before; co-owned inputs use PartiallyFulfillTx
+
+func TxMessages(tx Data) ([][]byte, error) {
+	for _, input := range GetTxInputs(tx) {
+		pubs := GetInputPublicKeys(input)
+		if len(pubs) == 0 {
+			return nil, ErrorAppend(ErrInvalidSize, "input has no owners before")
+		}
+		if len(pubs) > 1 {
+			return nil, ErrorAppend(ErrInvalidSize, "input has several owners before")
+		}
+		if len(pubs[0].Bytes()) != ed25519.PUBKEY_SIZE {
+			return nil, ErrorAppend(ErrInvalidKey, "owner before of input")
+		}
+	}
+	return inputMessages(tx), nil
+}
+
+// Messages signed for each input, computed without the fulfillments
+
+func inputMessages(tx Data) [][]byte {
+	txVersion := GetTxVersion(tx)
+	inputs := GetTxInputs(tx)
+	fulfillments := make([]interface{}, len(inputs))
+	for i, input := range inputs {
+		fulfillments[i] = input.Get("fulfillment")
+		input.Clear("fulfillment")
+	}
+	defer func() {
+		for i, input := range inputs {
+			input.Set("fulfillment", fulfillments[i])
+		}
+	}()
+	var json []byte
+	if txVersion == VERSION_20 {
+		json = canonicalTxV2(tx)
+	} else {
+		json = MustMarshalCanonicalJSON(tx)
 	}
+	msgs := make([][]byte, len(inputs))
 	for i, input := range inputs {
-		AssertMapData(input).Set("fulfillment", fulfillments[i])
+		if txVersion == VERSION_20 {
+			msgs[i] = inputV2Message(json, input)
+		} else {
+			msgs[i] = json
+		}
 	}
-	return fulfilled
+	return msgs
+}
+
+// Fulfills each input with the signature of its message from TxMessages,
+// checked against the owner before
+
+func FulfillTxWithSignatures(tx Data, sigs []crypto.Signature) error {
+	msgs, err := TxMessages(tx)
+	if err != nil {
+		return err
+	}
+	inputs := GetTxInputs(tx)
+	if len(sigs) != len(inputs) {
+		return ErrorAppend(ErrInvalidSize, "expected a signature for each input")
+	}
+	fulfillments := make([]string, len(inputs))
+	for i, input := range inputs {
+		pub := GetInputPublicKeys(input)[0]
+		if !pub.Verify(msgs[i], sigs[i]) {
+			return ErrorAppend(ErrInvalidSignature, sigs[i].String())
+		}
+		pubEd25519, ok := pub.(*ed25519.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		sigEd25519, ok := sigs[i].(*ed25519.Signature)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		if GetTxVersion(tx) == VERSION_20 {
+			fulfillments[i] = conds.NewEd25519V2(pubEd25519, sigEd25519).String()
+		} else {
+			fulfillments[i] = conds.NewFulfillmentEd25519(pubEd25519, sigEd25519, 1).String()
+		}
+	}
+	for i, input := range inputs {
+		input.Set("fulfillment", fulfillments[i])
+	}
+	if GetTxVersion(tx) == VERSION_20 {
+		tx.Set("id", ComputeTxId(tx))
+	}
+	return nil
 }
 
 // for convenience
@@ -184,6 +1266,9 @@ func GetId(data Data) string {
 }
 
 func GetPublicKey(data Data) crypto.PublicKey {
+	if pub, ok := data.Get("public_key").(crypto.PublicKey); ok {
+		return pub
+	}
 	pub := new(ed25519.PublicKey)
 	pub.FromString(data.GetStr("public_key"))
 	return pub
@@ -197,6 +1282,15 @@ func GetTxAssetId(tx Data) string {
 	return GetId(GetTxAsset(tx))
 }
 
+// The asset id of a CREATE tx is the tx id
+
+func GetTxAssetIdOrId(tx Data) string {
+	if GetTxOperation(tx) == CREATE {
+		return GetId(tx)
+	}
+	return GetTxAssetId(tx)
+}
+
 func GetTxData(tx Data) Data {
 	return tx.GetInnerData("asset", "data")
 }
@@ -205,10 +1299,18 @@ func SetTxData(tx, data Data) {
 	tx.SetInnerValue(data, "asset", "data")
 }
 
+func GetTxMetadata(tx Data) Data {
+	return tx.GetMapData("metadata")
+}
+
 func GetTxOperation(tx Data) string {
 	return tx.GetStr("operation")
 }
 
+func GetTxVersion(tx Data) string {
+	return tx.GetStr("version")
+}
+
 func GetTxSenders(tx Data) [][]crypto.PublicKey {
 	inputs := GetTxInputs(tx)
 	return GetInputsPublicKeys(inputs)
@@ -242,6 +1344,9 @@ func GetTxShares(tx Data) int {
 }
 
 func GetTxInputs(tx Data) []Data {
+	if inputs, ok := tx.Get("inputs").([]Data); ok {
+		return inputs
+	}
 	inputs := tx.GetInterfaceSlice("inputs")
 	datas := make([]Data, len(inputs))
 	for i, input := range inputs {
@@ -250,7 +1355,20 @@ func GetTxInputs(tx Data) []Data {
 	return datas
 }
 
+// Id and output index of the consumed tx, empty id if none
+
+func GetInputFulfills(input Data) (string, int) {
+	fulfills := input.GetMapData("fulfills")
+	if consumeId := fulfills.GetStr("transaction_id"); !EmptyStr(consumeId) {
+		return consumeId, fulfills.GetInt("output_index")
+	}
+	return fulfills.GetStr("txid"), fulfills.GetInt("output")
+}
+
 func GetInputPublicKeys(input Data) []crypto.PublicKey {
+	if pubs, ok := input.Get("owners_before").([]crypto.PublicKey); ok {
+		return pubs
+	}
 	owners := input.GetInterfaceSlice("owners_before")
 	pubs := make([]crypto.PublicKey, len(owners))
 	for i, owner := range owners {
@@ -274,6 +1392,9 @@ func GetTxOutputAmount(tx Data, n int) int {
 }
 
 func GetTxOutputs(tx Data) []Data {
+	if outputs, ok := tx.Get("outputs").([]Data); ok {
+		return outputs
+	}
 	outputs := tx.GetInterfaceSlice("outputs")
 	datas := make([]Data, len(outputs))
 	for i, output := range outputs {
@@ -282,13 +1403,49 @@ func GetTxOutputs(tx Data) []Data {
 	return datas
 }
 
+// Outputs held by pub alone that no tx in txs consumes,
+// as Data{"amount", "output", "txid"}
+
+func UnspentOutputs(txs []Data, pub crypto.PublicKey) []Data {
+	spent := make(map[string]bool)
+	for _, tx := range txs {
+		for _, input := range GetTxInputs(tx) {
+			if consumeId, n := GetInputFulfills(input); !EmptyStr(consumeId) {
+				spent[Sprintf("%s:%d", consumeId, n)] = true
+			}
+		}
+	}
+	var unspent []Data
+	for _, tx := range txs {
+		txId := GetId(tx)
+		for i, output := range GetTxOutputs(tx) {
+			pubs := GetOutputPublicKeys(output)
+			if len(pubs) != 1 || !pub.Equals(pubs[0]) || spent[Sprintf("%s:%d", txId, i)] {
+				continue
+			}
+			unspent = append(unspent, Data{
+				"amount": GetOutputAmount(output),
+				"output": i,
+				"txid":   txId,
+			})
+		}
+	}
+	return unspent
+}
+
 func GetTxOutput(tx Data, n int) Data {
 	outputs := GetTxOutputs(tx)
 	return outputs[n]
 }
 
+// Version 2.0 amounts are strings
+
 func GetOutputAmount(output Data) int {
-	return int(output.GetFloat64("amount"))
+	if amount, ok := output.Get("amount").(string); ok {
+		n, _ := Atoi(amount)
+		return n
+	}
+	return output.GetInt("amount")
 }
 
 func GetOutputCondition(output Data) Data {
@@ -300,7 +1457,14 @@ func GetConditionDetails(condition Data) Data {
 }
 
 func GetDetailsSubfulfillments(details Data) []Data {
+	if subs, ok := details.Get("subconditions").([]Data); ok {
+		return subs
+	}
 	subs := details.GetInterfaceSlice("subfulfillments")
+	if subs == nil {
+		// version 2.0
+		subs = details.GetInterfaceSlice("subconditions")
+	}
 	if subs == nil {
 		return nil
 	}
@@ -333,19 +1497,25 @@ func GetOutputsPublicKeys(outputs []Data) [][]crypto.PublicKey {
 	return pubs
 }
 
-func NewInputs(fulfills []Data, ownersBefore [][]crypto.PublicKey) []Data {
+func NewInputs(version string, fulfills []Data, ownersBefore [][]crypto.PublicKey) []Data {
 	n := len(fulfills)
 	if n != len(ownersBefore) {
 		panic(ErrorAppend(ErrInvalidSize, "slices are different sizes"))
 	}
 	inputs := make([]Data, n)
 	for i := range inputs {
-		inputs[i] = NewInput(fulfills[i], ownersBefore[i])
+		inputs[i] = NewInput(version, fulfills[i], ownersBefore[i])
 	}
 	return inputs
 }
 
-func NewInput(fulfills Data, ownersBefore []crypto.PublicKey) Data {
+func NewInput(version string, fulfills Data, ownersBefore []crypto.PublicKey) Data {
+	if version == VERSION_20 && fulfills != nil {
+		fulfills = Data{
+			"output_index":   fulfills.GetInt("output"),
+			"transaction_id": fulfills.GetStr("txid"),
+		}
+	}
 	return Data{
 		"fulfillment":   nil,
 		"fulfills":      fulfills,
@@ -353,23 +1523,30 @@ func NewInput(fulfills Data, ownersBefore []crypto.PublicKey) Data {
 	}
 }
 
-func NewOutputs(amounts []int, ownersAfter [][]crypto.PublicKey) []Data {
+func NewOutputs(version string, amounts []int, ownersAfter [][]crypto.PublicKey) []Data {
 	n := len(amounts)
 	if n != len(ownersAfter) {
 		panic(ErrorAppend(ErrInvalidSize, "slices are different sizes"))
 	}
 	outputs := make([]Data, n)
 	for i, owner := range ownersAfter {
-		outputs[i] = NewOutput(amounts[i], owner)
+		outputs[i] = NewOutput(version, amounts[i], owner)
 	}
 	return outputs
 }
 
-func NewOutput(amount int, ownersAfter []crypto.PublicKey) Data {
+func NewOutput(version string, amount int, ownersAfter []crypto.PublicKey) Data {
 	n := len(ownersAfter)
 	if n == 0 {
 		return nil
 	}
+	if version == VERSION_20 {
+		return Data{
+			"amount":      Itoa(amount),
+			"condition":   NewConditionV2(ownersAfter),
+			"public_keys": ownersAfter,
+		}
+	}
 	if n == 1 {
 		return Data{
 			"amount":      amount,
@@ -383,3 +1560,13 @@ func NewOutput(amount int, ownersAfter []crypto.PublicKey) Data {
 		"public_keys": ownersAfter,
 	}
 }
+
+// Version 2.0 output condition, a threshold condition if there are multiple owners
+
+func NewConditionV2(ownersAfter []crypto.PublicKey) Data {
+	f := conds.FulfillmentV2FromPubKeys(ownersAfter)
+	return Data{
+		"details": f.Details(),
+		"uri":     f.Condition().String(),
+	}
+}
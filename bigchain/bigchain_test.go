@@ -1,7 +1,12 @@
 package bigchain
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/zbo14/envoke/common"
 	"github.com/zbo14/envoke/crypto/crypto"
@@ -14,6 +19,9 @@ var (
 )
 
 func TestBigchain(t *testing.T) {
+	server := httptest.NewServer(NewLedger())
+	defer server.Close()
+	cli := NewClient(server.URL + "/")
 	output := MustOpenWriteFile("output.json")
 	// Keys
 	privAlice, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
@@ -21,53 +29,567 @@ func TestBigchain(t *testing.T) {
 	// Data
 	data := Data{"bees": "knees"}
 	// Individual create tx
-	tx := IndividualCreateTx(100, data, pubAlice, pubAlice)
+	tx := cli.IndividualCreateTx(100, data, nil, pubAlice, pubAlice)
 	FulfillTx(tx, privAlice)
 	// Check that it's fulfilled
 	if !FulfilledTx(tx) {
 		t.Error(ErrInvalidFulfillment)
 	}
 	WriteJSON(output, Data{"createTx": tx})
-	createTxId, err := PostTx(tx)
+	createTxId, err := cli.PostTx(tx)
 	if err != nil {
 		t.Fatal(err)
 	}
 	// Divisible transfer tx
-	tx = DivisibleTransferTx([]int{40, 60}, createTxId, createTxId, 0, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
+	tx = cli.DivisibleTransferTx([]int{40, 60}, createTxId, createTxId, nil, 0, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
 	FulfillTx(tx, privAlice)
 	if !FulfilledTx(tx) {
 		t.Error(ErrInvalidFulfillment)
 	}
-	transferTxId, err := PostTx(tx)
+	transferTxId, err := cli.PostTx(tx)
 	if err != nil {
 		t.Fatal(err)
 	}
 	Println(transferTxId)
 	WriteJSON(output, Data{"transfer1Tx": tx})
 	// Transfer Bob's output of divisible transfer to Alice
-	tx = IndividualTransferTx(60, createTxId, transferTxId, 1, pubAlice, pubBob)
+	tx = cli.IndividualTransferTx(60, createTxId, transferTxId, nil, 1, pubAlice, pubBob)
 	FulfillTx(tx, privBob)
 	if !FulfilledTx(tx) {
 		t.Error(ErrInvalidFulfillment)
 	}
 	PrintJSON(tx)
-	if _, err = PostTx(tx); err != nil {
+	if _, err = cli.PostTx(tx); err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"transfer2Tx": tx})
+	// Try to spend Bob's output again
+	tx = cli.IndividualTransferTx(60, createTxId, transferTxId, nil, 1, pubBob, pubBob)
+	FulfillTx(tx, privBob)
+	if _, err = cli.PostTx(tx); err == nil {
+		t.Error("Expected double spend to be rejected")
+	} else if txErr, ok := err.(*TxError); !ok || txErr.Type != DOUBLE_SPEND || txErr.Status != http.StatusBadRequest {
+		t.Error(err)
+	}
+	// Try to spend the same output twice in one tx
+	tx = cli.IndividualCreateTx(10, Data{"twice": true}, nil, pubAlice, pubAlice)
+	FulfillTx(tx, privAlice)
+	doubleId, err := cli.PostTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = cli.ConsolidatedTransferTx([]int{20}, doubleId, []int{10, 10}, []string{doubleId, doubleId}, nil, []int{0, 0}, []crypto.PublicKey{pubBob}, pubAlice)
+	FulfillTx(tx, privAlice)
+	if _, err = cli.PostTx(tx); err == nil {
+		t.Error("Expected output consumed twice to be rejected")
+	} else if txErr, ok := err.(*TxError); !ok || txErr.Type != DOUBLE_SPEND {
+		t.Error(err)
+	}
 	// Multiple owners create tx
-	tx = MultipleOwnersCreateTx([]int{2, 3}, data, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
+	tx = cli.MultipleOwnersCreateTx([]int{2, 3}, data, nil, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
 	FulfillTx(tx, privAlice)
 	if !FulfilledTx(tx) {
 		t.Error(ErrInvalidFulfillment)
 	}
-	multipleOwnersTxId, err := PostTx(tx)
+	multipleOwnersTxId, err := cli.PostTx(tx)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"multipleOwnersTx": tx})
-	tx, err = GetTx(multipleOwnersTxId)
+	tx, err = cli.GetTx(multipleOwnersTxId)
 	if err != nil {
 		t.Fatal(err)
 	}
+	// Bob's output of divisible transfer is spent
+	outputs, err := cli.ListUnspentOutputs(pubBob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 1 || outputs[0].GetStr("txid") != multipleOwnersTxId || outputs[0].GetInt("output") != 1 {
+		t.Error("Expected Bob's output of multiple owners tx")
+	}
+	// Text search over asset data
+	assets, err := cli.SearchAssets("KNEES", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 2 || GetId(assets[0]) != createTxId {
+		t.Error("Expected assets matching search")
+	}
+	if assets, err = cli.SearchAssets("knees", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 1 {
+		t.Error("Expected search results cut to limit")
+	}
+}
+
+// Expected ids computed independently in python3 with
+// hashlib.sha3_256(json.dumps(body, sort_keys=True, separators=(',', ':'), ensure_ascii=False))
+
+func TestTxIds(t *testing.T) {
+	cli := NewClient("")
+	_, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
+	_, pubBob := ed25519.GenerateKeypairFromSeed(BytesFromB58(Bob))
+	createTx := cli.IndividualCreateTx(100, Data{"bees": "knees", "é": 1.5}, nil, pubAlice, pubAlice)
+	if id := GetId(createTx); id != "b8fc41d8f77850440570b08b50b0ef97412d68874a433d990da6cc1882004040" {
+		t.Errorf("Unexpected CREATE tx id %s", id)
+	}
+	transferTx := cli.DivisibleTransferTx([]int{40, 60}, GetId(createTx), GetId(createTx), nil, 0, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
+	if id := GetId(transferTx); id != "964230697976adef49fc6e1144e0ad0d5dc6613e5e263cd807c17e551270b8c9" {
+		t.Errorf("Unexpected TRANSFER tx id %s", id)
+	}
+}
+
+// Expected ids computed independently in python3, following bigchaindb 2.0
+
+func TestBigchainV2(t *testing.T) {
+	server := httptest.NewServer(NewLedger())
+	defer server.Close()
+	cli := NewClient(server.URL + "/")
+	if err := cli.SetVersion(VERSION_20); err != nil {
+		t.Fatal(err)
+	}
+	privAlice, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
+	privBob, pubBob := ed25519.GenerateKeypairFromSeed(BytesFromB58(Bob))
+	tx := cli.IndividualCreateTx(100, Data{"bees": "knees"}, nil, pubAlice, pubAlice)
+	FulfillTx(tx, privAlice)
+	createTxId := GetId(tx)
+	if createTxId != "5dec617355d36e0ab5dc99a34bf8f2ae7d255bfa63dfc21bb3be49a8a4d33d3b" {
+		t.Errorf("Unexpected CREATE tx id %s", createTxId)
+	}
+	if !FulfilledTx(tx) {
+		t.Error(ErrInvalidFulfillment)
+	}
+	if _, err := cli.PostTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	tx = cli.DivisibleTransferTx([]int{40, 60}, createTxId, createTxId, nil, 0, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
+	FulfillTx(tx, privAlice)
+	transferTxId := GetId(tx)
+	if transferTxId != "67dc469f06934f45094636202918a758087ed95ae26539d802c189faaeb8acc2" {
+		t.Errorf("Unexpected TRANSFER tx id %s", transferTxId)
+	}
+	if _, err := cli.PostTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := cli.GetTx(transferTxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetTxVersion(tx) != VERSION_20 || GetTxOutputAmount(tx, 1) != 60 {
+		t.Error("Expected version 2.0 tx with 60 shares for Bob")
+	}
+	if consumeId, n := GetInputFulfills(GetTxInputs(tx)[0]); consumeId != createTxId || n != 0 {
+		t.Error("Expected transfer to consume create output")
+	}
+	outputs, err := cli.ListUnspentOutputs(pubBob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 1 || outputs[0].GetStr("txid") != transferTxId || outputs[0].GetInt("output") != 1 {
+		t.Error("Expected Bob's output of transfer tx")
+	}
+	// Bob signs for Alice's output
+	tx = cli.IndividualTransferTx(40, createTxId, transferTxId, nil, 0, pubBob, pubAlice)
+	FulfillTx(tx, privBob)
+	if _, err = cli.PostTx(tx); err == nil {
+		t.Error("Expected wrong signer to be rejected")
+	}
+	// Multiple owners output with threshold condition
+	tx = cli.MultipleOwnersCreateTx([]int{1}, Data{"birds": "words"}, nil, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
+	FulfillTx(tx, privAlice)
+	if _, err = cli.PostTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	if pubs := GetOutputPublicKeys(GetTxOutput(tx, 0)); len(pubs) != 2 {
+		t.Error("Expected threshold output with 2 public keys")
+	}
+	// Legacy txs are still read and verified, and can be built
+	// alongside 2.0 txs
+	legacy := NewClient(server.URL + "/")
+	tx = legacy.IndividualCreateTx(1, Data{"bees": "knees"}, nil, pubAlice, pubAlice)
+	FulfillTx(tx, privAlice)
+	legacyTxId, err := legacy.PostTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli.GetTx(legacyTxId); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Node that serves whatever txs it's given, tampered or not
+
+type txServer map[string]Data
+
+func (s txServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	tx, ok := s[strings.TrimPrefix(req.URL.Path, "/transactions/")]
+	if !ok {
+		ledgerError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	ledgerJSON(w, http.StatusOK, tx)
+}
+
+func TestVerifyTx(t *testing.T) {
+	txs := make(txServer)
+	server := httptest.NewServer(txs)
+	defer server.Close()
+	cli := NewClient(server.URL + "/")
+	privAlice, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
+	privBob, pubBob := ed25519.GenerateKeypairFromSeed(BytesFromB58(Bob))
+	createTx := cli.IndividualCreateTx(100, Data{"bees": "knees"}, nil, pubAlice, pubAlice)
+	FulfillTx(createTx, privAlice)
+	createTxId := GetId(createTx)
+	otherTx := cli.IndividualCreateTx(100, Data{"birds": "words"}, nil, pubAlice, pubAlice)
+	FulfillTx(otherTx, privAlice)
+	otherTxId := GetId(otherTx)
+	txs[createTxId] = createTx
+	txs[otherTxId] = otherTx
+	if _, err := cli.GetTx(createTxId); err != nil {
+		t.Fatal(err)
+	}
+	// Served under another id
+	txs["abc"] = createTx
+	if _, err := cli.GetTx("abc"); err == nil {
+		t.Error("Expected tx id error")
+	} else if _, ok := err.(*TxIdError); !ok {
+		t.Error(err)
+	}
+	// Body changed after signing
+	tampered := cli.IndividualCreateTx(100, Data{"bees": "knees"}, nil, pubAlice, pubAlice)
+	FulfillTx(tampered, privAlice)
+	tampered.Set("asset", Data{"data": Data{"bees": "wax"}})
+	txs[createTxId] = tampered
+	if _, err := cli.GetTx(createTxId); err == nil {
+		t.Error("Expected tx id error")
+	} else if _, ok := err.(*TxIdError); !ok {
+		t.Error(err)
+	}
+	txs[createTxId] = createTx
+	// Transfer claims a different asset than the output it spends
+	transferTx := cli.IndividualTransferTx(100, otherTxId, createTxId, nil, 0, pubBob, pubAlice)
+	FulfillTx(transferTx, privAlice)
+	transferTxId := GetId(transferTx)
+	txs[transferTxId] = transferTx
+	if _, err := cli.GetTx(transferTxId); err == nil {
+		t.Error("Expected asset id error")
+	} else if _, ok := err.(*AssetIdError); !ok {
+		t.Error(err)
+	}
+	// Signed by a key that isn't its owner before
+	forged := cli.IndividualCreateTx(100, Data{"bees": "knees"}, nil, pubBob, pubAlice)
+	FulfillTx(forged, privBob)
+	txs[GetId(forged)] = forged
+	if _, err := cli.GetTx(GetId(forged)); err == nil {
+		t.Error("Expected tx signed by non-owner to be rejected")
+	}
+	// Spends an output its owners before don't hold
+	stolen := cli.IndividualTransferTx(100, createTxId, createTxId, nil, 0, pubBob, pubBob)
+	FulfillTx(stolen, privBob)
+	txs[GetId(stolen)] = stolen
+	if _, err := cli.GetTx(GetId(stolen)); err == nil {
+		t.Error("Expected input error")
+	} else if _, ok := err.(*InputError); !ok {
+		t.Error(err)
+	}
+	// Spends an output that doesn't exist
+	missing := cli.IndividualTransferTx(100, createTxId, createTxId, nil, 1, pubBob, pubAlice)
+	FulfillTx(missing, privAlice)
+	txs[GetId(missing)] = missing
+	if _, err := cli.GetTx(GetId(missing)); err == nil {
+		t.Error("Expected input error")
+	} else if _, ok := err.(*InputError); !ok {
+		t.Error(err)
+	}
+	// Unsigned, with no inputs, but the id matches the body
+	unsigned := NewTx(VERSION_09, Data{"data": Data{"bees": "knees"}}, nil, nil, CREATE, NewOutputs(VERSION_09, []int{1}, [][]crypto.PublicKey{{pubAlice}}))
+	txs[GetId(unsigned)] = unsigned
+	if _, err := cli.GetTx(GetId(unsigned)); err == nil {
+		t.Error("Expected tx with no inputs to be rejected")
+	}
+	// Signed, with no outputs
+	noOutputs := NewTx(VERSION_09, Data{"data": Data{"bees": "knees"}}, NewInputs(VERSION_09, []Data{nil}, [][]crypto.PublicKey{{pubAlice}}), nil, CREATE, nil)
+	FulfillTx(noOutputs, privAlice)
+	txs[GetId(noOutputs)] = noOutputs
+	if _, err := cli.GetTx(GetId(noOutputs)); err == nil {
+		t.Error("Expected tx with no outputs to be rejected")
+	}
+}
+
+func TestPartiallyFulfillTx(t *testing.T) {
+	privAlice, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
+	privBob, pubBob := ed25519.GenerateKeypairFromSeed(BytesFromB58(Bob))
+	privCarol, _ := ed25519.GenerateKeypair()
+	for _, v := range []string{VERSION_09, VERSION_20} {
+		server := httptest.NewServer(NewLedger())
+		cli := NewClient(server.URL + "/")
+		if err := cli.SetVersion(v); err != nil {
+			t.Fatal(err)
+		}
+		tx := cli.MultipleOwnersCreateTx([]int{10}, Data{"bees": "knees"}, nil, []crypto.PublicKey{pubAlice, pubBob}, pubAlice)
+		FulfillTx(tx, privAlice)
+		createTxId, err := cli.PostTx(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx = cli.MultipleOwnersTransferTx([]int{4, 6}, createTxId, createTxId, nil, 0, []crypto.PublicKey{pubAlice, pubBob}, []crypto.PublicKey{pubAlice, pubBob})
+		if err = PartiallyFulfillTx(tx, privCarol); err == nil {
+			t.Error("Expected signature from non-owner to be rejected")
+		}
+		if err = PartiallyFulfillTx(tx, privAlice); err != nil {
+			t.Fatal(err)
+		}
+		if FulfilledTx(tx) {
+			t.Error("Expected threshold not to be met")
+		}
+		if _, err = cli.PostTx(tx); err == nil {
+			t.Error("Expected partially fulfilled tx to be rejected")
+		}
+		// Export the tx to Bob, who signs client-side
+		exported := make(Data)
+		MustUnmarshalJSON(MustMarshalJSON(tx), &exported)
+		msgs, err := PartialTxMessages(exported, pubBob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs := make([]crypto.Signature, len(msgs))
+		for i, msg := range msgs {
+			sigs[i] = privAlice.Sign(msg)
+		}
+		if err = PartiallyFulfillTxWithSignatures(exported, pubBob, sigs); err == nil {
+			t.Error("Expected signatures from another owner to be rejected")
+		}
+		for i, msg := range msgs {
+			sigs[i] = privBob.Sign(msg)
+		}
+		if err = PartiallyFulfillTxWithSignatures(exported, pubBob, sigs); err != nil {
+			t.Fatal(err)
+		}
+		if !FulfilledTx(exported) {
+			t.Fatal(ErrInvalidFulfillment)
+		}
+		transferTxId, err := cli.PostTx(exported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.GetTx(transferTxId); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+	}
+}
+
+func TestFulfillTxWithSignatures(t *testing.T) {
+	privAlice, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
+	privBob, pubBob := ed25519.GenerateKeypairFromSeed(BytesFromB58(Bob))
+	// The client signs the messages of the exported tx
+	clientSign := func(tx Data, priv crypto.PrivateKey) (Data, error) {
+		exported := make(Data)
+		MustUnmarshalJSON(MustMarshalJSON(tx), &exported)
+		msgs, err := TxMessages(exported)
+		if err != nil {
+			return nil, err
+		}
+		sigs := make([]crypto.Signature, len(msgs))
+		for i, msg := range msgs {
+			sigs[i] = priv.Sign(msg)
+		}
+		return exported, FulfillTxWithSignatures(exported, sigs)
+	}
+	for _, v := range []string{VERSION_09, VERSION_20} {
+		server := httptest.NewServer(NewLedger())
+		cli := NewClient(server.URL + "/")
+		if err := cli.SetVersion(v); err != nil {
+			t.Fatal(err)
+		}
+		tx := cli.DefaultIndividualCreateTx(Data{"bees": "knees"}, nil, pubAlice)
+		if _, err := clientSign(tx, privBob); err == nil {
+			t.Error("Expected signature from non-owner to be rejected")
+		}
+		// Malformed owners before are errors, not panics
+		for _, owners := range [][]interface{}{{"abc"}, {}} {
+			exported := make(Data)
+			MustUnmarshalJSON(MustMarshalJSON(tx), &exported)
+			GetTxInputs(exported)[0].Set("owners_before", owners)
+			if err := FulfillTxWithSignatures(exported, []crypto.Signature{privAlice.Sign(nil)}); err == nil {
+				t.Errorf("Expected input with owners before %v to be rejected", owners)
+			}
+		}
+		signed, err := clientSign(tx, privAlice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		FulfillTx(tx, privAlice)
+		if GetId(signed) != GetId(tx) {
+			t.Error("Expected same tx as fulfilled server-side")
+		}
+		createTxId, err := cli.PostTx(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx = cli.DefaultIndividualTransferTx(createTxId, createTxId, nil, 0, pubBob, pubAlice)
+		if signed, err = clientSign(tx, privAlice); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.PostTx(signed); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+	}
+}
+
+// Node that reports txs in the backlog before committing them
+
+type statusServer struct {
+	polls int
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/statuses" {
+		ledgerError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if s.polls++; s.polls < 3 {
+		ledgerJSON(w, http.StatusOK, Data{"status": STATUS_BACKLOG})
+		return
+	}
+	ledgerJSON(w, http.StatusOK, Data{"status": STATUS_VALID})
+}
+
+func TestPostTxMode(t *testing.T) {
+	server := httptest.NewServer(NewLedger())
+	defer server.Close()
+	cli := NewClient(server.URL + "/")
+	privAlice, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
+	if err := cli.SetMode("eventually"); err == nil {
+		t.Error("Expected invalid mode to be rejected")
+	}
+	if err := cli.SetMode(MODE_COMMIT); err != nil {
+		t.Fatal(err)
+	}
+	tx := cli.IndividualCreateTx(100, Data{"bees": "knees"}, nil, pubAlice, pubAlice)
+	FulfillTx(tx, privAlice)
+	txId, err := cli.PostTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cli.WaitForCommit(txId, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli.PostTx(tx); err == nil {
+		t.Error("Expected duplicate tx to be rejected")
+	} else if txErr, ok := err.(*TxError); !ok || txErr.Type != DUPLICATE_TRANSACTION {
+		t.Error(err)
+	}
+	if err = cli.WaitForCommit("abc", 300*time.Millisecond); err == nil {
+		t.Error("Expected unknown tx to time out")
+	}
+	statuses := &statusServer{}
+	server = httptest.NewServer(statuses)
+	defer server.Close()
+	cli = NewClient(server.URL + "/")
+	if err = cli.WaitForCommit(txId, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if statuses.polls != 3 {
+		t.Errorf("Expected 3 polls, got %d", statuses.polls)
+	}
+	// Node without a statuses endpoint
+	txs := txServer{txId: tx}
+	server = httptest.NewServer(txs)
+	defer server.Close()
+	cli = NewClient(server.URL + "/")
+	if err = cli.WaitForCommit(txId, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := cli.GetTxStatus("abc"); err != nil || status != "" {
+		t.Errorf("Expected unknown tx to have no status, got %q, %v", status, err)
+	}
+	txs["abc"] = tx
+	if err = cli.WaitForCommit("abc", 5*time.Second); err == nil {
+		t.Error("Expected tx id error")
+	} else if _, ok := err.(*TxIdError); !ok {
+		t.Error(err)
+	}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/statuses" {
+			ledgerError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		ledgerError(w, http.StatusInternalServerError, "Internal error")
+	}))
+	defer server.Close()
+	cli = NewClient(server.URL + "/")
+	if err = cli.WaitForCommit(txId, 5*time.Second); err == nil {
+		t.Error("Expected server error")
+	} else if strings.HasPrefix(err.Error(), ErrTimeout.Error()) {
+		t.Error(err)
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	ledger := NewLedger()
+	server := httptest.NewServer(ledger)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cli := NewClient(server.URL + "/").WithContext(ctx)
+	privAlice, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
+	events := make(chan Data)
+	sub := cli.NewSubscriber()
+	done := make(chan error)
+	go func() {
+		done <- sub.Run(func(event Data) error {
+			events <- event
+			return nil
+		})
+	}()
+	// Waits for the subscriber to (re)connect, then posts a tx
+	post := func(data Data) string {
+		for i := 0; ; i++ {
+			ledger.mtx.Lock()
+			n := len(ledger.streams)
+			ledger.mtx.Unlock()
+			if n > 0 {
+				break
+			}
+			if i == 100 {
+				t.Fatal("Expected subscriber to connect")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		tx := cli.IndividualCreateTx(1, data, nil, pubAlice, pubAlice)
+		FulfillTx(tx, privAlice)
+		txId, err := cli.PostTx(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return txId
+	}
+	receive := func(txId string) {
+		select {
+		case event := <-events:
+			if event.GetStr("transaction_id") != txId || event.GetStr("asset_id") != txId {
+				t.Error("Expected event for posted tx")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected event")
+		}
+	}
+	receive(post(Data{"bees": "knees"}))
+	// Subscriber reconnects after the node drops the stream
+	ledger.CloseStreams()
+	receive(post(Data{"birds": "words"}))
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected context canceled, got %v", err)
+	}
+	// Rejected handshakes aren't retried
+	sub = NewClient(server.URL + "/").NewSubscriber()
+	sub.SetEndpoint(strings.Replace(server.URL, "http", "ws", 1) + "/streams/nope")
+	err := sub.Run(func(Data) error { return nil })
+	if herr, ok := err.(*HandshakeError); !ok || herr.Status != http.StatusNotFound {
+		t.Errorf("Expected handshake error, got %v", err)
+	}
 }
@@ -0,0 +1,459 @@
+package bigchain
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/zbo14/envoke/common"
+	"golang.org/x/net/websocket"
+)
+
+// In-memory stand-in for a BigchainDB node
+// Serves transactions/, outputs/, assets/, statuses/ and the valid tx
+// stream so it can be mounted
+// at the root of an httptest.Server, e.g.
+//
+//	server := httptest.NewServer(NewLedger())
+//	cli := NewClient(server.URL + "/")
+
+// Subscribers that don't read events within this are dropped
+
+const STREAM_WRITE_TIMEOUT = 5 * time.Second
+
+type Ledger struct {
+	backlog map[string]struct{}
+	delay   time.Duration
+	mtx     sync.Mutex
+	order   []string
+	spent   map[string]string
+	streams map[*websocket.Conn]struct{}
+	txs     map[string][]byte
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{
+		backlog: make(map[string]struct{}),
+		spent:   make(map[string]string),
+		streams: make(map[*websocket.Conn]struct{}),
+		txs:     make(map[string][]byte),
+	}
+}
+
+// Txs posted in async or sync mode stay in the backlog for delay
+// before they're committed, as on a node, e.g. to test that clients
+// wait for commits. Txs posted in commit mode are committed at once
+
+func (l *Ledger) SetCommitDelay(delay time.Duration) {
+	l.mtx.Lock()
+	l.delay = delay
+	l.mtx.Unlock()
+}
+
+func (l *Ledger) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	parts := SplitStr(path, "/")
+	switch {
+	case path == "transactions" && req.Method == http.MethodPost:
+		l.postTx(w, req)
+	case path == "transactions" && req.Method == http.MethodGet:
+		l.listTxs(w, req)
+	case len(parts) == 2 && parts[0] == "transactions" && req.Method == http.MethodGet:
+		l.getTx(w, parts[1])
+	case path == "outputs" && req.Method == http.MethodGet:
+		l.listOutputs(w, req)
+	case path == "assets" && req.Method == http.MethodGet:
+		l.searchAssets(w, req)
+	case path == "statuses" && req.Method == http.MethodGet:
+		l.getStatus(w, req)
+	case path == STREAM_VALID_TXS && req.Method == http.MethodGet:
+		l.streamTxs(w, req)
+	default:
+		ledgerError(w, http.StatusNotFound, "Not found: "+req.Method+" "+req.URL.Path)
+	}
+}
+
+func ledgerError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	WriteJSON(w, Data{"message": msg, "status": status})
+}
+
+func ledgerJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	WriteJSON(w, v)
+}
+
+// Each call returns a fresh copy since FulfilledTx mutates the tx
+
+func (l *Ledger) tx(txId string) Data {
+	p, ok := l.txs[txId]
+	if !ok {
+		return nil
+	}
+	tx := make(Data)
+	MustUnmarshalJSON(p, &tx)
+	return tx
+}
+
+func (l *Ledger) getTx(w http.ResponseWriter, txId string) {
+	l.mtx.Lock()
+	p, ok := l.txs[txId]
+	l.mtx.Unlock()
+	if !ok {
+		ledgerError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(p)
+}
+
+func (l *Ledger) listTxs(w http.ResponseWriter, req *http.Request) {
+	values := req.URL.Query()
+	assetId := values.Get("asset_id")
+	if EmptyStr(assetId) {
+		ledgerError(w, http.StatusBadRequest, "Missing asset_id")
+		return
+	}
+	operation := values.Get("operation")
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	txs := []Data{}
+	for _, txId := range l.order {
+		tx := l.tx(txId)
+		if !EmptyStr(operation) && operation != GetTxOperation(tx) {
+			continue
+		}
+		if assetId == txId || assetId == GetTxAssetId(tx) {
+			txs = append(txs, tx)
+		}
+	}
+	ledgerJSON(w, http.StatusOK, txs)
+}
+
+func (l *Ledger) listOutputs(w http.ResponseWriter, req *http.Request) {
+	values := req.URL.Query()
+	pub := values.Get("public_key")
+	if EmptyStr(pub) {
+		ledgerError(w, http.StatusBadRequest, "Missing public_key")
+		return
+	}
+	spent := values.Get("spent")
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	// 2.0 nodes return objects instead of links
+	links := []interface{}{}
+	for _, txId := range l.order {
+		tx := l.tx(txId)
+		for i, output := range GetTxOutputs(tx) {
+			if !outputHasKey(output, pub) {
+				continue
+			}
+			_, isSpent := l.spent[outputKey(txId, i)]
+			if (spent == "true" && !isSpent) || (spent == "false" && isSpent) {
+				continue
+			}
+			if GetTxVersion(tx) == VERSION_20 {
+				links = append(links, Data{"output_index": i, "transaction_id": txId})
+			} else {
+				links = append(links, Sprintf("../transactions/%s/outputs/%d", txId, i))
+			}
+		}
+	}
+	ledgerJSON(w, http.StatusOK, links)
+}
+
+func (l *Ledger) searchAssets(w http.ResponseWriter, req *http.Request) {
+	values := req.URL.Query()
+	search := ToLower(values.Get("search"))
+	if EmptyStr(search) {
+		ledgerError(w, http.StatusBadRequest, "Missing search")
+		return
+	}
+	limit, _ := Atoi(values.Get("limit"))
+	terms := strings.Fields(search)
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	assets := []Data{}
+	for _, txId := range l.order {
+		tx := l.tx(txId)
+		if GetTxOperation(tx) != CREATE {
+			continue
+		}
+		text := ToLower(string(MustMarshalJSON(GetTxData(tx))))
+		match := true
+		for _, term := range terms {
+			if !strings.Contains(text, term) {
+				match = false
+				break
+			}
+		}
+		if match {
+			assets = append(assets, Data{"data": GetTxData(tx), "id": txId})
+			if limit > 0 && len(assets) == limit {
+				break
+			}
+		}
+	}
+	ledgerJSON(w, http.StatusOK, assets)
+}
+
+// Every tx is valid once posted, so events are sent as txs are posted
+// The connection is read until the subscriber leaves, answering pings
+
+func (l *Ledger) streamTxs(w http.ResponseWriter, req *http.Request) {
+	websocket.Server{Handler: l.serveStream}.ServeHTTP(w, req)
+}
+
+func (l *Ledger) serveStream(ws *websocket.Conn) {
+	l.mtx.Lock()
+	l.streams[ws] = struct{}{}
+	l.mtx.Unlock()
+	var p []byte
+	for websocket.Message.Receive(ws, &p) == nil {
+	}
+	l.mtx.Lock()
+	delete(l.streams, ws)
+	l.mtx.Unlock()
+	ws.Close()
+}
+
+// Called without the lock, so a stalled subscriber only holds up
+// its own stream until the write deadline
+
+func (l *Ledger) broadcast(streams []*websocket.Conn, tx Data) {
+	event := string(MustMarshalJSON(Data{
+		"asset_id":       GetTxAssetIdOrId(tx),
+		"transaction_id": GetId(tx),
+	}))
+	for _, ws := range streams {
+		ws.SetWriteDeadline(time.Now().Add(STREAM_WRITE_TIMEOUT))
+		if err := websocket.Message.Send(ws, event); err != nil {
+			l.mtx.Lock()
+			delete(l.streams, ws)
+			l.mtx.Unlock()
+			ws.Close()
+		}
+	}
+}
+
+// Closes every stream, e.g. to test that subscribers reconnect
+
+func (l *Ledger) CloseStreams() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for ws := range l.streams {
+		delete(l.streams, ws)
+		ws.Close()
+	}
+}
+
+// Txs are committed before the response in every mode, unless
+// there's a commit delay
+
+func (l *Ledger) getStatus(w http.ResponseWriter, req *http.Request) {
+	txId := req.URL.Query().Get("transaction_id")
+	l.mtx.Lock()
+	_, ok := l.txs[txId]
+	_, inBacklog := l.backlog[txId]
+	l.mtx.Unlock()
+	if inBacklog {
+		ledgerJSON(w, http.StatusOK, Data{"status": STATUS_BACKLOG})
+		return
+	}
+	if !ok {
+		ledgerError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	ledgerJSON(w, http.StatusOK, Data{"status": STATUS_VALID})
+}
+
+func (l *Ledger) postTx(w http.ResponseWriter, req *http.Request) {
+	mode := req.URL.Query().Get("mode")
+	switch mode {
+	case "", MODE_ASYNC, MODE_COMMIT, MODE_SYNC:
+	default:
+		ledgerError(w, http.StatusBadRequest, "Invalid mode "+mode)
+		return
+	}
+	p, err := ReadAll(req.Body)
+	if err != nil {
+		ledgerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tx := make(Data)
+	if err = UnmarshalJSON(p, &tx); err != nil {
+		ledgerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	l.mtx.Lock()
+	if err = l.validateTx(tx); err != nil {
+		l.mtx.Unlock()
+		txErr, ok := err.(*TxError)
+		if !ok {
+			txErr = &TxError{Type: VALIDATION_ERROR, Message: err.Error()}
+		}
+		ledgerError(w, http.StatusBadRequest, Sprintf("Invalid transaction (%s): %s", txErr.Type, txErr.Message))
+		return
+	}
+	if delay := l.delay; delay > 0 && mode != MODE_COMMIT {
+		txId := GetId(tx)
+		l.backlog[txId] = struct{}{}
+		l.mtx.Unlock()
+		time.AfterFunc(delay, func() {
+			l.mtx.Lock()
+			delete(l.backlog, txId)
+			l.commitTx(p, tx)
+		})
+		ledgerJSON(w, http.StatusAccepted, tx)
+		return
+	}
+	l.commitTx(p, tx)
+	ledgerJSON(w, http.StatusAccepted, tx)
+}
+
+// Called with the lock, which it releases before broadcasting
+
+func (l *Ledger) commitTx(p []byte, tx Data) {
+	txId := GetId(tx)
+	for _, input := range GetTxInputs(tx) {
+		if consumeId, n := GetInputFulfills(input); !EmptyStr(consumeId) {
+			l.spent[outputKey(consumeId, n)] = txId
+		}
+	}
+	l.txs[txId] = p
+	l.order = append(l.order, txId)
+	streams := make([]*websocket.Conn, 0, len(l.streams))
+	for ws := range l.streams {
+		streams = append(streams, ws)
+	}
+	l.mtx.Unlock()
+	l.broadcast(streams, tx)
+}
+
+// Validation rules mirror those of a BigchainDB node:
+// the id must match the tx body, every input must be fulfilled,
+// consumed outputs must exist, be unspent, be consumed once and belong
+// to the asset,
+// and TRANSFER amounts must balance
+// Errors are TxErrors named after the BigchainDB exceptions
+
+func invalidTx(_type string, err error) *TxError {
+	return &TxError{Type: _type, Message: err.Error()}
+}
+
+func (l *Ledger) validateTx(tx Data) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Errorf("%v", r)
+		}
+	}()
+	txId := GetId(tx)
+	_, inBacklog := l.backlog[txId]
+	if _, ok := l.txs[txId]; ok || inBacklog {
+		return invalidTx(DUPLICATE_TRANSACTION, ErrorAppend(ErrInvalidId, "tx already exists"))
+	}
+	txVersion := GetTxVersion(tx)
+	if txVersion != VERSION_09 && txVersion != VERSION_20 {
+		return ErrorAppend(ErrInvalidType, "unsupported tx version "+txVersion)
+	}
+	if err := VerifyTxId(txId, tx); err != nil {
+		return invalidTx(INVALID_HASH, err)
+	}
+	inputs := GetTxInputs(tx)
+	if len(inputs) == 0 {
+		return ErrorAppend(ErrInvalidSize, "tx must have inputs")
+	}
+	uris := make([]string, len(inputs))
+	for i, input := range inputs {
+		uris[i] = input.GetStr("fulfillment")
+	}
+	if !FulfilledTx(tx) {
+		return invalidTx(INVALID_SIGNATURE, ErrInvalidFulfillment)
+	}
+	outputs := GetTxOutputs(tx)
+	if len(outputs) == 0 {
+		return ErrorAppend(ErrInvalidSize, "tx must have outputs")
+	}
+	amountOut := 0
+	for _, output := range outputs {
+		amount := GetOutputAmount(output)
+		if amount <= 0 {
+			return invalidTx(AMOUNT_ERROR, ErrorAppend(ErrCriteriaNotMet, "output amount must be greater than 0"))
+		}
+		amountOut += amount
+	}
+	switch GetTxOperation(tx) {
+	case CREATE:
+		for i, input := range inputs {
+			if input.Get("fulfills") != nil {
+				return ErrorAppend(ErrCriteriaNotMet, "CREATE tx inputs cannot fulfill outputs")
+			}
+			condition, err := fulfillmentCondition(txVersion, uris[i])
+			if err != nil {
+				return err
+			}
+			if condition != ownersCondition(txVersion, GetInputPublicKeys(input)) {
+				return invalidTx(INVALID_SIGNATURE, ErrorAppend(ErrInvalidFulfillment, "fulfillment does not match owners before"))
+			}
+		}
+	case TRANSFER:
+		assetId := GetTxAssetId(tx)
+		amountIn := 0
+		consuming := make(map[string]struct{}, len(inputs))
+		for i, input := range inputs {
+			consumeId, n := GetInputFulfills(input)
+			if EmptyStr(consumeId) {
+				return ErrorAppend(ErrCriteriaNotMet, "TRANSFER tx inputs must fulfill outputs")
+			}
+			consumed := l.tx(consumeId)
+			if consumed == nil {
+				return invalidTx(INPUT_DOES_NOT_EXIST, ErrorAppend(ErrInvalidId, "input tx not found: "+consumeId))
+			}
+			if id := GetTxAssetIdOrId(consumed); assetId != id {
+				return invalidTx(ASSET_ID_MISMATCH, &AssetIdError{txId, id, assetId})
+			}
+			key := outputKey(consumeId, n)
+			if spentBy, ok := l.spent[key]; ok {
+				return invalidTx(DOUBLE_SPEND, ErrorAppend(ErrCriteriaNotMet, Sprintf("output already consumed by %s", spentBy)))
+			}
+			if _, ok := consuming[key]; ok {
+				return invalidTx(DOUBLE_SPEND, ErrorAppend(ErrCriteriaNotMet, "output consumed twice by tx"))
+			}
+			consuming[key] = struct{}{}
+			outputs := GetTxOutputs(consumed)
+			if n < 0 || n >= len(outputs) {
+				return invalidTx(INPUT_DOES_NOT_EXIST, ErrorAppend(ErrInvalidSize, "output index out of range"))
+			}
+			condition, err := fulfillmentCondition(txVersion, uris[i])
+			if err != nil {
+				return err
+			}
+			if condition != GetOutputCondition(outputs[n]).GetStr("uri") {
+				return invalidTx(INVALID_SIGNATURE, ErrorAppend(ErrInvalidFulfillment, "fulfillment does not match output condition"))
+			}
+			amountIn += GetOutputAmount(outputs[n])
+		}
+		if amountIn != amountOut {
+			return invalidTx(AMOUNT_ERROR, ErrorAppend(ErrCriteriaNotMet, Sprintf("input amount %d does not equal output amount %d", amountIn, amountOut)))
+		}
+	default:
+		return ErrorAppend(ErrInvalidType, GetTxOperation(tx))
+	}
+	return nil
+}
+
+func outputHasKey(output Data, pub string) bool {
+	for _, key := range output.GetStrSlice("public_keys") {
+		if pub == key {
+			return true
+		}
+	}
+	return false
+}
+
+func outputKey(txId string, n int) string {
+	return Sprintf("%s:%d", txId, n)
+}
@@ -0,0 +1,150 @@
+package bigchain
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/zbo14/envoke/common"
+	"golang.org/x/net/websocket"
+)
+
+// Subscriber to the node's stream of valid txs
+// Events are Data{"asset_id", "block_id", "transaction_id"}; block_id
+// is omitted by nodes that don't batch txs in blocks
+
+const (
+	BACKOFF_MAX = 30 * time.Second
+	BACKOFF_MIN = 500 * time.Millisecond
+
+	STREAM_VALID_TXS = "streams/valid_transactions"
+)
+
+type Subscriber struct {
+	cli         *Client
+	endpoint    string
+	handleError func(error)
+}
+
+// The stream endpoint defaults to the client's endpoint with a ws scheme,
+// e.g. http://localhost:9984/api/v1/ -> ws://localhost:9984/api/v1/streams/valid_transactions
+// BigchainDB serves streams on a separate port (9985) so it's often set explicitly
+
+func (cli *Client) NewSubscriber() *Subscriber {
+	endpoint := cli.endpoint
+	switch {
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint = "wss://" + strings.TrimPrefix(endpoint, "https://")
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint = "ws://" + strings.TrimPrefix(endpoint, "http://")
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	return &Subscriber{
+		cli:      cli,
+		endpoint: endpoint + STREAM_VALID_TXS,
+	}
+}
+
+func (sub *Subscriber) Endpoint() string {
+	return sub.endpoint
+}
+
+func (sub *Subscriber) SetEndpoint(endpoint string) {
+	sub.endpoint = endpoint
+}
+
+// Called with connection errors and errors returned by the event handler
+// Neither stops the subscriber
+
+func (sub *Subscriber) SetErrorHandler(handleError func(error)) {
+	sub.handleError = handleError
+}
+
+func (sub *Subscriber) reportError(err error) {
+	if sub.handleError != nil {
+		sub.handleError(err)
+	}
+}
+
+// Calls handle with each event until the client's context is done
+// Dropped connections are redialed with exponential backoff from
+// BACKOFF_MIN to BACKOFF_MAX, reset once a connection is established
+// Returns the context's error, or a HandshakeError if the node
+// rejects the request (4xx), since retrying won't help
+
+func (sub *Subscriber) Run(handle func(event Data) error) error {
+	ctx := sub.cli.ctx
+	backoff := BACKOFF_MIN
+	for {
+		connected, err := sub.stream(handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if herr, ok := err.(*HandshakeError); ok && herr.Status >= 400 && herr.Status < 500 {
+			return err
+		}
+		if err != nil {
+			sub.reportError(err)
+		}
+		if connected {
+			backoff = BACKOFF_MIN
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > BACKOFF_MAX {
+			backoff = BACKOFF_MAX
+		}
+	}
+}
+
+// Reads events until the connection drops
+// The error is nil if the node closed the connection
+
+func (sub *Subscriber) stream(handle func(event Data) error) (bool, error) {
+	ctx := sub.cli.ctx
+	header := make(http.Header)
+	if !EmptyStr(sub.cli.appId) {
+		header.Set("app_id", sub.cli.appId)
+		header.Set("app_key", sub.cli.appKey)
+	}
+	ws, err := dialWebsocket(ctx, sub.endpoint, header)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+	defer ws.Close()
+	for {
+		var p []byte
+		err := websocket.Message.Receive(ws, &p)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		event := make(Data)
+		if err = UnmarshalJSON(p, &event); err != nil {
+			sub.reportError(err)
+			continue
+		}
+		if err = handle(event); err != nil {
+			sub.reportError(err)
+		}
+	}
+}
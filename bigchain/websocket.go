@@ -0,0 +1,117 @@
+package bigchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	. "github.com/zbo14/envoke/common"
+	"golang.org/x/net/websocket"
+)
+
+// WebSocket connections for the node's event stream
+
+const WEBSOCKET_MAX_LEN = 1 << 24
+
+// Returned when the node refuses the upgrade, e.g. 404 for a wrong endpoint
+
+type HandshakeError struct {
+	Status int
+}
+
+func (err *HandshakeError) Error() string {
+	return Sprintf("websocket handshake failed with status %d", err.Status)
+}
+
+// Records the status line of the handshake response, which
+// x/net/websocket doesn't return when it refuses the upgrade
+
+type statusConn struct {
+	net.Conn
+	done bool
+	line []byte
+}
+
+func (conn *statusConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	if !conn.done {
+		if i := bytes.IndexByte(p[:n], '\n'); i >= 0 {
+			conn.line = append(conn.line, p[:i]...)
+			conn.done = true
+		} else {
+			conn.line = append(conn.line, p[:n]...)
+		}
+		if len(conn.line) > 1024 {
+			conn.done = true
+		}
+	}
+	return n, err
+}
+
+func (conn *statusConn) status() int {
+	fields := strings.Fields(string(conn.line))
+	if len(fields) < 2 {
+		return 0
+	}
+	status, _ := Atoi(fields[1])
+	return status
+}
+
+func dialWebsocket(ctx context.Context, endpoint string, header http.Header) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig(endpoint, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	config.Header = header
+	u := config.Location
+	addr := u.Host
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			addr += ":80"
+		}
+	case "wss":
+		if u.Port() == "" {
+			addr += ":443"
+		}
+	default:
+		return nil, ErrorAppend(ErrInvalidUrl, endpoint)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+	// Unblock the handshake if ctx is done
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	recorder := &statusConn{Conn: conn}
+	ws, err := websocket.NewClient(config, recorder)
+	close(done)
+	if err == websocket.ErrBadStatus {
+		conn.Close()
+		return nil, &HandshakeError{recorder.status()}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		ws.Close()
+		return nil, ctx.Err()
+	}
+	ws.MaxPayloadBytes = WEBSOCKET_MAX_LEN
+	return ws, nil
+}
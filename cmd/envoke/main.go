@@ -4,7 +4,9 @@ import (
 	"net/http"
 
 	"github.com/zbo14/envoke/api"
+	"github.com/zbo14/envoke/bigchain"
 	. "github.com/zbo14/envoke/common"
+	ld "github.com/zbo14/envoke/linked_data"
 )
 
 func main() {
@@ -47,8 +49,22 @@ func main() {
 	fs := http.Dir("static/")
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(fs)))
 
-	// Create api
-	api := api.NewApi()
+	// Create api with ledger client
+	cli := bigchain.NewClientFromEnv()
+
+	// Cache fetched txs in a local index at ENVOKE_INDEX
+	if path := Getenv("ENVOKE_INDEX"); !EmptyStr(path) {
+		index, err := ld.OpenIndex(path)
+		Check(err)
+		defer index.Close()
+		cli.SetCache(index)
+	}
+	api := api.NewApi(cli)
+
+	// Save registered and recovered credentials in ENVOKE_KEYSTORE
+	if dir := Getenv("ENVOKE_KEYSTORE"); !EmptyStr(dir) {
+		api.SetKeystoreDir(dir)
+	}
 
 	// Add routes to multiplexer
 	api.AddRoutes(mux)
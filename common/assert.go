@@ -1,6 +1,9 @@
 package common
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 func MustAssertBool(v interface{}) bool {
 	return v.(bool)
@@ -30,6 +33,13 @@ func AssertInt(v interface{}) int {
 	if n, ok := v.(float64); ok {
 		return int(n)
 	}
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := n.Float64()
+		return int(f)
+	}
 	return 0
 }
 
@@ -44,6 +54,10 @@ func AssertFloat64(v interface{}) float64 {
 	if n, ok := v.(float64); ok {
 		return n
 	}
+	if n, ok := v.(json.Number); ok {
+		f, _ := n.Float64()
+		return f
+	}
 	return 0
 }
 
@@ -65,6 +79,10 @@ func AssertInt64(v interface{}) int64 {
 	if n, ok := v.(int64); ok {
 		return n
 	}
+	if n, ok := v.(json.Number); ok {
+		i, _ := n.Int64()
+		return i
+	}
 	return 0
 }
 
@@ -72,6 +90,9 @@ func AssertMap(v interface{}) map[string]interface{} {
 	if m, ok := v.(map[string]interface{}); ok {
 		return m
 	}
+	if d, ok := v.(Data); ok {
+		return d
+	}
 	return nil
 }
 
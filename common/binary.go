@@ -539,18 +539,25 @@ func MustReadVarOctet(r io.Reader) []byte {
 	return octet
 }
 
+// Lengths >= 128 are prefixed with MSB | the number of big-endian length bytes
+
 func ReadVarOctet(r io.Reader) (octet []byte, err error) {
 	b, err := Peek(r)
 	if err != nil {
 		return nil, err
 	}
+	n := int(b)
 	if b > MSB {
-		b, err = Peek(r)
+		p, err := ReadN(r, int(b&^MSB))
 		if err != nil {
 			return nil, err
 		}
+		n = 0
+		for _, b = range p {
+			n = n<<8 | int(b)
+		}
 	}
-	return ReadN(r, int(b))
+	return ReadN(r, n)
 }
 
 func WriteVarOctet(w io.Writer, p []byte) {
@@ -578,11 +585,14 @@ func VarOctetBytes(octet []byte) ([]byte, error) {
 	if i >= len(octet) {
 		return nil, ErrInvalidSize
 	}
-	n := int(octet[i])
-	if i+n > len(octet) {
+	n := 0
+	for _, b := range octet[1 : i+1] {
+		n = n<<8 | int(b)
+	}
+	if i+1+n > len(octet) {
 		return nil, ErrInvalidSize
 	}
-	return octet[i : i+n], nil
+	return octet[i+1 : i+1+n], nil
 }
 
 func VarOctet(p []byte) (octet []byte) {
@@ -592,8 +602,9 @@ func VarOctet(p []byte) (octet []byte) {
 		for i := 1; ; i++ {
 			if n < 1<<uint(i*8) {
 				octet = []byte{uint8(MSB | uint(i))}
-				octet = append(octet, make([]byte, i)...)
-				octet[i] = uint8(n)
+				for j := i - 1; j >= 0; j-- {
+					octet = append(octet, uint8(n>>uint(j*8)))
+				}
 				break
 			}
 		}
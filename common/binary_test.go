@@ -0,0 +1,24 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVarOctet(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 255, 256, 1000, 70000} {
+		p := bytes.Repeat([]byte{0xab}, n)
+		buf := new(bytes.Buffer)
+		WriteVarOctet(buf, p)
+		octet, err := ReadVarOctet(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, octet) {
+			t.Errorf("Expected octet of length %d, got %d", n, len(octet))
+		}
+		if octet, err = VarOctetBytes(VarOctet(p)); err != nil || !bytes.Equal(p, octet) {
+			t.Errorf("Expected octet bytes of length %d", n)
+		}
+	}
+}
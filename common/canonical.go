@@ -0,0 +1,225 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Canonical JSON
+// Matches BigchainDB serialization, i.e. python's
+// json.dumps(v, sort_keys=True, separators=(',', ':'), ensure_ascii=False)
+// - object keys sorted by code point
+// - no insignificant whitespace
+// - only '"', '\\' and control characters escaped, no html escaping
+// - integers as written, numbers with fraction or exponent like python's repr
+
+func MarshalCanonicalJSON(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := writeCanonical(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func MustMarshalCanonicalJSON(v interface{}) []byte {
+	p, err := MarshalCanonicalJSON(v)
+	Check(err)
+	return p
+}
+
+func writeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalStr(buf, v)
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		num, err := canonicalFloat(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(num)
+	case json.Number:
+		num, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(num)
+	case []interface{}:
+		if v == nil {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeCanonicalArray(buf, len(v), func(i int) interface{} { return v[i] })
+	case []Data:
+		if v == nil {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeCanonicalArray(buf, len(v), func(i int) interface{} { return v[i] })
+	case []string:
+		if v == nil {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeCanonicalArray(buf, len(v), func(i int) interface{} { return v[i] })
+	case Data:
+		return writeCanonicalObject(buf, v)
+	case map[string]interface{}:
+		return writeCanonicalObject(buf, v)
+	default:
+		// Marshalers, structs, typed slices..
+		p, err := MarshalJSON(v)
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(p))
+		dec.UseNumber()
+		var x interface{}
+		if err = dec.Decode(&x); err != nil {
+			return err
+		}
+		return writeCanonical(buf, x)
+	}
+	return nil
+}
+
+func writeCanonicalArray(buf *bytes.Buffer, n int, elem func(int) interface{}) error {
+	buf.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeCanonical(buf, elem(i)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func writeCanonicalObject(buf *bytes.Buffer, m map[string]interface{}) error {
+	if m == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	// byte order of utf-8 strings is code point order
+	sort.Strings(keys)
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeCanonicalStr(buf, k)
+		buf.WriteByte(':')
+		if err := writeCanonical(buf, m[k]); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+func writeCanonicalStr(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			buf.WriteRune(r)
+			i += size
+			continue
+		}
+		switch b {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if b < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hexDigits[b>>4])
+				buf.WriteByte(hexDigits[b&0xf])
+			} else {
+				buf.WriteByte(b)
+			}
+		}
+		i++
+	}
+	buf.WriteByte('"')
+}
+
+// Numbers written with a fraction or exponent are python floats,
+// e.g. 1.0 and 1e+16, even when their value is integral
+
+func canonicalNumber(num json.Number) (string, error) {
+	s := num.String()
+	if !strings.ContainsAny(s, ".eE") {
+		return s, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", err
+	}
+	return pythonFloat(f)
+}
+
+// Decoded float64 values have lost their text so integral values
+// below python's exponent threshold are written as integers
+
+func canonicalFloat(f float64) (string, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", Errorf("Unsupported number %v", f)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1e16 {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	return pythonFloat(f)
+}
+
+func pythonFloat(f float64) (string, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", Errorf("Unsupported number %v", f)
+	}
+	// python repr: shortest digits, exponent if exp < -4 or exp >= 16
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	exp, err := strconv.Atoi(e[strings.IndexByte(e, 'e')+1:])
+	if err != nil {
+		return "", err
+	}
+	if exp < -4 || exp >= 16 {
+		return e, nil
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsRune(s, '.') {
+		s += ".0"
+	}
+	return s, nil
+}
@@ -0,0 +1,56 @@
+package common
+
+import "testing"
+
+// Expected output generated with python3:
+// json.dumps(v, sort_keys=True, separators=(',', ':'), ensure_ascii=False)
+// hashlib.sha3_256(...).hexdigest()
+
+var canonicalVectors = []struct {
+	input    string
+	expected string
+	checksum string
+}{
+	{
+		`{"b": 1, "a": [true, false, null], "c": {"z": "x", "y": "é"}}`,
+		"{\"a\":[true,false,null],\"b\":1,\"c\":{\"y\":\"é\",\"z\":\"x\"}}",
+		"e2f63c90b962e94f7b4bdfb5b51bbda1cdffe5dc9d971f79de9525827846af41",
+	},
+	{
+		`{"s": "quote\" back\\ nl\n tab\t ctrl\u0001\u001f html<>& snow☃ sep  del\u007f"}`,
+		"{\"s\":\"quote\\\" back\\\\ nl\\n tab\\t ctrl\\u0001\\u001f html<>& snow☃ sep  del\u007f\"}",
+		"81ffab86627c8b6cea1e76adcbb7d8684701616cfecb5af1487a06b0c166faf7",
+	},
+	{
+		`{"floats": [1.5, 0.1, 1e-07, 1.5e+16, 1234567.5, 0.0001, -2.25]}`,
+		"{\"floats\":[1.5,0.1,1e-07,1.5e+16,1234567.5,0.0001,-2.25]}",
+		"68b0cd22a3aa517006418b26d5b30fa6ec3684ba5498e4c8d72a5d87cd45c993",
+	},
+	{
+		`{"é": 1, "z": 2, "A": 3, "a": {"中": [], "": {}}}`,
+		"{\"A\":3,\"a\":{\"\":{},\"中\":[]},\"z\":2,\"é\":1}",
+		"0c28f0aa60c4a284b5ab197c442202037eaa131b58efa911750cc789effda2d0",
+	},
+	{
+		`{"ints": [1, -3], "floats": [1.0, 1e16, 100.0, -0.0, 2.50, 1E2, 12345678901234567.0]}`,
+		"{\"floats\":[1.0,1e+16,100.0,-0.0,2.5,100.0,1.2345678901234568e+16],\"ints\":[1,-3]}",
+		"1935748a7d1cac42242f6e2f50dbb2bc6b89fe6e0006ef52f86f99f201ec6ed9",
+	},
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	for i, vector := range canonicalVectors {
+		data := make(Data)
+		MustUnmarshalJSON([]byte(vector.input), &data)
+		p, err := MarshalCanonicalJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != vector.expected {
+			t.Errorf("Vector %d: expected %s, got %s", i, vector.expected, p)
+		}
+		if checksum := BytesToHex(Checksum256(p)); checksum != vector.checksum {
+			t.Errorf("Vector %d: expected checksum %s, got %s", i, vector.checksum, checksum)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -79,8 +80,19 @@ func MustMarshalIndentJSON(v interface{}) []byte {
 	return p
 }
 
+// Numbers are decoded as json.Number so they keep their text, e.g. 1.0,
+// and txs serialize canonically as the node wrote them
+
 func UnmarshalJSON(p []byte, v interface{}) error {
-	return json.Unmarshal(p, v)
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return Errorf("Unexpected data after JSON value")
+	}
+	return nil
 }
 
 func MustUnmarshalJSON(p []byte, v interface{}) {
@@ -90,6 +102,7 @@ func MustUnmarshalJSON(p []byte, v interface{}) {
 
 func ReadJSON(r io.Reader, v interface{}) error {
 	dec := json.NewDecoder(r)
+	dec.UseNumber()
 	return dec.Decode(v)
 }
 
@@ -23,6 +23,7 @@ var (
 	ErrInvalidTime        = Error("Invalid time")
 	ErrInvalidType        = Error("Invalid type")
 	ErrInvalidUrl         = Error("Invalid url")
+	ErrTimeout            = Error("Timeout")
 )
 
 func Check(err error) {
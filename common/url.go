@@ -34,6 +34,10 @@ func MustParseQuery(query string) url.Values {
 	return values
 }
 
+func QueryEscape(s string) string {
+	return url.QueryEscape(s)
+}
+
 func UrlValues(req *http.Request) (url.Values, error) {
 	data, err := ReadAll(req.Body)
 	if err != nil {
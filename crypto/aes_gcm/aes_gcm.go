@@ -38,3 +38,37 @@ func Decrypt(key, ciphertext []byte) []byte {
 	Check(err)
 	return plaintext
 }
+
+// Like Encrypt and Decrypt, but authenticating data that isn't encrypted
+// and returning an error if the ciphertext or data was changed
+
+func Seal(key, plaintext, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, NONCE_SIZE)
+	if err = ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, data), nil
+}
+
+func Open(key, ciphertext, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < NONCE_SIZE {
+		return nil, ErrInvalidSize
+	}
+	return gcm.Open(nil, ciphertext[:NONCE_SIZE], ciphertext[NONCE_SIZE:], data)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
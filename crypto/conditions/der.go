@@ -0,0 +1,705 @@
+package conditions
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/crypto/crypto"
+	"github.com/zbo14/envoke/crypto/ed25519"
+)
+
+// Crypto-conditions v2
+// DER encoded conditions and fulfillments with ni:/// uris
+// tools.ietf.org/html/draft-thomas-crypto-conditions-03
+// Supports preimage, ed25519 and threshold types
+
+const (
+	// Type names
+	PREIMAGE_SHA_256  = "preimage-sha-256"
+	PREFIX_SHA_256    = "prefix-sha-256"
+	THRESHOLD_SHA_256 = "threshold-sha-256"
+	RSA_SHA_256       = "rsa-sha-256"
+	ED25519_SHA_256   = "ed25519-sha-256"
+
+	// Costs
+	ED25519_COST       = 131072
+	THRESHOLD_SUB_COST = 1024
+
+	// URI
+	NI_PREFIX      = "ni:///sha-256;"
+	NI_REGEX       = `^ni:///sha-256;[a-zA-Z0-9_-]{43}\?fpt=[a-z0-9-]+&cost=[0-9]+(&subtypes=[a-z0-9,-]+)?$`
+	TYPE_PARAM     = "fpt"
+	COST_PARAM     = "cost"
+	SUBTYPES_PARAM = "subtypes"
+
+	DER_SEQUENCE = 0x30
+)
+
+var typeNames = []string{
+	PREIMAGE_SHA_256,
+	PREFIX_SHA_256,
+	THRESHOLD_SHA_256,
+	RSA_SHA_256,
+	ED25519_SHA_256,
+}
+
+func TypeName(id int) string {
+	if id < 0 || id >= len(typeNames) {
+		return ""
+	}
+	return typeNames[id]
+}
+
+func TypeId(name string) int {
+	for id, typeName := range typeNames {
+		if name == typeName {
+			return id
+		}
+	}
+	return -1
+}
+
+// Condition
+
+type ConditionV2 struct {
+	Cost        int
+	Fingerprint []byte
+	Subtypes    int // bitmask of type ids
+	TypeId      int
+}
+
+func NewConditionV2(typeId int, fingerprint []byte, cost, subtypes int) *ConditionV2 {
+	return &ConditionV2{
+		Cost:        cost,
+		Fingerprint: fingerprint,
+		Subtypes:    subtypes,
+		TypeId:      typeId,
+	}
+}
+
+func (c *ConditionV2) compound() bool {
+	return c.TypeId == PREFIX_ID || c.TypeId == THRESHOLD_ID
+}
+
+func (c *ConditionV2) Equals(other *ConditionV2) bool {
+	return c.TypeId == other.TypeId &&
+		c.Cost == other.Cost &&
+		c.Subtypes == other.Subtypes &&
+		bytes.Equal(c.Fingerprint, other.Fingerprint)
+}
+
+func (c *ConditionV2) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.Write(derEncode(0x80, c.Fingerprint))
+	buf.Write(derEncode(0x81, derUint(c.Cost)))
+	if c.compound() {
+		buf.Write(derEncode(0x82, derBitString(c.Subtypes)))
+	}
+	return derEncode(0xa0|byte(c.TypeId), buf.Bytes()), nil
+}
+
+func (c *ConditionV2) UnmarshalBinary(p []byte) error {
+	tag, content, rest, err := derDecode(p)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 || tag&0xe0 != 0xa0 {
+		return ErrInvalidCondition
+	}
+	c.TypeId = int(tag & 0x1f)
+	if TypeName(c.TypeId) == "" {
+		return ErrorAppend(ErrInvalidType, Itoa(c.TypeId))
+	}
+	fields, err := derFields(content)
+	if err != nil {
+		return err
+	}
+	if len(fields[0x80]) != HASH_SIZE {
+		return ErrorAppend(ErrInvalidCondition, "fingerprint must be 32 bytes")
+	}
+	c.Fingerprint = fields[0x80]
+	if c.Cost, err = derReadUint(fields[0x81]); err != nil {
+		return err
+	}
+	c.Subtypes = 0
+	if c.compound() {
+		if c.Subtypes, err = derReadBitString(fields[0x82]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ConditionV2) MarshalJSON() ([]byte, error) {
+	return MustMarshalJSON(c.String()), nil
+}
+
+func (c *ConditionV2) UnmarshalJSON(p []byte) error {
+	var uri string
+	if err := UnmarshalJSON(p, &uri); err != nil {
+		return err
+	}
+	return c.FromString(uri)
+}
+
+// e.g. ni:///sha-256;<fingerprint>?fpt=ed25519-sha-256&cost=131072
+
+func (c *ConditionV2) String() string {
+	uri := Sprintf("%s%s?%s=%s&%s=%d", NI_PREFIX, Base64UrlEncode(c.Fingerprint), TYPE_PARAM, TypeName(c.TypeId), COST_PARAM, c.Cost)
+	if c.compound() {
+		var names []string
+		for id, name := range typeNames {
+			if c.Subtypes&(1<<uint(id)) != 0 {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			uri += Sprintf("&%s=%s", SUBTYPES_PARAM, strings.Join(names, ","))
+		}
+	}
+	return uri
+}
+
+func (c *ConditionV2) FromString(uri string) (err error) {
+	if !MatchStr(NI_REGEX, uri) {
+		return ErrInvalidCondition
+	}
+	uri = strings.TrimPrefix(uri, NI_PREFIX)
+	parts := SplitStr(uri, "?")
+	c.Fingerprint, err = Base64UrlDecode(parts[0])
+	if err != nil {
+		return err
+	}
+	c.TypeId, c.Subtypes = -1, 0
+	for _, param := range SplitStr(parts[1], "&") {
+		kv := SplitStr(param, "=")
+		switch kv[0] {
+		case TYPE_PARAM:
+			c.TypeId = TypeId(kv[1])
+		case COST_PARAM:
+			if c.Cost, err = Atoi(kv[1]); err != nil {
+				return err
+			}
+		case SUBTYPES_PARAM:
+			for _, name := range SplitStr(kv[1], ",") {
+				id := TypeId(name)
+				if id < 0 {
+					return ErrorAppend(ErrInvalidType, name)
+				}
+				c.Subtypes |= 1 << uint(id)
+			}
+		}
+	}
+	if c.TypeId < 0 {
+		return ErrorAppend(ErrInvalidType, "unknown fingerprint type")
+	}
+	return nil
+}
+
+func ConditionV2FromString(uri string) (*ConditionV2, error) {
+	c := new(ConditionV2)
+	if err := c.FromString(uri); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Fulfillment
+
+type FulfillmentV2 interface {
+	Condition() *ConditionV2
+	Details() Data
+	IsFulfilled() bool
+	MarshalBinary() ([]byte, error)
+	String() string
+	TypeId() int
+	Validate([]byte) bool
+}
+
+// Base64url encoding of the DER fulfillment, the format bigchaindb 2.0 uses in tx inputs
+
+func fulfillmentV2String(f FulfillmentV2) string {
+	p, err := f.MarshalBinary()
+	Check(err)
+	return Base64UrlEncode(p)
+}
+
+func FulfillmentV2FromString(str string) (FulfillmentV2, error) {
+	p, err := Base64UrlDecode(str)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalFulfillmentV2(p)
+}
+
+func UnmarshalFulfillmentV2(p []byte) (FulfillmentV2, error) {
+	tag, content, rest, err := derDecode(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 || tag&0xe0 != 0xa0 {
+		return nil, ErrInvalidFulfillment
+	}
+	switch typeId := int(tag & 0x1f); typeId {
+	case PREIMAGE_ID:
+		fields, err := derFields(content)
+		if err != nil {
+			return nil, err
+		}
+		return NewPreImageV2(fields[0x80]), nil
+	case ED25519_ID:
+		fields, err := derFields(content)
+		if err != nil {
+			return nil, err
+		}
+		pub := new(ed25519.PublicKey)
+		if err = pub.FromBytes(fields[0x80]); err != nil {
+			return nil, err
+		}
+		sig := new(ed25519.Signature)
+		if err = sig.FromBytes(fields[0x81]); err != nil {
+			return nil, err
+		}
+		return NewEd25519V2(pub, sig), nil
+	case THRESHOLD_ID:
+		return unmarshalThresholdV2(content)
+	default:
+		return nil, ErrorAppend(ErrInvalidType, TypeName(typeId))
+	}
+}
+
+// SHA256 Pre-Image
+
+type preImageV2 struct {
+	preimage []byte
+}
+
+func NewPreImageV2(preimage []byte) *preImageV2 {
+	return &preImageV2{preimage}
+}
+
+func (f *preImageV2) Condition() *ConditionV2 {
+	return NewConditionV2(PREIMAGE_ID, Sum256(f.preimage), len(f.preimage), 0)
+}
+
+func (f *preImageV2) Details() Data {
+	return Data{"type": PREIMAGE_SHA_256}
+}
+
+func (f *preImageV2) IsFulfilled() bool { return f.preimage != nil }
+
+func (f *preImageV2) MarshalBinary() ([]byte, error) {
+	return derEncode(0xa0|PREIMAGE_ID, derEncode(0x80, f.preimage)), nil
+}
+
+func (f *preImageV2) String() string { return fulfillmentV2String(f) }
+
+func (f *preImageV2) TypeId() int { return PREIMAGE_ID }
+
+func (f *preImageV2) Validate(_ []byte) bool { return f.IsFulfilled() }
+
+// ED25519
+
+type ed25519V2 struct {
+	pub *ed25519.PublicKey
+	sig *ed25519.Signature
+}
+
+// Signature can be nil for an unfulfilled condition
+
+func NewEd25519V2(pub *ed25519.PublicKey, sig *ed25519.Signature) *ed25519V2 {
+	return &ed25519V2{pub, sig}
+}
+
+func (f *ed25519V2) Condition() *ConditionV2 {
+	fingerprint := Sum256(derEncode(DER_SEQUENCE, derEncode(0x80, f.pub.Bytes())))
+	return NewConditionV2(ED25519_ID, fingerprint, ED25519_COST, 0)
+}
+
+// Condition details in bigchaindb 2.0 outputs
+
+func (f *ed25519V2) Details() Data {
+	return Data{
+		"public_key": f.pub,
+		"type":       ED25519_SHA_256,
+	}
+}
+
+func (f *ed25519V2) IsFulfilled() bool { return f.sig.Bytes() != nil }
+
+func (f *ed25519V2) MarshalBinary() ([]byte, error) {
+	if !f.IsFulfilled() {
+		return nil, ErrorAppend(ErrInvalidFulfillment, "missing signature")
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(derEncode(0x80, f.pub.Bytes()))
+	buf.Write(derEncode(0x81, f.sig.Bytes()))
+	return derEncode(0xa0|ED25519_ID, buf.Bytes()), nil
+}
+
+func (f *ed25519V2) PublicKey() *ed25519.PublicKey { return f.pub }
+
+func (f *ed25519V2) Sign(msg []byte, priv *ed25519.PrivateKey) {
+	f.sig = priv.Sign(msg).(*ed25519.Signature)
+}
+
+func (f *ed25519V2) String() string { return fulfillmentV2String(f) }
+
+func (f *ed25519V2) TypeId() int { return ED25519_ID }
+
+func (f *ed25519V2) Validate(msg []byte) bool {
+	if !f.IsFulfilled() {
+		return false
+	}
+	return f.pub.Verify(msg, f.sig)
+}
+
+// SHA256 Threshold
+
+type thresholdV2 struct {
+	conds     []*ConditionV2
+	subs      []FulfillmentV2
+	threshold int
+}
+
+// Unfulfilled subs are serialized as conditions
+
+func NewThresholdV2(subs []FulfillmentV2, threshold int) *thresholdV2 {
+	if len(subs) == 0 {
+		panic("Must have more than 0 subs")
+	}
+	if threshold <= 0 || threshold > len(subs) {
+		panic("Threshold must be between 1 and number of subs")
+	}
+	return &thresholdV2{
+		subs:      subs,
+		threshold: threshold,
+	}
+}
+
+// Ed25519 for one key, n-of-n threshold for multiple keys
+
+func FulfillmentV2FromPubKeys(pubs []crypto.PublicKey) FulfillmentV2 {
+	if len(pubs) == 1 {
+		return Ed25519V2FromPubKey(pubs[0])
+	}
+	return ThresholdV2FromPubKeys(pubs, len(pubs))
+}
+
+func Ed25519V2FromPubKey(pub crypto.PublicKey) *ed25519V2 {
+	pubEd25519, ok := pub.(*ed25519.PublicKey)
+	if !ok {
+		panic(ErrInvalidKey)
+	}
+	return NewEd25519V2(pubEd25519, nil)
+}
+
+func ThresholdV2FromPubKeys(pubs []crypto.PublicKey, threshold int) *thresholdV2 {
+	subs := make([]FulfillmentV2, len(pubs))
+	for i, pub := range pubs {
+		subs[i] = Ed25519V2FromPubKey(pub)
+	}
+	return NewThresholdV2(subs, threshold)
+}
+
+func (f *thresholdV2) Conditions() []*ConditionV2 {
+	conds := make([]*ConditionV2, 0, len(f.subs)+len(f.conds))
+	for _, sub := range f.subs {
+		conds = append(conds, sub.Condition())
+	}
+	return append(conds, f.conds...)
+}
+
+func (f *thresholdV2) Condition() *ConditionV2 {
+	conds := f.Conditions()
+	encoded := make([][]byte, len(conds))
+	costs := make([]int, len(conds))
+	subtypes := 0
+	for i, c := range conds {
+		encoded[i], _ = c.MarshalBinary()
+		costs[i] = c.Cost
+		subtypes |= c.Subtypes | (1 << uint(c.TypeId))
+	}
+	subtypes &^= 1 << THRESHOLD_ID
+	// Cost is the sum of the largest threshold costs plus 1024 per sub
+	sort.Sort(sort.Reverse(sort.IntSlice(costs)))
+	cost := THRESHOLD_SUB_COST * len(conds)
+	for i := 0; i < f.threshold && i < len(costs); i++ {
+		cost += costs[i]
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(derEncode(0x80, derUint(f.threshold)))
+	buf.Write(derEncode(0xa1, derSetOf(encoded)))
+	fingerprint := Sum256(derEncode(DER_SEQUENCE, buf.Bytes()))
+	return NewConditionV2(THRESHOLD_ID, fingerprint, cost, subtypes)
+}
+
+func (f *thresholdV2) Details() Data {
+	subconditions := make([]Data, len(f.subs))
+	for i, sub := range f.subs {
+		subconditions[i] = sub.Details()
+	}
+	return Data{
+		"subconditions": subconditions,
+		"threshold":     f.threshold,
+		"type":          THRESHOLD_SHA_256,
+	}
+}
+
+func (f *thresholdV2) IsFulfilled() bool {
+	n := 0
+	for _, sub := range f.subs {
+		if sub.IsFulfilled() {
+			n++
+		}
+	}
+	return n >= f.threshold
+}
+
+func (f *thresholdV2) MarshalBinary() ([]byte, error) {
+	if !f.IsFulfilled() {
+		return nil, ErrorAppend(ErrInvalidFulfillment, "threshold not met")
+	}
+	var fulfillments, conds [][]byte
+	for _, sub := range f.subs {
+		if sub.IsFulfilled() && len(fulfillments) < f.threshold {
+			p, err := sub.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			fulfillments = append(fulfillments, p)
+		} else {
+			p, _ := sub.Condition().MarshalBinary()
+			conds = append(conds, p)
+		}
+	}
+	for _, c := range f.conds {
+		p, _ := c.MarshalBinary()
+		conds = append(conds, p)
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(derEncode(0xa0, derSetOf(fulfillments)))
+	buf.Write(derEncode(0xa1, derSetOf(conds)))
+	return derEncode(0xa0|THRESHOLD_ID, buf.Bytes()), nil
+}
+
+func (f *thresholdV2) String() string { return fulfillmentV2String(f) }
+
+func (f *thresholdV2) Subfulfillments() []FulfillmentV2 { return f.subs }
+
+// Subfulfillments of a threshold, nil for other types
+
+func GetSubfulfillmentsV2(f FulfillmentV2) []FulfillmentV2 {
+	if f, ok := f.(*thresholdV2); ok {
+		return f.subs
+	}
+	return nil
+}
+
+func (f *thresholdV2) Threshold() int { return f.threshold }
+
+func (f *thresholdV2) TypeId() int { return THRESHOLD_ID }
+
+func (f *thresholdV2) Validate(msg []byte) bool {
+	valid := 0
+	for _, sub := range f.subs {
+		if sub.IsFulfilled() {
+			if !sub.Validate(msg) {
+				return false
+			}
+			valid++
+		}
+	}
+	return valid >= f.threshold
+}
+
+// The threshold itself isn't serialized in the fulfillment,
+// it's the number of subfulfillments
+
+func unmarshalThresholdV2(content []byte) (*thresholdV2, error) {
+	f := new(thresholdV2)
+	for len(content) > 0 {
+		tag, inner, rest, err := derDecode(content)
+		if err != nil {
+			return nil, err
+		}
+		content = rest
+		for len(inner) > 0 {
+			_, _, next, err := derDecode(inner)
+			if err != nil {
+				return nil, err
+			}
+			elem := inner[:len(inner)-len(next)]
+			inner = next
+			switch tag {
+			case 0xa0:
+				sub, err := UnmarshalFulfillmentV2(elem)
+				if err != nil {
+					return nil, err
+				}
+				f.subs = append(f.subs, sub)
+			case 0xa1:
+				c := new(ConditionV2)
+				if err = c.UnmarshalBinary(elem); err != nil {
+					return nil, err
+				}
+				f.conds = append(f.conds, c)
+			default:
+				return nil, ErrInvalidFulfillment
+			}
+		}
+	}
+	f.threshold = len(f.subs)
+	if f.threshold == 0 {
+		return nil, ErrorAppend(ErrInvalidFulfillment, "threshold must have subfulfillments")
+	}
+	return f, nil
+}
+
+// DER
+
+func derEncode(tag byte, content []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(tag)
+	n := len(content)
+	if n < 0x80 {
+		buf.WriteByte(byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		buf.WriteByte(0x80 | byte(len(length)))
+		buf.Write(length)
+	}
+	buf.Write(content)
+	return buf.Bytes()
+}
+
+func derDecode(p []byte) (tag byte, content, rest []byte, err error) {
+	if len(p) < 2 {
+		return 0, nil, nil, ErrorAppend(ErrInvalidSize, "DER element too short")
+	}
+	tag = p[0]
+	n := int(p[1])
+	p = p[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || size > len(p) {
+			return 0, nil, nil, ErrorAppend(ErrInvalidSize, "invalid DER length")
+		}
+		n = 0
+		for _, b := range p[:size] {
+			n = n<<8 | int(b)
+		}
+		p = p[size:]
+	}
+	if n > len(p) {
+		return 0, nil, nil, ErrorAppend(ErrInvalidSize, "DER length exceeds input")
+	}
+	return tag, p[:n], p[n:], nil
+}
+
+// Context-specific fields of a sequence, keyed by tag
+
+func derFields(p []byte) (map[byte][]byte, error) {
+	fields := make(map[byte][]byte)
+	for len(p) > 0 {
+		tag, content, rest, err := derDecode(p)
+		if err != nil {
+			return nil, err
+		}
+		fields[tag] = content
+		p = rest
+	}
+	return fields, nil
+}
+
+func derUint(x int) []byte {
+	if x < 0 {
+		panic("Expected non-negative integer")
+	}
+	p := []byte{byte(x)}
+	for x >>= 8; x > 0; x >>= 8 {
+		p = append([]byte{byte(x)}, p...)
+	}
+	if p[0]&0x80 != 0 {
+		p = append([]byte{0}, p...)
+	}
+	return p
+}
+
+func derReadUint(p []byte) (int, error) {
+	if len(p) == 0 || len(p) > 8 || p[0]&0x80 != 0 {
+		return 0, ErrorAppend(ErrInvalidSize, "invalid DER integer")
+	}
+	x := 0
+	for _, b := range p {
+		x = x<<8 | int(b)
+	}
+	return x, nil
+}
+
+// Type id n is bit n, counting from the most significant bit
+
+func derBitString(bitmask int) []byte {
+	last := -1
+	for id := range typeNames {
+		if bitmask&(1<<uint(id)) != 0 {
+			last = id
+		}
+	}
+	if last < 0 {
+		return []byte{0}
+	}
+	p := make([]byte, last/8+2)
+	p[0] = byte(7 - last%8)
+	for id := 0; id <= last; id++ {
+		if bitmask&(1<<uint(id)) != 0 {
+			p[1+id/8] |= 0x80 >> uint(id%8)
+		}
+	}
+	return p
+}
+
+func derReadBitString(p []byte) (int, error) {
+	if len(p) == 0 || p[0] > 7 {
+		return 0, ErrorAppend(ErrInvalidSize, "invalid DER bit string")
+	}
+	bitmask := 0
+	for i, b := range p[1:] {
+		for j := 0; j < 8; j++ {
+			if b&(0x80>>uint(j)) != 0 {
+				bitmask |= 1 << uint(i*8+j)
+			}
+		}
+	}
+	return bitmask, nil
+}
+
+// DER sorts SET OF elements by their encodings,
+// shorter ones padded with trailing zeros
+
+func derSetOf(elems [][]byte) []byte {
+	sorted := make([][]byte, len(elems))
+	copy(sorted, elems)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		for k := 0; k < len(a) || k < len(b); k++ {
+			var x, y byte
+			if k < len(a) {
+				x = a[k]
+			}
+			if k < len(b) {
+				y = b[k]
+			}
+			if x != y {
+				return x < y
+			}
+		}
+		return len(a) < len(b)
+	})
+	return bytes.Join(sorted, nil)
+}
@@ -410,6 +410,16 @@ func (f *fulfillmentThreshold) ThresholdSubs() {
 	Panicf("Cannot have %d subs, threshold=%d\n", len(f.subs), f.threshold)
 }
 
+// Subfulfillments and subconditions of a threshold, nil for other types
+
+func GetSubfulfillments(f Fulfillment) Fulfillments {
+	if f, ok := f.(*fulfillmentThreshold); ok {
+		f.ThresholdSubs()
+		return f.subs
+	}
+	return nil
+}
+
 func ThresholdSubs(p []byte) (Fulfillments, int, error) {
 	buf := bytes.NewBuffer(p)
 	threshold, err := ReadVarUint(buf)
@@ -5,8 +5,12 @@ import (
 	. "github.com/zbo14/envoke/common"
 	conds "github.com/zbo14/envoke/crypto/conditions"
 	"github.com/zbo14/envoke/crypto/ed25519"
+	ks "github.com/zbo14/envoke/crypto/keystore"
 	"github.com/zbo14/envoke/crypto/rsa"
+	"io/ioutil"
+	"os"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -38,7 +42,7 @@ func TestCrypto(t *testing.T) {
 	}
 	// Ed25519
 	msg := []byte("deadbeef")
-	privEd25519, _ := ed25519.GenerateKeypairFromPassword("password")
+	privEd25519, _ := ed25519.GenerateKeypair()
 	f3 := conds.FulfillmentFromPrivKey(msg, privEd25519, 2)
 	if !f3.Validate(msg) {
 		t.Error("Failed to validate ed25519 fulfillment")
@@ -88,3 +92,162 @@ func TestCrypto(t *testing.T) {
 		t.Error("Failed to validate nested thresholds")
 	}
 }
+
+// Expected uris computed independently in python3
+
+func TestConditionsV2(t *testing.T) {
+	_, pubAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58("4phdqYUjr2BMZfTn7Sbadhj2YMaSZmGW4ZouMuCzMHeQ"))
+	privBob, pubBob := ed25519.GenerateKeypairFromSeed(BytesFromB58("3K69pciBXYK9jyr9pSVoeb4TdQe5rT63fjxbsMUGpgBw"))
+	f1 := conds.Ed25519V2FromPubKey(pubAlice)
+	uri := f1.Condition().String()
+	if uri != "ni:///sha-256;J2UGOEzny6s50YCU0hQGI0JTBRS-0fyWzN_Ou6LCAMU?fpt=ed25519-sha-256&cost=131072" {
+		t.Errorf("Unexpected ed25519 condition %s", uri)
+	}
+	c, err := conds.ConditionV2FromString(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equals(f1.Condition()) {
+		t.Error("Expected condition from uri to equal ed25519 condition")
+	}
+	f2 := conds.Ed25519V2FromPubKey(pubBob)
+	f3 := conds.NewThresholdV2([]conds.FulfillmentV2{f1, f2}, 1)
+	f4 := conds.NewThresholdV2([]conds.FulfillmentV2{f2, f1}, 2)
+	uri = f4.Condition().String()
+	if uri != "ni:///sha-256;zImdLa9oVNCSg77bHVIAP9u3n7uHEH80M_O2WGle1gk?fpt=threshold-sha-256&cost=264192&subtypes=ed25519-sha-256" {
+		t.Errorf("Unexpected threshold condition %s", uri)
+	}
+	// Condition DER round trip
+	p, _ := f4.Condition().MarshalBinary()
+	c = new(conds.ConditionV2)
+	if err = c.UnmarshalBinary(p); err != nil {
+		t.Fatal(err)
+	}
+	if c.String() != uri {
+		t.Error("Expected threshold condition to survive DER round trip")
+	}
+	// 1-of-2 threshold signed by Bob
+	msg := []byte("deadbeef")
+	f2.Sign(msg, privBob)
+	if !f3.IsFulfilled() || f4.IsFulfilled() {
+		t.Error("Expected 1-of-2 threshold to be fulfilled and 2-of-2 not")
+	}
+	f, err := conds.FulfillmentV2FromString(f3.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Validate(msg) {
+		t.Error("Failed to validate threshold fulfillment")
+	}
+	if f.Validate([]byte("foobar")) {
+		t.Error("Expected threshold fulfillment to be invalid for other message")
+	}
+	if !f.Condition().Equals(f3.Condition()) {
+		t.Error("Expected decoded threshold fulfillment to have same condition")
+	}
+	// Pre-image
+	f5 := conds.NewPreImageV2([]byte("helloworld"))
+	if f, err = conds.FulfillmentV2FromString(f5.String()); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Condition().Equals(f5.Condition()) {
+		t.Error("Expected decoded pre-image fulfillment to have same condition")
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	priv, pub := ed25519.GenerateKeypairFromSeed(make([]byte, 32))
+	keystore, err := ks.Encrypt("party", priv, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(Data)
+	if err = UnmarshalJSON(MustMarshalJSON(keystore), &received); err != nil {
+		t.Fatal(err)
+	}
+	id, decrypted, err := ks.Decrypt(received, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "party" || !decrypted.Public().Equals(pub) {
+		t.Error("Expected party id and private key from keystore")
+	}
+	if _, _, err = ks.Decrypt(received, "wrong"); err == nil {
+		t.Error("Expected wrong password to fail")
+	}
+	received.Set("id", "other")
+	if _, _, err = ks.Decrypt(received, "password"); err == nil {
+		t.Error("Expected changed party id to fail")
+	}
+	// Plaintext credentials are encrypted when loaded
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/credentials.json"
+	if err = ioutil.WriteFile(path, MustMarshalJSON(Data{"id": "party", "privateKey": priv.String()}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if id, decrypted, err = ks.Load(path, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if id != "party" || !decrypted.Public().Equals(pub) {
+		t.Error("Expected party id and private key from plaintext credentials")
+	}
+	if p := MustReadFile(path); bytes.Contains(p, []byte(priv.String())) {
+		t.Error("Expected credentials to be encrypted")
+	}
+	if _, _, err = ks.Load(path, "wrong"); err == nil {
+		t.Error("Expected wrong password to fail")
+	}
+	if _, decrypted, err = ks.Load(path, "password"); err != nil || !decrypted.Public().Equals(pub) {
+		t.Error("Expected private key from migrated keystore")
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	priv, pub := ed25519.GenerateKeypair()
+	mnemonic := priv.ToMnemonic()
+	if words := SplitStr(mnemonic, " "); len(words) != 24 {
+		t.Fatalf("Expected 24 words, got %d", len(words))
+	}
+	recovered := new(ed25519.PrivateKey)
+	if err := recovered.FromMnemonic(mnemonic); err != nil {
+		t.Fatal(err)
+	}
+	if !recovered.Public().Equals(pub) {
+		t.Error("Expected private key from mnemonic")
+	}
+	// The zero seed's checksum word is "art"
+	zero, _ := ed25519.GenerateKeypairFromSeed(make([]byte, 32))
+	mnemonic = zero.ToMnemonic()
+	if !strings.HasSuffix(mnemonic, " art") {
+		t.Fatalf("Unexpected mnemonic %q", mnemonic)
+	}
+	if err := recovered.FromMnemonic(strings.TrimSuffix(mnemonic, "art") + "abandon"); err == nil {
+		t.Error("Expected bad checksum to fail")
+	}
+	if _, other := ed25519.GenerateKeypair(); other.Equals(pub) {
+		t.Error("Expected random keypairs to differ")
+	}
+	// Password-derived keys need the same salt
+	_, pub1, err := ed25519.GenerateKeypairFromPassword("password", []byte("salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pub2, err := ed25519.GenerateKeypairFromPassword("password", []byte("salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pub3, err := ed25519.GenerateKeypairFromPassword("password", []byte("pepper"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub1.Equals(pub2) || pub1.Equals(pub3) {
+		t.Error("Expected password-derived keys to depend on password and salt")
+	}
+	if _, _, err = ed25519.GenerateKeypairFromPassword("password", nil); err == nil {
+		t.Error("Expected empty salt to fail")
+	}
+}
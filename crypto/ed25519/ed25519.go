@@ -2,9 +2,13 @@ package ed25519
 
 import (
 	"bytes"
+	"crypto/rand"
+
+	"github.com/tyler-smith/go-bip39"
 	. "github.com/zbo14/envoke/common"
 	"github.com/zbo14/envoke/crypto/crypto"
 	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/scrypt"
 )
 
 const (
@@ -12,6 +16,11 @@ const (
 	PUBKEY_SIZE    = ed25519.PublicKeySize
 	SEED_SIZE      = 32
 	SIGNATURE_SIZE = ed25519.SignatureSize
+
+	// Parameters for deriving a seed from a password
+	SCRYPT_N = 1 << 15
+	SCRYPT_P = 1
+	SCRYPT_R = 8
 )
 
 type PublicKey struct {
@@ -47,15 +56,30 @@ func NewSignature(inner []byte) *Signature {
 	return &Signature{inner}
 }
 
-func GenerateKeypairFromPassword(password string) (*PrivateKey, *PublicKey) {
-	secret := crypto.GenerateSecret(password)
-	buf := new(bytes.Buffer)
-	buf.Write(secret)
-	pubInner, privInner, err := ed25519.GenerateKey(buf)
-	Check(err)
-	priv := NewPrivateKey(privInner)
-	pub := NewPublicKey(pubInner)
-	return priv, pub
+// Keypair from a random seed, backed up with ToMnemonic
+
+func GenerateKeypair() (*PrivateKey, *PublicKey) {
+	seed := make([]byte, SEED_SIZE)
+	Check(ReadFull(rand.Reader, seed))
+	return GenerateKeypairFromSeed(seed)
+}
+
+// Keypair derived from a password with scrypt, for keys that were
+// generated from passwords. The salt must be kept to derive it again
+
+func GenerateKeypairFromPassword(password string, salt []byte) (*PrivateKey, *PublicKey, error) {
+	if EmptyStr(password) {
+		return nil, nil, ErrorAppend(ErrEmptyStr, "password")
+	}
+	if len(salt) == 0 {
+		return nil, nil, ErrorAppend(ErrInvalidSize, "empty salt")
+	}
+	seed, err := scrypt.Key([]byte(password), salt, SCRYPT_N, SCRYPT_R, SCRYPT_P, SEED_SIZE)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, pub := GenerateKeypairFromSeed(seed)
+	return priv, pub, nil
 }
 
 func GenerateKeypairFromSeed(seed []byte) (*PrivateKey, *PublicKey) {
@@ -95,6 +119,20 @@ func (priv *PrivateKey) FromString(str string) error {
 	return priv.FromBytes(p)
 }
 
+// Recovers the private key from the BIP39 mnemonic of its seed
+
+func (priv *PrivateKey) FromMnemonic(mnemonic string) error {
+	seed, err := bip39.EntropyFromMnemonic(mnemonic)
+	if err != nil {
+		return ErrorAppend(ErrInvalidKey, err.Error())
+	}
+	if len(seed) != SEED_SIZE {
+		return ErrInvalidSize
+	}
+	priv.inner = ed25519.NewKeyFromSeed(seed)
+	return nil
+}
+
 func (priv *PrivateKey) MarshalJSON() ([]byte, error) {
 	if priv == nil {
 		return nil, nil
@@ -117,6 +155,14 @@ func (priv *PrivateKey) String() string {
 	return BytesToB58(priv.Bytes())
 }
 
+// BIP39 mnemonic of the seed, 24 words
+
+func (priv *PrivateKey) ToMnemonic() string {
+	mnemonic, err := bip39.NewMnemonic(priv.inner.Seed())
+	Check(err)
+	return mnemonic
+}
+
 // Public Key
 func (_ *PublicKey) IsPublicKey() {}
 
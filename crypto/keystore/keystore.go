@@ -0,0 +1,240 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/crypto/aes_gcm"
+	"github.com/zbo14/envoke/crypto/crypto"
+	"github.com/zbo14/envoke/crypto/ed25519"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Party credentials with the private key encrypted under a password, as
+//
+//	Data{
+//		"cipher":     "aes-256-gcm",
+//		"ciphertext": base64url,
+//		"id":         partyId,
+//		"kdf":        "scrypt",
+//		"kdfParams":  Data{"n", "p", "r", "salt"},
+//		"keyType":    "ed25519",
+//		"version":    1,
+//	}
+//
+// The key is derived from the password with scrypt and the ciphertext is
+// the nonce and AES-GCM sealed private key, authenticated with the other
+// fields so none can be changed. Plaintext credentials, Data{"id",
+// "privateKey"}, are still read, and rewritten encrypted by Load
+
+const (
+	CIPHER   = "aes-256-gcm"
+	KDF      = "scrypt"
+	KEY_TYPE = "ed25519"
+	VERSION  = 1
+
+	KEY_SIZE  = 32
+	SALT_SIZE = 32
+
+	SCRYPT_N = 1 << 15
+	SCRYPT_P = 1
+	SCRYPT_R = 8
+
+	// Bounds on the parameters a keystore is decrypted with
+	MAX_SCRYPT_N  = 1 << 20
+	MAX_SCRYPT_RP = 1 << 6
+)
+
+func deriveKey(password string, params Data) ([]byte, error) {
+	salt, err := Base64UrlDecode(params.GetStr("salt"))
+	if err != nil || len(salt) == 0 {
+		return nil, ErrorAppend(ErrInvalidField, "salt")
+	}
+	n, p, r := params.GetInt("n"), params.GetInt("p"), params.GetInt("r")
+	if n < 2 || n > MAX_SCRYPT_N || n&(n-1) != 0 || p < 1 || r < 1 || p*r > MAX_SCRYPT_RP {
+		return nil, ErrorAppend(ErrInvalidField, "scrypt parameters out of range")
+	}
+	return scrypt.Key([]byte(password), salt, n, r, p, KEY_SIZE)
+}
+
+// Fields authenticated with the ciphertext
+
+func header(keystore Data) ([]byte, error) {
+	fields := make(Data, len(keystore))
+	for k, v := range keystore {
+		if k != "ciphertext" {
+			fields[k] = v
+		}
+	}
+	return MarshalCanonicalJSON(fields)
+}
+
+func Encrypt(id string, priv crypto.PrivateKey, password string) (Data, error) {
+	if EmptyStr(password) {
+		return nil, ErrorAppend(ErrEmptyStr, "password")
+	}
+	privEd25519, ok := priv.(*ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrorAppend(ErrInvalidKey, "expected "+KEY_TYPE+" private key")
+	}
+	salt := make([]byte, SALT_SIZE)
+	if err := ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	keystore := Data{
+		"cipher": CIPHER,
+		"id":     id,
+		"kdf":    KDF,
+		"kdfParams": Data{
+			"n":    SCRYPT_N,
+			"p":    SCRYPT_P,
+			"r":    SCRYPT_R,
+			"salt": Base64UrlEncode(salt),
+		},
+		"keyType": KEY_TYPE,
+		"version": VERSION,
+	}
+	key, err := deriveKey(password, keystore.GetData("kdfParams"))
+	if err != nil {
+		return nil, err
+	}
+	data, err := header(keystore)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := aes_gcm.Seal(key, privEd25519.Bytes(), data)
+	if err != nil {
+		return nil, err
+	}
+	keystore.Set("ciphertext", Base64UrlEncode(ciphertext))
+	return keystore, nil
+}
+
+// Returns the party id and private key of an encrypted keystore or of
+// plaintext credentials, which need no password
+
+func Decrypt(keystore Data, password string) (string, crypto.PrivateKey, error) {
+	id := keystore.GetStr("id")
+	if EmptyStr(id) {
+		return "", nil, ErrorAppend(ErrInvalidField, "id")
+	}
+	if Plaintext(keystore) {
+		priv := new(ed25519.PrivateKey)
+		if err := priv.FromString(keystore.GetStr("privateKey")); err != nil {
+			return "", nil, err
+		}
+		return id, priv, nil
+	}
+	switch {
+	case keystore.GetInt("version") != VERSION:
+		return "", nil, ErrorAppend(ErrInvalidField, "version")
+	case keystore.GetStr("cipher") != CIPHER:
+		return "", nil, ErrorAppend(ErrInvalidField, "cipher")
+	case keystore.GetStr("kdf") != KDF:
+		return "", nil, ErrorAppend(ErrInvalidField, "kdf")
+	case keystore.GetStr("keyType") != KEY_TYPE:
+		return "", nil, ErrorAppend(ErrInvalidField, "keyType")
+	}
+	params := keystore.GetData("kdfParams")
+	if params == nil {
+		return "", nil, ErrorAppend(ErrInvalidField, "kdfParams")
+	}
+	key, err := deriveKey(password, params)
+	if err != nil {
+		return "", nil, err
+	}
+	ciphertext, err := Base64UrlDecode(keystore.GetStr("ciphertext"))
+	if err != nil {
+		return "", nil, ErrorAppend(ErrInvalidField, "ciphertext")
+	}
+	data, err := header(keystore)
+	if err != nil {
+		return "", nil, err
+	}
+	p, err := aes_gcm.Open(key, ciphertext, data)
+	if err != nil {
+		return "", nil, ErrorAppend(ErrInvalidKey, "wrong password or keystore was changed")
+	}
+	priv := new(ed25519.PrivateKey)
+	if err = priv.FromBytes(p); err != nil {
+		return "", nil, err
+	}
+	return id, priv, nil
+}
+
+func Plaintext(keystore Data) bool {
+	return keystore.Get("privateKey") != nil
+}
+
+func Read(r io.Reader, password string) (string, crypto.PrivateKey, error) {
+	keystore := make(Data)
+	if err := ReadJSON(r, &keystore); err != nil {
+		return "", nil, err
+	}
+	return Decrypt(keystore, password)
+}
+
+// Writes the keystore so only the owner can read it, replacing any file
+// at path once it's written
+
+func Save(path, id string, priv crypto.PrivateKey, password string) error {
+	keystore, err := Encrypt(id, priv, password)
+	if err != nil {
+		return err
+	}
+	return SaveEncrypted(path, keystore)
+}
+
+// As Save, for a keystore that's already encrypted
+
+func SaveEncrypted(path string, keystore Data) error {
+	p, err := MarshalIndentJSON(keystore)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(p); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Reads the keystore at path, encrypting plaintext credentials under
+// password in place unless password is empty
+
+func Load(path, password string) (string, crypto.PrivateKey, error) {
+	p, err := ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	keystore := make(Data)
+	if err = UnmarshalJSON(p, &keystore); err != nil {
+		return "", nil, err
+	}
+	id, priv, err := Decrypt(keystore, password)
+	if err != nil {
+		return "", nil, err
+	}
+	if Plaintext(keystore) && !EmptyStr(password) {
+		if err = Save(path, id, priv, password); err != nil {
+			return "", nil, err
+		}
+	}
+	return id, priv, nil
+}
@@ -0,0 +1,176 @@
+package linked_data
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+)
+
+// Proof bundled with every tx its verifier reads, as Data{"proof", "txs"},
+// e.g. the parties, composition, publication, right and transfers behind
+// a right-holder proof, so it can be checked without ledger access
+//
+// Offline, each tx is checked as by VerifyTxSigned and VerifyTxInputs,
+// reading the txs TRANSFERs consume from the bundle too; then the
+// proof is verified by the same rules, reading only bundled txs
+// A bundle can't show that its txs are committed or its outputs unspent
+
+func BundleProof(cli *bigchain.Client, proof Data) (Data, error) {
+	recorder := &txRecorder{
+		cache: cli.Cache(),
+		txs:   make(map[string][]byte),
+	}
+	recording := cli.WithCache(recorder)
+	if err := VerifyProof(recording, proof); err != nil {
+		return nil, err
+	}
+	for _, tx := range recorder.list() {
+		if bigchain.GetTxOperation(tx) != bigchain.TRANSFER {
+			continue
+		}
+		for _, input := range bigchain.GetTxInputs(tx) {
+			consumeId, _ := bigchain.GetInputFulfills(input)
+			if _, err := recording.GetTx(consumeId); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return Data{
+		"proof": proof,
+		"txs":   recorder.list(),
+	}, nil
+}
+
+func VerifyBundle(bundle Data) error {
+	proof := bundle.GetData("proof")
+	if proof == nil {
+		return ErrorAppend(ErrInvalidField, "proof")
+	}
+	var txs []Data
+	switch v := bundle.Get("txs").(type) {
+	case []Data:
+		txs = v
+	case []interface{}:
+		txs = make([]Data, len(v))
+		for i, tx := range v {
+			txs[i] = AssertData(tx)
+		}
+	}
+	bundled := make(bundledTxs)
+	for _, tx := range txs {
+		if tx == nil {
+			return ErrorAppend(ErrInvalidField, "txs")
+		}
+		txId := bigchain.GetId(tx)
+		if err := bigchain.VerifyTxSigned(txId, tx); err != nil {
+			return err
+		}
+		p, err := MarshalJSON(tx)
+		if err != nil {
+			return err
+		}
+		bundled[txId] = p
+	}
+	for _, tx := range txs {
+		txId := bigchain.GetId(tx)
+		err := bigchain.VerifyTxInputs(txId, tx, func(consumeId string) (Data, error) {
+			consumed, ok := bundled.GetTx(consumeId)
+			if !ok {
+				return nil, ErrorAppend(ErrCriteriaNotMet, "bundle is missing tx consumed by "+txId)
+			}
+			return consumed, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	offline := bigchain.NewClient("").WithCache(bundled)
+	offline.SetHttpClient(&http.Client{Transport: offlineTransport{}})
+	return VerifyProof(offline, proof)
+}
+
+// Records txs read through the client, e.g. by a verifier
+
+type txRecorder struct {
+	cache bigchain.TxCache
+	mtx   sync.Mutex
+	txs   map[string][]byte
+}
+
+func (recorder *txRecorder) record(txId string, tx Data) {
+	p, err := MarshalJSON(tx)
+	if err != nil {
+		return
+	}
+	recorder.mtx.Lock()
+	recorder.txs[txId] = p
+	recorder.mtx.Unlock()
+}
+
+func (recorder *txRecorder) GetTx(txId string) (Data, bool) {
+	if recorder.cache == nil {
+		return nil, false
+	}
+	tx, ok := recorder.cache.GetTx(txId)
+	if ok {
+		recorder.record(txId, tx)
+	}
+	return tx, ok
+}
+
+func (recorder *txRecorder) PutTx(txId string, tx Data) error {
+	recorder.record(txId, tx)
+	if recorder.cache == nil {
+		return nil
+	}
+	return recorder.cache.PutTx(txId, tx)
+}
+
+// Recorded txs by id
+
+func (recorder *txRecorder) list() []Data {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	txIds := make([]string, 0, len(recorder.txs))
+	for txId := range recorder.txs {
+		txIds = append(txIds, txId)
+	}
+	sort.Strings(txIds)
+	txs := make([]Data, len(txIds))
+	for i, txId := range txIds {
+		txs[i] = make(Data)
+		MustUnmarshalJSON(recorder.txs[txId], &txs[i])
+	}
+	return txs
+}
+
+// Each call returns a fresh copy since validators set fields on models
+
+type bundledTxs map[string][]byte
+
+func (bundled bundledTxs) GetTx(txId string) (Data, bool) {
+	p, ok := bundled[txId]
+	if !ok {
+		return nil, false
+	}
+	tx := make(Data)
+	if err := UnmarshalJSON(p, &tx); err != nil {
+		return nil, false
+	}
+	return tx, true
+}
+
+func (bundled bundledTxs) PutTx(txId string, tx Data) error {
+	return nil
+}
+
+// Fails every request so txs missing from a bundle aren't fetched
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, ErrorAppend(ErrInvalidRequest, "tx not in bundle")
+}
@@ -0,0 +1,330 @@
+package linked_data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/spec"
+)
+
+// Licenses with the usage term are exclusive, e.g. usage ["exclusive", "stream"]
+
+const USAGE_EXCLUSIVE = "exclusive"
+
+// Overlapping licenses of a right, publication or release, as
+// Data{"invalid", "licenseIds", "overlaps", "unheld"}
+//
+// Licenses of a right include those issued under its transfers; licenses
+// of a publication or release include those listing its compositions or
+// recordings. Overlaps are Data{"conflict", "licenseIds", "subjectIds",
+// "territory", "usage", "validFrom", "validThrough"} for each pair of
+// licenses of the same compositions or recordings that overlap in
+// territory, validity window and usage; they conflict if either license
+// is exclusive. A license without usage covers every use
+//
+// Unheld are Data{"licenseId", "rightId", "senderId", "validFrom"} for
+// licenses whose sender held no shares in the right at issuance, taken
+// as validFrom since txs aren't timestamped. Transfers apply as in ResolveOwnership
+//
+// Invalid are Data{"licenseId", "message"} for licenses whose terms
+// can't be read or whose right can't be resolved, so they can't be
+// checked for overlaps or held shares
+
+func AnalyzeLicenses(cli *bigchain.Client, id string) (Data, error) {
+	tx, err := cli.GetTx(id)
+	if err != nil {
+		return nil, err
+	}
+	model := bigchain.GetTxData(tx)
+	queries := []string{id}
+	var subjectIds []string
+	_type := GetModelType(model)
+	switch _type {
+	case "right":
+		transfers, err := queryTransferModels(cli, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, transfer := range transfers {
+			queries = append(queries, transfer.GetStr("id"))
+		}
+	case "publication":
+		subjectIds = spec.GetCompositionIds(model)
+	case "release":
+		subjectIds = spec.GetRecordingIds(model)
+	default:
+		return nil, ErrorAppend(ErrInvalidType, "expected right, publication or release")
+	}
+	queries = append(queries, subjectIds...)
+	found := make(map[string]*licenseTerms)
+	invalid := make(map[string]string)
+	models := make(map[string]Data)
+	for _, query := range queries {
+		for _, licenseType := range []string{"master_license", "mechanical_license"} {
+			licenses, err := SearchModels(cli, query, 0, licenseType)
+			if err != nil {
+				return nil, err
+			}
+			for _, license := range licenses {
+				licenseId := license.GetStr("id")
+				if _, ok := found[licenseId]; ok {
+					continue
+				}
+				if _, ok := invalid[licenseId]; ok {
+					continue
+				}
+				terms, err := newLicenseTerms(cli, licenseId, license.GetData("data"), licenseType, models)
+				if err != nil {
+					invalid[licenseId] = err.Error()
+					continue
+				}
+				if terms.references(id, _type, subjectIds) {
+					found[licenseId] = terms
+				}
+			}
+		}
+	}
+	licenseIds := make([]string, 0, len(found))
+	for licenseId := range found {
+		licenseIds = append(licenseIds, licenseId)
+	}
+	sort.Strings(licenseIds)
+	overlaps := []Data{}
+	for i, licenseId := range licenseIds {
+		for _, otherId := range licenseIds[i+1:] {
+			if overlap := found[licenseId].overlap(found[otherId]); overlap != nil {
+				overlaps = append(overlaps, overlap)
+			}
+		}
+	}
+	unheld := []Data{}
+	ownerships := make(map[string]Data)
+	unresolved := make(map[string]error)
+	for _, licenseId := range licenseIds {
+		terms := found[licenseId]
+		if EmptyStr(terms.rightId) {
+			continue
+		}
+		validFrom := spec.GetValidFrom(terms.license)
+		key := terms.rightId + "/" + validFrom
+		if err, ok := unresolved[key]; ok {
+			invalid[licenseId] = "right " + terms.rightId + ": " + err.Error()
+			continue
+		}
+		ownership, ok := ownerships[key]
+		if !ok {
+			if ownership, err = ResolveOwnership(cli, terms.rightId, terms.from); err != nil {
+				unresolved[key] = err
+				invalid[licenseId] = "right " + terms.rightId + ": " + err.Error()
+				continue
+			}
+			ownerships[key] = ownership
+		}
+		senderId := spec.GetSenderId(terms.license)
+		shares := 0
+		for _, holder := range ownership.GetDataSlice("holders") {
+			partyIds := []string{holder.GetStr("partyId")}
+			if holder.Get("partyIds") != nil {
+				partyIds = holder.GetStrSlice("partyIds")
+			}
+			for _, partyId := range partyIds {
+				if partyId == senderId {
+					shares += holder.GetInt("shares")
+				}
+			}
+		}
+		if shares == 0 {
+			unheld = append(unheld, Data{
+				"licenseId": licenseId,
+				"rightId":   terms.rightId,
+				"senderId":  senderId,
+				"validFrom": validFrom,
+			})
+		}
+	}
+	invalidIds := make([]string, 0, len(invalid))
+	for licenseId := range invalid {
+		invalidIds = append(invalidIds, licenseId)
+	}
+	sort.Strings(invalidIds)
+	invalidLicenses := make([]Data, len(invalidIds))
+	for i, licenseId := range invalidIds {
+		invalidLicenses[i] = Data{"licenseId": licenseId, "message": invalid[licenseId]}
+	}
+	return Data{
+		"invalid":    invalidLicenses,
+		"licenseIds": licenseIds,
+		"overlaps":   overlaps,
+		"unheld":     unheld,
+	}, nil
+}
+
+type licenseTerms struct {
+	exclusive   bool
+	from        time.Time
+	id          string
+	license     Data
+	licenseType string
+	linkedId    string
+	rightId     string
+	subjectIds  map[string]struct{}
+	through     time.Time
+	usage       []string
+}
+
+// Models are fetched once across licenses
+
+func newLicenseTerms(cli *bigchain.Client, licenseId string, license Data, licenseType string, models map[string]Data) (*licenseTerms, error) {
+	from, through, err := validWindow(license)
+	if err != nil {
+		return nil, err
+	}
+	getModel := func(id string) (Data, error) {
+		if model, ok := models[id]; ok {
+			return model, nil
+		}
+		tx, err := cli.GetTx(id)
+		if err != nil {
+			return nil, err
+		}
+		models[id] = bigchain.GetTxData(tx)
+		return models[id], nil
+	}
+	terms := &licenseTerms{
+		from:        from,
+		id:          licenseId,
+		license:     license,
+		licenseType: licenseType,
+		subjectIds:  make(map[string]struct{}),
+		through:     through,
+	}
+	var subjectIds []string
+	var transferId string
+	if licenseType == "mechanical_license" {
+		subjectIds = spec.GetCompositionIds(license)
+		terms.linkedId = spec.GetPublicationId(license)
+		terms.rightId = spec.GetCompositionRightId(license)
+		transferId = spec.GetCompositionRightTransferId(license)
+	} else {
+		subjectIds = spec.GetRecordingIds(license)
+		terms.linkedId = spec.GetReleaseId(license)
+		terms.rightId = spec.GetRecordingRightId(license)
+		transferId = spec.GetRecordingRightTransferId(license)
+	}
+	if !EmptyStr(terms.linkedId) {
+		linked, err := getModel(terms.linkedId)
+		if err != nil {
+			return nil, err
+		}
+		if licenseType == "mechanical_license" {
+			subjectIds = append(subjectIds, spec.GetCompositionIds(linked)...)
+		} else {
+			subjectIds = append(subjectIds, spec.GetRecordingIds(linked)...)
+		}
+	}
+	if EmptyStr(terms.rightId) && !EmptyStr(transferId) {
+		transfer, err := getModel(transferId)
+		if err != nil {
+			return nil, err
+		}
+		if licenseType == "mechanical_license" {
+			terms.rightId = spec.GetCompositionRightId(transfer)
+		} else {
+			terms.rightId = spec.GetRecordingRightId(transfer)
+		}
+	}
+	for _, subjectId := range subjectIds {
+		terms.subjectIds[subjectId] = struct{}{}
+	}
+	for _, use := range spec.GetUsage(license) {
+		if use == USAGE_EXCLUSIVE {
+			terms.exclusive = true
+		} else {
+			terms.usage = append(terms.usage, use)
+		}
+	}
+	return terms, nil
+}
+
+func (terms *licenseTerms) references(id, _type string, subjectIds []string) bool {
+	if _type == "right" {
+		return terms.rightId == id
+	}
+	if terms.linkedId == id {
+		return true
+	}
+	for _, subjectId := range subjectIds {
+		if _, ok := terms.subjectIds[subjectId]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Nil if the licenses don't overlap
+
+func (terms *licenseTerms) overlap(other *licenseTerms) Data {
+	if terms.licenseType != other.licenseType {
+		return nil
+	}
+	var subjectIds []string
+	for subjectId := range terms.subjectIds {
+		if _, ok := other.subjectIds[subjectId]; ok {
+			subjectIds = append(subjectIds, subjectId)
+		}
+	}
+	if len(subjectIds) == 0 {
+		return nil
+	}
+	sort.Strings(subjectIds)
+	territory := intersect(spec.GetTerritory(terms.license), spec.GetTerritory(other.license))
+	if len(territory) == 0 {
+		return nil
+	}
+	if !terms.from.Before(other.through) || !other.from.Before(terms.through) {
+		return nil
+	}
+	usage := other.usage
+	if len(terms.usage) > 0 {
+		if len(other.usage) > 0 {
+			if usage = intersect(terms.usage, other.usage); len(usage) == 0 {
+				return nil
+			}
+		} else {
+			usage = terms.usage
+		}
+	}
+	validFrom, validThrough := terms.license, terms.license
+	if other.from.After(terms.from) {
+		validFrom = other.license
+	}
+	if other.through.Before(terms.through) {
+		validThrough = other.license
+	}
+	return Data{
+		"conflict":     terms.exclusive || other.exclusive,
+		"licenseIds":   []string{terms.id, other.id},
+		"subjectIds":   subjectIds,
+		"territory":    territory,
+		"usage":        usage,
+		"validFrom":    spec.GetValidFrom(validFrom),
+		"validThrough": spec.GetValidThrough(validThrough),
+	}
+}
+
+// Strings in both, in order of the first
+
+func intersect(strs, others []string) []string {
+	both := []string{}
+	for _, s := range strs {
+		for _, other := range others {
+			if s == other {
+				both = append(both, s)
+				break
+			}
+		}
+	}
+	return both
+}
@@ -0,0 +1,93 @@
+package linked_data
+
+import (
+	"sync"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/spec"
+)
+
+// Routes valid txs from the node's stream to handlers by model type
+// CREATE txs are routed by the type of their asset data, TRANSFER txs
+// as "transfer" with the model of the asset being transferred
+// Models are classified by schema only; handlers validate them if needed
+
+const TYPE_TRANSFER = "transfer"
+
+type ModelHandler func(model, tx Data) error
+
+type Dispatcher struct {
+	cli      *bigchain.Client
+	handlers map[string][]ModelHandler
+	mtx      sync.RWMutex
+}
+
+func NewDispatcher(cli *bigchain.Client) *Dispatcher {
+	return &Dispatcher{
+		cli:      cli,
+		handlers: make(map[string][]ModelHandler),
+	}
+}
+
+func (d *Dispatcher) Handle(_type string, handler ModelHandler) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.handlers[_type] = append(d.handlers[_type], handler)
+}
+
+// Master and mechanical licenses issued to recipientId
+
+func (d *Dispatcher) HandleLicensesTo(recipientId string, handler ModelHandler) {
+	filtered := func(model, tx Data) error {
+		if spec.GetRecipientId(model) != recipientId {
+			return nil
+		}
+		return handler(model, tx)
+	}
+	d.Handle("master_license", filtered)
+	d.Handle("mechanical_license", filtered)
+}
+
+// Fetches the tx in the event and calls the handlers for its type
+// in the order they were registered, stopping at the first error
+
+func (d *Dispatcher) Dispatch(event Data) error {
+	txId := event.GetStr("transaction_id")
+	if !spec.MatchId(txId) {
+		return ErrorAppend(ErrInvalidId, txId)
+	}
+	tx, err := d.cli.GetTx(txId)
+	if err != nil {
+		return err
+	}
+	var _type string
+	var model Data
+	switch bigchain.GetTxOperation(tx) {
+	case bigchain.CREATE:
+		model = bigchain.GetTxData(tx)
+		_type = GetModelType(model)
+	case bigchain.TRANSFER:
+		asset, err := d.cli.GetTx(bigchain.GetTxAssetId(tx))
+		if err != nil {
+			return err
+		}
+		model = bigchain.GetTxData(asset)
+		_type = TYPE_TRANSFER
+	}
+	d.mtx.RLock()
+	handlers := d.handlers[_type]
+	d.mtx.RUnlock()
+	for _, handler := range handlers {
+		if err = handler(model, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dispatches events from sub until its client's context is done
+
+func (d *Dispatcher) Run(sub *bigchain.Subscriber) error {
+	return sub.Run(d.Dispatch)
+}
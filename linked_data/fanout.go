@@ -0,0 +1,75 @@
+package linked_data
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+)
+
+// Linked models are fetched and validated by goroutines holding one of
+// the client's bigchain.DEFAULT_WORKERS worker slots, which
+// validators that fan out in turn share with the caller
+
+// Names the linked item that failed validation by field and position
+
+type ItemError struct {
+	Err   error
+	Field string
+	Index int
+}
+
+func (err *ItemError) Error() string {
+	return Sprintf("%s[%d]: %v", err.Field, err.Index, err.Err)
+}
+
+// Calls validate for i in [0, n), on a new goroutine while a worker
+// slot is free and otherwise on the calling goroutine, so nested calls
+// stay within the slots without waiting on each other
+// Items are handed out in order, and no more once one fails or the
+// client's context is done. Every item before a failing one has been
+// handed out, so the error is that of the first failing item by position,
+// as if the items were validated one at a time
+
+func validateEach(cli *bigchain.Client, field string, n int, validate func(i int) error) error {
+	ctx := cli.Context()
+	workers := cli.Workers()
+	errs := make([]error, n)
+	var failed int32
+	var wg sync.WaitGroup
+	run := func(i int) {
+		if errs[i] = validate(i); errs[i] != nil {
+			atomic.StoreInt32(&failed, 1)
+		}
+	}
+	handedOut := 0
+	for ; handedOut < n; handedOut++ {
+		if atomic.LoadInt32(&failed) == 1 || ctx.Err() != nil {
+			break
+		}
+		select {
+		case workers <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+				run(i)
+			}(handedOut)
+		default:
+			run(handedOut)
+		}
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return &ItemError{err, field, i}
+		}
+	}
+	if handedOut < n {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package linked_data
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+)
+
+func TestValidateEach(t *testing.T) {
+	cli := bigchain.NewClient("")
+	var active, maxActive int32
+	for k := 0; k < 20; k++ {
+		err := validateEach(cli, "recordings", 40, func(i int) error {
+			n := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			for {
+				max := atomic.LoadInt32(&maxActive)
+				if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+					break
+				}
+			}
+			// Later failures may finish first
+			time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+			if i == 3 || i == 5 || i == 30 {
+				return Errorf("item %d", i)
+			}
+			return nil
+		})
+		itemErr, ok := err.(*ItemError)
+		if !ok || itemErr.Index != 3 || itemErr.Error() != "recordings[3]: item 3" {
+			t.Fatalf("Expected error for first failing item, got %v", err)
+		}
+	}
+	// The caller works too
+	workers := int32(cap(cli.Workers())) + 1
+	if maxActive > workers {
+		t.Errorf("Expected at most %d workers, got %d", workers, maxActive)
+	}
+	// Nested calls share the client's slots
+	active, maxActive = 0, 0
+	var nested func(depth int) func(i int) error
+	nested = func(depth int) func(i int) error {
+		return func(i int) error {
+			if depth < 3 {
+				return validateEach(cli, "nested", 8, nested(depth+1))
+			}
+			n := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			for {
+				max := atomic.LoadInt32(&maxActive)
+				if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+					break
+				}
+			}
+			time.Sleep(100 * time.Microsecond)
+			return nil
+		}
+	}
+	if err := validateEach(cli, "nested", 8, nested(1)); err != nil {
+		t.Fatal(err)
+	}
+	if maxActive > workers {
+		t.Errorf("Expected at most %d workers for nested calls, got %d", workers, maxActive)
+	}
+	if err := validateEach(cli, "recordings", 0, nil); err != nil {
+		t.Error(err)
+	}
+	// No items are handed out once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls int32
+	err := validateEach(cli.WithContext(ctx), "recordings", 40, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	if err != context.Canceled || calls != 0 {
+		t.Errorf("Expected context canceled, got %v after %d calls", err, calls)
+	}
+}
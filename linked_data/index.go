@@ -0,0 +1,125 @@
+package linked_data
+
+import (
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/store"
+)
+
+// Persistent index of fetched txs by id
+// CREATE txs are also indexed by model type and by the links in their
+// models, e.g. recordings by the composition in "recordingOf"
+// Only txs fetched through the client are indexed, e.g. by validators
+// or a Dispatcher. Set it as the client's cache so validators read through it:
+//
+//	index, err := OpenIndex(path)
+//	cli.SetCache(index)
+//
+// Keys: tx/<id>, type/<type>/<id> and link/<field>/<linkedId>/<id>
+
+type Index struct {
+	store *store.Store
+}
+
+func OpenIndex(path string) (*Index, error) {
+	store, err := store.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{store}, nil
+}
+
+func (index *Index) Close() error {
+	return index.store.Close()
+}
+
+// Each call returns a fresh copy since FulfilledTx mutates the tx
+
+func (index *Index) GetTx(txId string) (Data, bool) {
+	p, ok := index.store.Get("tx/" + txId)
+	if !ok {
+		return nil, false
+	}
+	tx := make(Data)
+	if err := UnmarshalJSON(p, &tx); err != nil {
+		return nil, false
+	}
+	return tx, true
+}
+
+// The tx is written last so a tx found in the index is fully indexed
+
+func (index *Index) PutTx(txId string, tx Data) error {
+	if index.store.Has("tx/" + txId) {
+		return nil
+	}
+	if bigchain.GetTxOperation(tx) == bigchain.CREATE {
+		model := bigchain.GetTxData(tx)
+		if _type := GetModelType(model); !EmptyStr(_type) {
+			if err := index.store.Put("type/"+_type+"/"+txId, nil); err != nil {
+				return err
+			}
+		}
+		for field, value := range model {
+			for _, linkedId := range linkedIds(value) {
+				if err := index.store.Put("link/"+field+"/"+linkedId+"/"+txId, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	p, err := MarshalJSON(tx)
+	if err != nil {
+		return err
+	}
+	return index.store.Put("tx/"+txId, p)
+}
+
+// Ids in a link, e.g. {"id": id}, or a list of links
+
+func linkedIds(value interface{}) []string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if id, ok := value["id"].(string); ok {
+			return []string{id}
+		}
+	case Data:
+		return linkedIds(map[string]interface{}(value))
+	case []interface{}:
+		var ids []string
+		for _, v := range value {
+			ids = append(ids, linkedIds(v)...)
+		}
+		return ids
+	case []Data:
+		var ids []string
+		for _, v := range value {
+			ids = append(ids, linkedIds(v)...)
+		}
+		return ids
+	}
+	return nil
+}
+
+func suffixes(keys []string, prefix string) []string {
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = key[len(prefix):]
+	}
+	return ids
+}
+
+// Ids of indexed models of the type, sorted
+
+func (index *Index) ModelIds(_type string) []string {
+	prefix := "type/" + _type + "/"
+	return suffixes(index.store.Keys(prefix), prefix)
+}
+
+// Ids of indexed models whose field links to id, sorted,
+// e.g. LinkedIds("recordingOf", compositionId) for its recordings
+
+func (index *Index) LinkedIds(field, id string) []string {
+	prefix := "link/" + field + "/" + id + "/"
+	return suffixes(index.store.Keys(prefix), prefix)
+}
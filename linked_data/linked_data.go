@@ -1,7 +1,8 @@
 package linked_data
 
 import (
-	"github.com/zbo14/balloon"
+	"time"
+
 	"github.com/zbo14/envoke/bigchain"
 	. "github.com/zbo14/envoke/common"
 	"github.com/zbo14/envoke/crypto/crypto"
@@ -9,8 +10,8 @@ import (
 	"github.com/zbo14/envoke/spec"
 )
 
-func QueryAndValidateModel(id string, _type string) (Data, error) {
-	tx, err := bigchain.GetTx(id)
+func QueryAndValidateModel(cli *bigchain.Client, id string, _type string) (Data, error) {
+	tx, err := cli.GetTx(id)
 	if err != nil {
 		return nil, err
 	}
@@ -21,26 +22,15 @@ func QueryAndValidateModel(id string, _type string) (Data, error) {
 	return tx, nil
 }
 
-var SALT = balloon.GenerateSalt()
-
-func DefaultBalloonHash(challenge string) ([]byte, error) {
-	p, err := Base64UrlDecode(challenge)
-	if err != nil {
-		return nil, err
-	}
-	// TODO: adjust params
-	return balloon.BalloonHash(p, SALT, 256, 32, 2), nil
-}
-
-func ValidateComposition(compositionId string) (Data, error) {
-	tx, err := QueryAndValidateModel(compositionId, "composition")
+func ValidateComposition(cli *bigchain.Client, compositionId string) (Data, error) {
+	tx, err := QueryAndValidateModel(cli, compositionId, "composition")
 	if err != nil {
 		return nil, err
 	}
 	composition := bigchain.GetTxData(tx)
 	senderPub := bigchain.DefaultGetTxSender(tx)
 	composerId := spec.GetComposerId(composition)
-	tx, err = QueryAndValidateModel(composerId, "party")
+	tx, err = QueryAndValidateModel(cli, composerId, "party")
 	if err != nil {
 		return nil, err
 	}
@@ -50,35 +40,35 @@ func ValidateComposition(compositionId string) (Data, error) {
 	return composition, nil
 }
 
-func QueryCompositionField(compositionId, field string) (interface{}, error) {
-	composition, err := ValidateComposition(compositionId)
+func QueryCompositionField(cli *bigchain.Client, compositionId, field string) (interface{}, error) {
+	composition, err := ValidateComposition(cli, compositionId)
 	if err != nil {
 		return nil, err
 	}
 	switch field {
 	case "composer":
-		return GetComposer(composition)
+		return GetComposer(cli, composition)
 		//..
 	}
 	return nil, ErrorAppend(ErrInvalidField, field)
 }
 
-func GetComposer(data Data) (Data, error) {
+func GetComposer(cli *bigchain.Client, data Data) (Data, error) {
 	composerId := spec.GetComposerId(data)
-	tx, err := bigchain.GetTx(composerId)
+	tx, err := cli.GetTx(composerId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func ProveComposer(challenge, compositionId string, priv crypto.PrivateKey) (crypto.Signature, error) {
-	composition, err := ValidateComposition(compositionId)
+func ProveComposer(cli *bigchain.Client, challenge, compositionId string, priv crypto.PrivateKey) (Data, error) {
+	composition, err := ValidateComposition(cli, compositionId)
 	if err != nil {
 		return nil, err
 	}
 	composerId := spec.GetComposerId(composition)
-	tx, err := bigchain.GetTx(composerId)
+	tx, err := cli.GetTx(composerId)
 	if err != nil {
 		return nil, err
 	}
@@ -86,53 +76,118 @@ func ProveComposer(challenge, compositionId string, priv crypto.PrivateKey) (cry
 	if !senderPub.Equals(priv.Public()) {
 		return nil, ErrorAppend(ErrInvalidKey, priv.Public().String())
 	}
-	hash, err := DefaultBalloonHash(challenge)
+	return SignProof(NewProof(challenge, ROLE_COMPOSER, compositionId), priv)
+}
+
+func VerifyComposer(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_COMPOSER); err != nil {
+		return err
+	}
+	compositionId := GetProofModelId(proof)
+	composition, err := ValidateComposition(cli, compositionId)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	composerId := spec.GetComposerId(composition)
+	tx, err := cli.GetTx(composerId)
+	if err != nil {
+		return err
 	}
-	return priv.Sign(hash), nil
+	return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 }
 
-func VerifyComposer(challenge, compositionId string, sig crypto.Signature) error {
-	composition, err := ValidateComposition(compositionId)
+// Rights and licenses are in force from the start of validFrom
+// through the end of validThrough
+
+func validWindow(model Data) (time.Time, time.Time, error) {
+	from, err := ParseDateStr(spec.GetValidFrom(model))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	through, err := ParseDateStr(spec.GetValidThrough(model))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if through.Before(from) {
+		return time.Time{}, time.Time{}, ErrorAppend(ErrInvalidTime, "validThrough before validFrom")
+	}
+	return from, through.AddDate(0, 0, 1), nil
+}
+
+func checkValidAt(model Data, at time.Time) error {
+	from, through, err := validWindow(model)
 	if err != nil {
 		return err
 	}
-	composerId := spec.GetComposerId(composition)
-	tx, err := bigchain.GetTx(composerId)
+	if at.Before(from) {
+		return ErrorAppend(ErrInvalidTime, "not valid until "+spec.GetValidFrom(model))
+	}
+	if !at.Before(through) {
+		return ErrorAppend(ErrInvalidTime, "expired after "+spec.GetValidThrough(model))
+	}
+	return nil
+}
+
+func checkValidWithin(license, right Data) error {
+	licenseFrom, licenseThrough, err := validWindow(license)
 	if err != nil {
 		return err
 	}
-	hash, err := DefaultBalloonHash(challenge)
+	rightFrom, rightThrough, err := validWindow(right)
 	if err != nil {
 		return err
 	}
-	senderPub := bigchain.DefaultGetTxSender(tx)
-	if !senderPub.Verify(hash, sig) {
-		return ErrorAppend(ErrInvalidSignature, sig.String())
+	if licenseFrom.Before(rightFrom) || licenseThrough.After(rightThrough) {
+		return ErrorAppend(ErrCriteriaNotMet, "license window not within right window")
 	}
 	return nil
 }
 
-func ValidateRight(rightId string) (Data, crypto.PublicKey, crypto.PublicKey, error) {
-	tx, err := QueryAndValidateModel(rightId, "right")
+func ValidateRight(cli *bigchain.Client, rightId string) (Data, crypto.PublicKey, crypto.PublicKey, error) {
+	return ValidateRightAt(cli, rightId, Now())
+}
+
+// The right must be in force at the given time, now if zero
+
+func ValidateRightAt(cli *bigchain.Client, rightId string, at time.Time) (Data, crypto.PublicKey, crypto.PublicKey, error) {
+	if at.IsZero() {
+		at = Now()
+	}
+	return validateRight(cli, rightId, at)
+}
+
+// The window is only checked against a non-zero time, so rights
+// linked from publications and releases stay valid once expired
+
+func validateRight(cli *bigchain.Client, rightId string, at time.Time) (Data, crypto.PublicKey, crypto.PublicKey, error) {
+	tx, err := QueryAndValidateModel(cli, rightId, "right")
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	right := bigchain.GetTxData(tx)
+	if at.IsZero() {
+		_, _, err = validWindow(right)
+	} else {
+		err = checkValidAt(right, at)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	recipientId := spec.GetRecipientId(right)
-	recipientPub := bigchain.DefaultGetTxRecipient(tx)
+	// A co-owned right's output lists the co-owners with the recipient
+	owners := bigchain.GetTxRecipients(tx)[0]
 	recipientShares := bigchain.GetTxShares(tx)
 	senderId := spec.GetSenderId(right)
 	senderPub := bigchain.DefaultGetTxSender(tx)
-	tx, err = QueryAndValidateModel(recipientId, "party")
+	tx, err = QueryAndValidateModel(cli, recipientId, "party")
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	if !recipientPub.Equals(bigchain.DefaultGetTxSender(tx)) {
+	recipientPub := bigchain.DefaultGetTxSender(tx)
+	if !hasPublicKey(owners, recipientPub) {
 		return nil, nil, nil, ErrorAppend(ErrInvalidKey, recipientPub.String())
 	}
-	tx, err = QueryAndValidateModel(senderId, "party")
+	tx, err = QueryAndValidateModel(cli, senderId, "party")
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -143,15 +198,27 @@ func ValidateRight(rightId string) (Data, crypto.PublicKey, crypto.PublicKey, er
 	return right, recipientPub, senderPub, nil
 }
 
-func ProveCompositionRightHolder(challenge, compositionRightId string, priv crypto.PrivateKey, publicationId string) (crypto.Signature, error) {
-	_, _, compositionRights, err := ValidatePublication(publicationId)
+func hasPublicKey(pubs []crypto.PublicKey, pub crypto.PublicKey) bool {
+	for _, other := range pubs {
+		if pub.Equals(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func ProveCompositionRightHolder(cli *bigchain.Client, challenge, compositionRightId string, priv crypto.PrivateKey, publicationId string) (Data, error) {
+	_, _, compositionRights, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return nil, err
 	}
 	for _, compositionRight := range compositionRights {
 		if compositionRightId == spec.GetId(compositionRight) {
+			if err = checkValidAt(compositionRight, Now()); err != nil {
+				return nil, err
+			}
 			recipientId := spec.GetRecipientId(compositionRight)
-			tx, err := bigchain.GetTx(recipientId)
+			tx, err := cli.GetTx(recipientId)
 			if err != nil {
 				return nil, err
 			}
@@ -159,62 +226,232 @@ func ProveCompositionRightHolder(challenge, compositionRightId string, priv cryp
 			if pub := priv.Public(); !recipientPub.Equals(pub) {
 				return nil, ErrorAppend(ErrInvalidKey, pub.String())
 			}
-			hash, err := DefaultBalloonHash(challenge)
-			if err != nil {
-				return nil, err
-			}
-			return priv.Sign(hash), nil
+			proof := NewProof(challenge, ROLE_COMPOSITION_RIGHT_HOLDER, compositionRightId)
+			proof.Set("publicationId", publicationId)
+			return SignProof(proof, priv)
 		}
 	}
 	return nil, ErrorAppend(ErrCriteriaNotMet, "publication does not link to composition right")
 }
 
-func VerifyCompositionRightHolder(challenge, compositionRightId, publicationId string, sig crypto.Signature) error {
-	_, _, compositionRights, err := ValidatePublication(publicationId)
+func VerifyCompositionRightHolder(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_COMPOSITION_RIGHT_HOLDER); err != nil {
+		return err
+	}
+	compositionRightId := GetProofModelId(proof)
+	publicationId := proof.GetStr("publicationId")
+	_, _, compositionRights, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return err
 	}
 	for _, compositionRight := range compositionRights {
 		if compositionRightId == spec.GetId(compositionRight) {
-			recipientId := spec.GetRecipientId(compositionRight)
-			tx, err := bigchain.GetTx(recipientId)
-			if err != nil {
+			if err = checkValidAt(compositionRight, Now()); err != nil {
 				return err
 			}
-			hash, err := DefaultBalloonHash(challenge)
+			recipientId := spec.GetRecipientId(compositionRight)
+			tx, err := cli.GetTx(recipientId)
 			if err != nil {
 				return err
 			}
-			recipientPub := bigchain.DefaultGetTxSender(tx)
-			if !recipientPub.Verify(hash, sig) {
-				return ErrorAppend(ErrInvalidSignature, sig.String())
-			}
-			return nil
+			return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 		}
 	}
 	return ErrorAppend(ErrCriteriaNotMet, "publication does not link to composition right")
 }
 
-func GetRecipient(data Data) (Data, error) {
+func GetRecipient(cli *bigchain.Client, data Data) (Data, error) {
 	recipientId := spec.GetRecipientId(data)
-	tx, err := bigchain.GetTx(recipientId)
+	tx, err := cli.GetTx(recipientId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func GetSender(data Data) (Data, error) {
+func GetSender(cli *bigchain.Client, data Data) (Data, error) {
 	senderId := spec.GetSenderId(data)
-	tx, err := bigchain.GetTx(senderId)
+	tx, err := cli.GetTx(senderId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func QueryPublicationField(field, publicationId string) (interface{}, error) {
-	publication, compositions, compositionRights, err := ValidatePublication(publicationId)
+// Metadata attached to the tx with id, nil if there is none
+
+func QueryMetadata(cli *bigchain.Client, id string) (Data, error) {
+	tx, err := cli.GetTx(id)
+	if err != nil {
+		return nil, err
+	}
+	return bigchain.GetTxMetadata(tx), nil
+}
+
+// Metadata of the TRANSFER tx a right transfer links to
+
+func GetTransferMetadata(cli *bigchain.Client, data Data) (Data, error) {
+	txId := spec.GetTxId(data)
+	return QueryMetadata(cli, txId)
+}
+
+// Text search over models of one type, as Data{"data", "id"}
+// The node's limit applies before filtering, so every match is
+// requested and the results are cut to limit after
+
+func SearchModels(cli *bigchain.Client, query string, limit int, _type string) ([]Data, error) {
+	assets, err := cli.SearchAssets(query, 0)
+	if err != nil {
+		return nil, err
+	}
+	var models []Data
+	for _, asset := range assets {
+		if limit > 0 && len(models) == limit {
+			break
+		}
+		if err = schema.ValidateModel(asset.GetData("data"), _type); err != nil {
+			continue
+		}
+		id := asset.GetStr("id")
+		tx, err := QueryAndValidateModel(cli, id, _type)
+		if err != nil {
+			continue
+		}
+		models = append(models, Data{
+			"data": bigchain.GetTxData(tx),
+			"id":   id,
+		})
+	}
+	return models, nil
+}
+
+func SearchCompositions(cli *bigchain.Client, query string, limit int) ([]Data, error) {
+	return SearchModels(cli, query, limit, "composition")
+}
+
+func SearchParties(cli *bigchain.Client, query string, limit int) ([]Data, error) {
+	return SearchModels(cli, query, limit, "party")
+}
+
+func SearchRecordings(cli *bigchain.Client, query string, limit int) ([]Data, error) {
+	return SearchModels(cli, query, limit, "recording")
+}
+
+// Rights with unspent outputs held by pub alone, as
+// Data{"outputs", "right", "rightId", "shares"}
+// where outputs are Data{"amount", "output", "txid"}
+
+func QueryHeldRights(cli *bigchain.Client, pub crypto.PublicKey) ([]Data, error) {
+	return queryHeldRights(cli, pub, "")
+}
+
+func QueryHeldRight(cli *bigchain.Client, pub crypto.PublicKey, rightId string) (Data, error) {
+	rights, err := queryHeldRights(cli, pub, rightId)
+	if err != nil {
+		return nil, err
+	}
+	if len(rights) == 0 {
+		return nil, ErrorAppend(ErrCriteriaNotMet, "party does not hold shares in right")
+	}
+	return rights[0], nil
+}
+
+func queryHeldRights(cli *bigchain.Client, pub crypto.PublicKey, rightId string) ([]Data, error) {
+	unspent, err := cli.ListUnspentOutputs(pub)
+	if err != nil {
+		return nil, err
+	}
+	var rights []Data
+	held := make(map[string]Data)
+	skip := make(map[string]bool)
+	for _, output := range unspent {
+		txId := output.GetStr("txid")
+		n := output.GetInt("output")
+		tx, err := cli.GetTx(txId)
+		if err != nil {
+			return nil, err
+		}
+		assetId := bigchain.GetTxAssetIdOrId(tx)
+		if skip[assetId] || (!EmptyStr(rightId) && rightId != assetId) {
+			continue
+		}
+		outputs := bigchain.GetTxOutputs(tx)
+		if n < 0 || n >= len(outputs) {
+			return nil, ErrorAppend(ErrInvalidSize, "output index out of range")
+		}
+		// Outputs shared with other keys can't be spent alone
+		pubs := bigchain.GetOutputPublicKeys(outputs[n])
+		if len(pubs) != 1 || !pub.Equals(pubs[0]) {
+			continue
+		}
+		right, ok := held[assetId]
+		if !ok {
+			if tx, err = cli.GetTx(assetId); err != nil {
+				return nil, err
+			}
+			model := bigchain.GetTxData(tx)
+			if !isRight(model) {
+				skip[assetId] = true
+				continue
+			}
+			right = Data{
+				"outputs": []Data{},
+				"right":   model,
+				"rightId": assetId,
+				"shares":  0,
+			}
+			held[assetId] = right
+			rights = append(rights, right)
+		}
+		amount := bigchain.GetOutputAmount(outputs[n])
+		output.Set("amount", amount)
+		right.Set("outputs", append(right.Get("outputs").([]Data), output))
+		right.Set("shares", right.GetInt("shares")+amount)
+	}
+	return rights, nil
+}
+
+// Model types in the order data is classified
+// Licenses also validate as rights so they're tried first
+
+var modelTypes = []string{
+	"master_license",
+	"mechanical_license",
+	"composition",
+	"composition_right_transfer",
+	"party",
+	"publication",
+	"recording",
+	"recording_right_transfer",
+	"release",
+	"right",
+}
+
+// Type of model the data validates as, or "" if none
+
+func GetModelType(model Data) string {
+	for _, _type := range modelTypes {
+		if schema.ValidateModel(model, _type) == nil {
+			return _type
+		}
+	}
+	return ""
+}
+
+func isRight(model Data) bool {
+	if len(model) != 5 {
+		return false
+	}
+	for _, field := range []string{"recipient", "sender", "territory", "validFrom", "validThrough"} {
+		if _, ok := model[field]; !ok {
+			return false
+		}
+	}
+	return schema.ValidateModel(model, "right") == nil
+}
+
+func QueryPublicationField(cli *bigchain.Client, field, publicationId string) (interface{}, error) {
+	publication, compositions, compositionRights, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return nil, err
 	}
@@ -224,13 +461,13 @@ func QueryPublicationField(field, publicationId string) (interface{}, error) {
 	case "composition_rights":
 		return compositionRights, nil
 	case "publisher":
-		return GetPublisher(publication)
+		return GetPublisher(cli, publication)
 	}
 	return nil, ErrorAppend(ErrInvalidField, field)
 }
 
-func ValidatePublication(publicationId string) (Data, []Data, []Data, error) {
-	tx, err := QueryAndValidateModel(publicationId, "publication")
+func ValidatePublication(cli *bigchain.Client, publicationId string) (Data, []Data, []Data, error) {
+	tx, err := QueryAndValidateModel(cli, publicationId, "publication")
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -239,21 +476,22 @@ func ValidatePublication(publicationId string) (Data, []Data, []Data, error) {
 	var composerId string
 	compositionIds := spec.GetCompositionIds(publication)
 	compositions := make([]Data, len(compositionIds))
-	for i, compositionId := range compositionIds {
-		composition, err := ValidateComposition(compositionId)
-		if err != nil {
-			return nil, nil, nil, err
-		}
+	if err = validateEach(cli, "compositions", len(compositionIds), func(i int) (err error) {
+		compositions[i], err = ValidateComposition(cli, compositionIds[i])
+		return
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+	for i, composition := range compositions {
 		if i == 0 {
 			composerId = spec.GetComposerId(composition)
 			// TODO: check composerId
 		} else if composerId != spec.GetComposerId(composition) {
 			return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "publication cannot link to compositions by different composers")
 		}
-		composition.Set("id", compositionId)
-		compositions[i] = composition
+		composition.Set("id", compositionIds[i])
 	}
-	tx, err = QueryAndValidateModel(composerId, "party")
+	tx, err = QueryAndValidateModel(cli, composerId, "party")
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -262,15 +500,19 @@ func ValidatePublication(publicationId string) (Data, []Data, []Data, error) {
 	}
 	compositionRightIds := spec.GetCompositionRightIds(publication)
 	compositionRights := make([]Data, len(compositionRightIds))
+	recipientPubs := make([]crypto.PublicKey, len(compositionRightIds))
+	if err = validateEach(cli, "compositionRights", len(compositionRightIds), func(i int) (err error) {
+		compositionRights[i], recipientPubs[i], _, err = validateRight(cli, compositionRightIds[i], time.Time{})
+		return
+	}); err != nil {
+		return nil, nil, nil, err
+	}
 	publisherId := spec.GetPublisherId(publication)
 	recipientIds := make(map[string]struct{})
 	rightHolder := false
 	totalShares := 0
-	for i, compositionRightId := range compositionRightIds {
-		compositionRight, recipientPub, _, err := ValidateRight(compositionRightId)
-		if err != nil {
-			return nil, nil, nil, err
-		}
+	for i, compositionRight := range compositionRights {
+		recipientPub := recipientPubs[i]
 		if composerId != spec.GetSenderId(compositionRight) {
 			return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "composer must be right sender")
 		}
@@ -280,7 +522,7 @@ func ValidatePublication(publicationId string) (Data, []Data, []Data, error) {
 		}
 		if !EmptyStr(publisherId) {
 			if !rightHolder && publisherId == recipientId {
-				tx, err = bigchain.GetTx(publisherId)
+				tx, err = cli.GetTx(publisherId)
 				if err != nil {
 					return nil, nil, nil, err
 				}
@@ -298,8 +540,7 @@ func ValidatePublication(publicationId string) (Data, []Data, []Data, error) {
 		if totalShares += shares; totalShares > 100 {
 			return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "total percentage shares cannot exceed 100")
 		}
-		compositionRight.Set("id", compositionRightId)
-		compositionRights[i] = compositionRight
+		compositionRight.Set("id", compositionRightIds[i])
 	}
 	if !EmptyStr(publisherId) && !rightHolder {
 		return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "publisher must be right-holder")
@@ -310,13 +551,13 @@ func ValidatePublication(publicationId string) (Data, []Data, []Data, error) {
 	return publication, compositions, compositionRights, nil
 }
 
-func ProvePublisher(challenge string, priv crypto.PrivateKey, publicationId string) (crypto.Signature, error) {
-	publication, _, _, err := ValidatePublication(publicationId)
+func ProvePublisher(cli *bigchain.Client, challenge string, priv crypto.PrivateKey, publicationId string) (Data, error) {
+	publication, _, _, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return nil, err
 	}
 	publisherId := spec.GetPublisherId(publication)
-	tx, err := bigchain.GetTx(publisherId)
+	tx, err := cli.GetTx(publisherId)
 	if err != nil {
 		return nil, err
 	}
@@ -324,48 +565,44 @@ func ProvePublisher(challenge string, priv crypto.PrivateKey, publicationId stri
 	if pub := priv.Public(); !senderPub.Equals(pub) {
 		return nil, ErrorAppend(ErrInvalidKey, pub.String())
 	}
-	hash, err := DefaultBalloonHash(challenge)
-	if err != nil {
-		return nil, err
-	}
-	return priv.Sign(hash), nil
+	return SignProof(NewProof(challenge, ROLE_PUBLISHER, publicationId), priv)
 }
 
-func VerifyPublisher(challenge, publicationId string, sig crypto.Signature) error {
-	publication, _, _, err := ValidatePublication(publicationId)
+func VerifyPublisher(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_PUBLISHER); err != nil {
+		return err
+	}
+	publicationId := GetProofModelId(proof)
+	publication, _, _, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return err
 	}
 	publisherId := spec.GetPublisherId(publication)
-	tx, err := bigchain.GetTx(publisherId)
+	tx, err := cli.GetTx(publisherId)
 	if err != nil {
 		return err
 	}
-	senderPub := bigchain.DefaultGetTxSender(tx)
-	if !senderPub.Verify(MustMarshalJSON(publication), sig) {
-		return ErrorAppend(ErrInvalidSignature, sig.String())
-	}
-	return nil
+	return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 }
 
-func GetPublisher(data Data) (Data, error) {
+func GetPublisher(cli *bigchain.Client, data Data) (Data, error) {
 	publisherId := spec.GetPublisherId(data)
-	tx, err := bigchain.GetTx(publisherId)
+	tx, err := cli.GetTx(publisherId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func ValidateCompositionRightTransfer(compositionRightTransferId string) (Data, error) {
-	tx, err := QueryAndValidateModel(compositionRightTransferId, "composition_right_transfer")
+func ValidateCompositionRightTransfer(cli *bigchain.Client, compositionRightTransferId string) (Data, error) {
+	tx, err := QueryAndValidateModel(cli, compositionRightTransferId, "composition_right_transfer")
 	if err != nil {
 		return nil, err
 	}
 	compositionRightTransfer := bigchain.GetTxData(tx)
 	senderPub := bigchain.DefaultGetTxSender(tx)
 	recipientId := spec.GetRecipientId(compositionRightTransfer)
-	tx, err = QueryAndValidateModel(recipientId, "party")
+	tx, err = QueryAndValidateModel(cli, recipientId, "party")
 	if err != nil {
 		return nil, err
 	}
@@ -374,7 +611,7 @@ func ValidateCompositionRightTransfer(compositionRightTransferId string) (Data,
 		return nil, ErrorAppend(ErrCriteriaNotMet, "recipient and sender keys must be different")
 	}
 	senderId := spec.GetSenderId(compositionRightTransfer)
-	tx, err = QueryAndValidateModel(senderId, "party")
+	tx, err = QueryAndValidateModel(cli, senderId, "party")
 	if err != nil {
 		return nil, err
 	}
@@ -382,29 +619,33 @@ func ValidateCompositionRightTransfer(compositionRightTransferId string) (Data,
 		return nil, ErrorAppend(ErrInvalidKey, senderPub.String())
 	}
 	publicationId := spec.GetPublicationId(compositionRightTransfer)
-	_, _, compositionRights, err := ValidatePublication(publicationId)
+	_, _, compositionRights, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return nil, err
 	}
 	txId := spec.GetTxId(compositionRightTransfer)
-	tx, err = bigchain.GetTx(txId)
+	tx, err = cli.GetTx(txId)
 	if err != nil {
 		return nil, err
 	}
 	if bigchain.TRANSFER != bigchain.GetTxOperation(tx) {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "expected TRANSFER tx")
 	}
-	if !senderPub.Equals(bigchain.DefaultGetTxSender(tx)) {
-		return nil, ErrorAppend(ErrCriteriaNotMet, "sender is not signer of TRANSFER tx")
+	// A consolidated transfer has several inputs, all signed by sender
+	for _, pubs := range bigchain.GetTxSenders(tx) {
+		if len(pubs) != 1 || !senderPub.Equals(pubs[0]) {
+			return nil, ErrorAppend(ErrCriteriaNotMet, "sender is not signer of TRANSFER tx")
+		}
 	}
 	n := len(bigchain.GetTxOutputs(tx))
 	if n != 1 && n != 2 {
 		return nil, ErrorAppend(ErrInvalidSize, "tx outputs must have size 1 or 2")
 	}
-	if !recipientPub.Equals(bigchain.GetTxRecipient(tx, 1)) {
+	// Recipient holds the only output or the secondary one
+	if !recipientPub.Equals(bigchain.GetTxRecipient(tx, n-1)) {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "recipient does not hold secondary output of TRANSFER tx")
 	}
-	recipientShares := bigchain.GetTxOutputAmount(tx, 1)
+	recipientShares := bigchain.GetTxOutputAmount(tx, n-1)
 	if recipientShares <= 0 || recipientShares > 100 {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "recipient shares must be greater than 0 and less than/equal to 100")
 	}
@@ -436,8 +677,8 @@ func ValidateCompositionRightTransfer(compositionRightTransferId string) (Data,
 	return compositionRightTransfer, nil
 }
 
-func ProveCompositionRightTransferHolder(challenge, compositionRightTransferId, holderId string, priv crypto.PrivateKey, publicationId string) (crypto.Signature, error) {
-	compositionRightTransfer, err := ValidateCompositionRightTransfer(compositionRightTransferId)
+func ProveCompositionRightTransferHolder(cli *bigchain.Client, challenge, compositionRightTransferId, holderId string, priv crypto.PrivateKey, publicationId string) (Data, error) {
+	compositionRightTransfer, err := ValidateCompositionRightTransfer(cli, compositionRightTransferId)
 	if err != nil {
 		return nil, err
 	}
@@ -451,13 +692,13 @@ func ProveCompositionRightTransferHolder(challenge, compositionRightTransferId,
 	} else {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "holder is not recipient or sender of transfer")
 	}
-	_, _, compositionRights, err := ValidatePublication(publicationId)
+	_, _, compositionRights, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return nil, err
 	}
 	for _, compositionRight := range compositionRights {
 		if compositionRightId == spec.GetId(compositionRight) {
-			tx, err := bigchain.GetTx(holderId)
+			tx, err := cli.GetTx(holderId)
 			if err != nil {
 				return nil, err
 			}
@@ -465,18 +706,23 @@ func ProveCompositionRightTransferHolder(challenge, compositionRightTransferId,
 			if pub := priv.Public(); !holderPub.Equals(pub) {
 				return nil, ErrorAppend(ErrInvalidKey, pub.String())
 			}
-			hash, err := DefaultBalloonHash(challenge)
-			if err != nil {
-				return nil, err
-			}
-			return priv.Sign(hash), nil
+			proof := NewProof(challenge, ROLE_COMPOSITION_RIGHT_TRANSFER_HOLDER, compositionRightTransferId)
+			proof.Set("holderId", holderId)
+			proof.Set("publicationId", publicationId)
+			return SignProof(proof, priv)
 		}
 	}
 	return nil, ErrorAppend(ErrCriteriaNotMet, "publication does not link to underlying composition right")
 }
 
-func VerifyCompositionRightTransferHolder(challenge, compositionRightTransferId, holderId, publicationId string, sig crypto.Signature) error {
-	compositionRightTransfer, err := ValidateCompositionRightTransfer(compositionRightTransferId)
+func VerifyCompositionRightTransferHolder(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_COMPOSITION_RIGHT_TRANSFER_HOLDER); err != nil {
+		return err
+	}
+	compositionRightTransferId := GetProofModelId(proof)
+	holderId := proof.GetStr("holderId")
+	publicationId := proof.GetStr("publicationId")
+	compositionRightTransfer, err := ValidateCompositionRightTransfer(cli, compositionRightTransferId)
 	if err != nil {
 		return err
 	}
@@ -490,50 +736,42 @@ func VerifyCompositionRightTransferHolder(challenge, compositionRightTransferId,
 	} else {
 		return ErrorAppend(ErrCriteriaNotMet, "holder is not recipient or sender of transfer")
 	}
-	_, _, compositionRights, err := ValidatePublication(publicationId)
+	_, _, compositionRights, err := ValidatePublication(cli, publicationId)
 	if err != nil {
 		return err
 	}
 	for _, compositionRight := range compositionRights {
 		if compositionRightId == spec.GetId(compositionRight) {
-			tx, err := bigchain.GetTx(holderId)
-			if err != nil {
-				return err
-			}
-			hash, err := DefaultBalloonHash(challenge)
+			tx, err := cli.GetTx(holderId)
 			if err != nil {
 				return err
 			}
-			holderPub := bigchain.DefaultGetTxSender(tx)
-			if !holderPub.Verify(hash, sig) {
-				return ErrorAppend(ErrInvalidSignature, sig.String())
-			}
-			return nil
+			return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 		}
 	}
 	return ErrorAppend(ErrCriteriaNotMet, "publication does not link to underlying composition right")
 }
 
-func GetCompositionRight(data Data) (Data, error) {
+func GetCompositionRight(cli *bigchain.Client, data Data) (Data, error) {
 	compositionRightId := spec.GetCompositionRightId(data)
-	tx, err := bigchain.GetTx(compositionRightId)
+	tx, err := cli.GetTx(compositionRightId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func GetPublication(data Data) (Data, error) {
+func GetPublication(cli *bigchain.Client, data Data) (Data, error) {
 	publicationId := spec.GetPublicationId(data)
-	tx, err := bigchain.GetTx(publicationId)
+	tx, err := cli.GetTx(publicationId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func QueryMechanicalLicenseField(field, mechanicalLicenseId string) (interface{}, error) {
-	mechanicalLicense, compositions, err := ValidateMechanicalLicense(mechanicalLicenseId)
+func QueryMechanicalLicenseField(cli *bigchain.Client, field, mechanicalLicenseId string) (interface{}, error) {
+	mechanicalLicense, compositions, err := ValidateMechanicalLicense(cli, mechanicalLicenseId)
 	if err != nil {
 		return nil, err
 	}
@@ -541,22 +779,46 @@ func QueryMechanicalLicenseField(field, mechanicalLicenseId string) (interface{}
 	case "compositions":
 		return compositions, nil
 	case "recipient":
-		return GetRecipient(mechanicalLicense)
+		return GetRecipient(cli, mechanicalLicense)
 	case "sender":
-		return GetSender(mechanicalLicense)
+		return GetSender(cli, mechanicalLicense)
 	}
 	return nil, ErrorAppend(ErrInvalidField, field)
 }
 
-func ValidateMechanicalLicense(mechanicalLicenseId string) (Data, []Data, error) {
-	tx, err := QueryAndValidateModel(mechanicalLicenseId, "mechanical_license")
+func ValidateMechanicalLicense(cli *bigchain.Client, mechanicalLicenseId string) (Data, []Data, error) {
+	return ValidateMechanicalLicenseAt(cli, mechanicalLicenseId, Now())
+}
+
+// The license must be in force at the given time, now if zero,
+// and its window must fall within that of the right it was issued under
+
+func ValidateMechanicalLicenseAt(cli *bigchain.Client, mechanicalLicenseId string, at time.Time) (Data, []Data, error) {
+	if at.IsZero() {
+		at = Now()
+	}
+	return validateMechanicalLicense(cli, mechanicalLicenseId, at)
+}
+
+// The window is only checked against a non-zero time
+
+func validateMechanicalLicense(cli *bigchain.Client, mechanicalLicenseId string, at time.Time) (Data, []Data, error) {
+	tx, err := QueryAndValidateModel(cli, mechanicalLicenseId, "mechanical_license")
 	if err != nil {
 		return nil, nil, err
 	}
 	mechanicalLicense := bigchain.GetTxData(tx)
+	if at.IsZero() {
+		_, _, err = validWindow(mechanicalLicense)
+	} else {
+		err = checkValidAt(mechanicalLicense, at)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 	senderPub := bigchain.DefaultGetTxSender(tx)
 	senderId := spec.GetSenderId(mechanicalLicense)
-	tx, err = bigchain.GetTx(senderId)
+	tx, err = cli.GetTx(senderId)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -567,26 +829,29 @@ func ValidateMechanicalLicense(mechanicalLicenseId string) (Data, []Data, error)
 	compositionIds := spec.GetCompositionIds(mechanicalLicense)
 	seen := make(map[string]struct{})
 	if n := len(compositionIds); n > 0 {
-		compositions = make([]Data, n)
-		for i, compositionId := range compositionIds {
+		for _, compositionId := range compositionIds {
 			if _, ok := seen[compositionId]; ok {
 				return nil, nil, ErrorAppend(ErrCriteriaNotMet, "cannot license composition multiple times")
 			}
-			composition, err := ValidateComposition(compositionId)
-			if err != nil {
-				return nil, nil, err
-			}
+			seen[compositionId] = struct{}{}
+		}
+		compositions = make([]Data, n)
+		if err = validateEach(cli, "compositions", n, func(i int) (err error) {
+			compositions[i], err = ValidateComposition(cli, compositionIds[i])
+			return
+		}); err != nil {
+			return nil, nil, err
+		}
+		for i, composition := range compositions {
 			if senderId != spec.GetComposerId(composition) {
 				return nil, nil, ErrorAppend(ErrCriteriaNotMet, "cannot license composition by another composer")
 			}
-			seen[compositionId] = struct{}{}
-			composition.Set("id", compositionId)
-			compositions[i] = composition
+			composition.Set("id", compositionIds[i])
 		}
 	}
 	publicationId := spec.GetPublicationId(mechanicalLicense)
 	if !EmptyStr(publicationId) {
-		_, moreCompositions, compositionRights, err := ValidatePublication(publicationId)
+		_, moreCompositions, compositionRights, err := ValidatePublication(cli, publicationId)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -594,7 +859,7 @@ func ValidateMechanicalLicense(mechanicalLicenseId string) (Data, []Data, error)
 		compositionRightTransferHolder := false
 		if EmptyStr(compositionRightId) {
 			compositionRightTransferId := spec.GetCompositionRightTransferId(mechanicalLicense)
-			compositionRightTransfer, err := ValidateCompositionRightTransfer(compositionRightTransferId)
+			compositionRightTransfer, err := ValidateCompositionRightTransfer(cli, compositionRightTransferId)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -628,6 +893,9 @@ func ValidateMechanicalLicense(mechanicalLicenseId string) (Data, []Data, error)
 		if compositionRight == nil {
 			return nil, nil, ErrorAppend(ErrCriteriaNotMet, "could not find composition right")
 		}
+		if err = checkValidWithin(mechanicalLicense, compositionRight); err != nil {
+			return nil, nil, err
+		}
 		licenseTerritory := spec.GetTerritory(mechanicalLicense)
 		rightTerritory := spec.GetTerritory(compositionRight)
 	OUTER:
@@ -653,19 +921,19 @@ func ValidateMechanicalLicense(mechanicalLicenseId string) (Data, []Data, error)
 		return nil, nil, ErrorAppend(ErrCriteriaNotMet, "empty mechanical license; no compositions")
 	}
 	recipientId := spec.GetRecipientId(mechanicalLicense)
-	if _, err = QueryAndValidateModel(recipientId, "party"); err != nil {
+	if _, err = QueryAndValidateModel(cli, recipientId, "party"); err != nil {
 		return nil, nil, err
 	}
 	return mechanicalLicense, compositions, nil
 }
 
-func ProveMechanicalLicenseHolder(challenge, mechanicalLicenseId string, priv crypto.PrivateKey) (crypto.Signature, error) {
-	mechanicalLicense, _, err := ValidateMechanicalLicense(mechanicalLicenseId)
+func ProveMechanicalLicenseHolder(cli *bigchain.Client, challenge, mechanicalLicenseId string, priv crypto.PrivateKey) (Data, error) {
+	mechanicalLicense, _, err := ValidateMechanicalLicense(cli, mechanicalLicenseId)
 	if err != nil {
 		return nil, err
 	}
 	recipientId := spec.GetRecipientId(mechanicalLicense)
-	tx, err := bigchain.GetTx(recipientId)
+	tx, err := cli.GetTx(recipientId)
 	if err != nil {
 		return nil, err
 	}
@@ -673,43 +941,35 @@ func ProveMechanicalLicenseHolder(challenge, mechanicalLicenseId string, priv cr
 	if pub := priv.Public(); !recipientPub.Equals(pub) {
 		return nil, ErrorAppend(ErrInvalidKey, pub.String())
 	}
-	hash, err := DefaultBalloonHash(challenge)
-	if err != nil {
-		return nil, err
-	}
-	return priv.Sign(hash), nil
+	return SignProof(NewProof(challenge, ROLE_MECHANICAL_LICENSE_HOLDER, mechanicalLicenseId), priv)
 }
 
-func VerifyMechanicalLicenseHolder(challenge, mechanicalLicenseId string, sig crypto.Signature) error {
-	mechanicalLicense, _, err := ValidateMechanicalLicense(mechanicalLicenseId)
-	if err != nil {
+func VerifyMechanicalLicenseHolder(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_MECHANICAL_LICENSE_HOLDER); err != nil {
 		return err
 	}
-	recipientId := spec.GetRecipientId(mechanicalLicense)
-	tx, err := bigchain.GetTx(recipientId)
+	mechanicalLicenseId := GetProofModelId(proof)
+	mechanicalLicense, _, err := ValidateMechanicalLicense(cli, mechanicalLicenseId)
 	if err != nil {
 		return err
 	}
-	recipientPub := bigchain.DefaultGetTxSender(tx)
-	hash, err := DefaultBalloonHash(challenge)
+	recipientId := spec.GetRecipientId(mechanicalLicense)
+	tx, err := cli.GetTx(recipientId)
 	if err != nil {
 		return err
 	}
-	if !recipientPub.Verify(hash, sig) {
-		return ErrorAppend(ErrInvalidSignature, sig.String())
-	}
-	return nil
+	return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 }
 
-func ValidateRecording(recordingId string) (Data, error) {
-	tx, err := QueryAndValidateModel(recordingId, "recording")
+func ValidateRecording(cli *bigchain.Client, recordingId string) (Data, error) {
+	tx, err := QueryAndValidateModel(cli, recordingId, "recording")
 	if err != nil {
 		return nil, err
 	}
 	recording := bigchain.GetTxData(tx)
 	senderPub := bigchain.DefaultGetTxSender(tx)
 	performerId := spec.GetPerformerId(recording)
-	tx, err = QueryAndValidateModel(performerId, "party")
+	tx, err = QueryAndValidateModel(cli, performerId, "party")
 	if err != nil {
 		return nil, err
 	}
@@ -717,7 +977,7 @@ func ValidateRecording(recordingId string) (Data, error) {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "performer is not recording sender")
 	}
 	compositionId := spec.GetRecordingOfId(recording)
-	composition, err := ValidateComposition(compositionId)
+	composition, err := ValidateComposition(cli, compositionId)
 	if err != nil {
 		return nil, err
 	}
@@ -728,7 +988,7 @@ func ValidateRecording(recordingId string) (Data, error) {
 	compositionRightId := spec.GetCompositionRightId(recording)
 	if !EmptyStr(compositionRightId) {
 		publicationId := spec.GetPublicationId(recording)
-		_, compositions, compositionRights, err := ValidatePublication(publicationId)
+		_, compositions, compositionRights, err := ValidatePublication(cli, publicationId)
 		if err != nil {
 			return nil, err
 		}
@@ -757,7 +1017,8 @@ func ValidateRecording(recordingId string) (Data, error) {
 		}
 	}
 	mechanicalLicenseId := spec.GetMechanicalLicenseId(recording)
-	mechanicalLicense, compositions, err := ValidateMechanicalLicense(mechanicalLicenseId)
+	// A recording made under a license stays valid once it expires
+	mechanicalLicense, compositions, err := validateMechanicalLicense(cli, mechanicalLicenseId, time.Time{})
 	if err != nil {
 		return nil, err
 	}
@@ -772,13 +1033,13 @@ func ValidateRecording(recordingId string) (Data, error) {
 	return nil, ErrorAppend(ErrCriteriaNotMet, "mechanical license does not cover composition")
 }
 
-func ProvePerformer(challenge string, priv crypto.PrivateKey, recordingId string) (crypto.Signature, error) {
-	recording, err := ValidateRecording(recordingId)
+func ProvePerformer(cli *bigchain.Client, challenge string, priv crypto.PrivateKey, recordingId string) (Data, error) {
+	recording, err := ValidateRecording(cli, recordingId)
 	if err != nil {
 		return nil, err
 	}
 	performerId := spec.GetPerformerId(recording)
-	tx, err := bigchain.GetTx(performerId)
+	tx, err := cli.GetTx(performerId)
 	if err != nil {
 		return nil, err
 	}
@@ -786,88 +1047,83 @@ func ProvePerformer(challenge string, priv crypto.PrivateKey, recordingId string
 	if pub := priv.Public(); !senderPub.Equals(pub) {
 		return nil, ErrorAppend(ErrInvalidKey, pub.String())
 	}
-	hash, err := DefaultBalloonHash(challenge)
-	if err != nil {
-		return nil, err
-	}
-	return priv.Sign(hash), nil
+	return SignProof(NewProof(challenge, ROLE_PERFORMER, recordingId), priv)
 }
 
-func VerifyPerformer(challenge, recordingId string, sig crypto.Signature) error {
-	recording, err := ValidateRecording(recordingId)
-	if err != nil {
+func VerifyPerformer(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_PERFORMER); err != nil {
 		return err
 	}
-	performerId := spec.GetPerformerId(recording)
-	tx, err := bigchain.GetTx(performerId)
+	recordingId := GetProofModelId(proof)
+	recording, err := ValidateRecording(cli, recordingId)
 	if err != nil {
 		return err
 	}
-	hash, err := DefaultBalloonHash(challenge)
+	performerId := spec.GetPerformerId(recording)
+	tx, err := cli.GetTx(performerId)
 	if err != nil {
 		return err
 	}
-	senderPub := bigchain.DefaultGetTxSender(tx)
-	if !senderPub.Verify(hash, sig) {
-		return ErrorAppend(ErrInvalidSignature, sig.String())
-	}
-	return nil
+	return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 }
 
-func GetComposition(data Data) (Data, error) {
+func GetComposition(cli *bigchain.Client, data Data) (Data, error) {
 	compositionId := spec.GetRecordingOfId(data)
-	tx, err := bigchain.GetTx(compositionId)
+	tx, err := cli.GetTx(compositionId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func GetMechanicalLicense(data Data) (Data, error) {
+func GetMechanicalLicense(cli *bigchain.Client, data Data) (Data, error) {
 	mechanicalLicenseId := spec.GetMechanicalLicenseId(data)
-	tx, err := bigchain.GetTx(mechanicalLicenseId)
+	tx, err := cli.GetTx(mechanicalLicenseId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func GetPerformer(data Data) (Data, error) {
+func GetPerformer(cli *bigchain.Client, data Data) (Data, error) {
 	performerId := spec.GetPerformerId(data)
-	tx, err := bigchain.GetTx(performerId)
+	tx, err := cli.GetTx(performerId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func QueryRecordingField(field, recordingId string) (interface{}, error) {
-	recording, err := ValidateRecording(recordingId)
+func QueryRecordingField(cli *bigchain.Client, field, recordingId string) (interface{}, error) {
+	recording, err := ValidateRecording(cli, recordingId)
 	if err != nil {
 		return nil, err
 	}
 	switch field {
 	case "composition":
-		return GetComposition(recording)
+		return GetComposition(cli, recording)
 	case "composition_right":
-		return GetCompositionRight(recording)
+		return GetCompositionRight(cli, recording)
 	case "mechanical_license":
-		return GetMechanicalLicense(recording)
+		return GetMechanicalLicense(cli, recording)
 	case "performer":
-		return GetPerformer(recording)
+		return GetPerformer(cli, recording)
 	}
 	return nil, ErrorAppend(ErrInvalidField, field)
 }
 
-func ProveRecordingRightHolder(challenge string, priv crypto.PrivateKey, recordingRightId, releaseId string) (crypto.Signature, error) {
-	_, _, recordingRights, err := ValidateRelease(releaseId)
+func ProveRecordingRightHolder(cli *bigchain.Client, challenge string, priv crypto.PrivateKey, recordingRightId, releaseId string) (Data, error) {
+	_, _, recordingRights, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return nil, err
 	}
 	for _, recordingRight := range recordingRights {
 		if recordingRightId == spec.GetId(recordingRight) {
+			if err = checkValidAt(recordingRight, Now()); err != nil {
+				return nil, err
+			}
 			recipientId := spec.GetRecipientId(recordingRight)
-			tx, err := bigchain.GetTx(recipientId)
+			tx, err := cli.GetTx(recipientId)
 			if err != nil {
 				return nil, err
 			}
@@ -875,44 +1131,42 @@ func ProveRecordingRightHolder(challenge string, priv crypto.PrivateKey, recordi
 			if pub := priv.Public(); !recipientPub.Equals(pub) {
 				return nil, ErrorAppend(ErrInvalidKey, pub.String())
 			}
-			hash, err := DefaultBalloonHash(challenge)
-			if err != nil {
-				return nil, err
-			}
-			return priv.Sign(hash), nil
+			proof := NewProof(challenge, ROLE_RECORDING_RIGHT_HOLDER, recordingRightId)
+			proof.Set("releaseId", releaseId)
+			return SignProof(proof, priv)
 		}
 	}
 	return nil, ErrorAppend(ErrCriteriaNotMet, "release does not link to recording right")
 }
 
-func VerifyRecordingRightHolder(challenge, recordingRightId, releaseId string, sig crypto.Signature) error {
-	_, _, recordingRights, err := ValidateRelease(releaseId)
+func VerifyRecordingRightHolder(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_RECORDING_RIGHT_HOLDER); err != nil {
+		return err
+	}
+	recordingRightId := GetProofModelId(proof)
+	releaseId := proof.GetStr("releaseId")
+	_, _, recordingRights, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return err
 	}
 	for _, recordingRight := range recordingRights {
 		if recordingRightId == spec.GetId(recordingRight) {
-			recipientId := spec.GetRecipientId(recordingRight)
-			tx, err := bigchain.GetTx(recipientId)
-			if err != nil {
+			if err = checkValidAt(recordingRight, Now()); err != nil {
 				return err
 			}
-			hash, err := DefaultBalloonHash(challenge)
+			recipientId := spec.GetRecipientId(recordingRight)
+			tx, err := cli.GetTx(recipientId)
 			if err != nil {
 				return err
 			}
-			recipientPub := bigchain.DefaultGetTxSender(tx)
-			if !recipientPub.Verify(hash, sig) {
-				return ErrorAppend(ErrInvalidSignature, sig.String())
-			}
-			return nil
+			return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 		}
 	}
 	return ErrorAppend(ErrCriteriaNotMet, "release does not link to recording right")
 }
 
-func ValidateRelease(releaseId string) (Data, []Data, []Data, error) {
-	tx, err := QueryAndValidateModel(releaseId, "release")
+func ValidateRelease(cli *bigchain.Client, releaseId string) (Data, []Data, []Data, error) {
+	tx, err := QueryAndValidateModel(cli, releaseId, "release")
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -921,21 +1175,22 @@ func ValidateRelease(releaseId string) (Data, []Data, []Data, error) {
 	senderPub := bigchain.DefaultGetTxSender(tx)
 	recordingIds := spec.GetRecordingIds(release)
 	recordings := make([]Data, len(recordingIds))
-	for i, recordingId := range recordingIds {
-		recording, err := ValidateRecording(recordingId)
-		if err != nil {
-			return nil, nil, nil, err
-		}
+	if err = validateEach(cli, "recordings", len(recordingIds), func(i int) (err error) {
+		recordings[i], err = ValidateRecording(cli, recordingIds[i])
+		return
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+	for i, recording := range recordings {
 		if i == 0 {
 			performerId = spec.GetPerformerId(recording)
 			// TODO: check performerId
 		} else if performerId != spec.GetPerformerId(recording) {
 			return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "release cannot link to recording with different performers")
 		}
-		recording.Set("id", recordingId)
-		recordings[i] = recording
+		recording.Set("id", recordingIds[i])
 	}
-	tx, err = QueryAndValidateModel(performerId, "party")
+	tx, err = QueryAndValidateModel(cli, performerId, "party")
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -945,14 +1200,18 @@ func ValidateRelease(releaseId string) (Data, []Data, []Data, error) {
 	recipientIds := make(map[string]struct{})
 	recordingRightIds := spec.GetRecordingRightIds(release)
 	recordingRights := make([]Data, len(recordingRightIds))
+	recipientPubs := make([]crypto.PublicKey, len(recordingRightIds))
+	if err = validateEach(cli, "recordingRights", len(recordingRightIds), func(i int) (err error) {
+		recordingRights[i], recipientPubs[i], _, err = validateRight(cli, recordingRightIds[i], time.Time{})
+		return
+	}); err != nil {
+		return nil, nil, nil, err
+	}
 	recordLabelId := spec.GetRecordLabelId(release)
 	rightHolder := false
 	totalShares := 0
-	for i, rightId := range recordingRightIds {
-		recordingRight, recipientPub, _, err := ValidateRight(rightId)
-		if err != nil {
-			return nil, nil, nil, err
-		}
+	for i, recordingRight := range recordingRights {
+		recipientPub := recipientPubs[i]
 		if performerId != spec.GetSenderId(recordingRight) {
 			return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "performer must be right sender")
 		}
@@ -962,7 +1221,7 @@ func ValidateRelease(releaseId string) (Data, []Data, []Data, error) {
 		}
 		if !EmptyStr(recordLabelId) {
 			if !rightHolder && recipientId == recordLabelId {
-				tx, err = bigchain.GetTx(recordLabelId)
+				tx, err = cli.GetTx(recordLabelId)
 				if err != nil {
 					return nil, nil, nil, err
 				}
@@ -980,8 +1239,7 @@ func ValidateRelease(releaseId string) (Data, []Data, []Data, error) {
 		if totalShares += shares; totalShares > 100 {
 			return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "total percentage shares cannot exceed 100")
 		}
-		recordingRight.Set("id", rightId)
-		recordingRights[i] = recordingRight
+		recordingRight.Set("id", recordingRightIds[i])
 	}
 	if !EmptyStr(recordLabelId) && !rightHolder {
 		return nil, nil, nil, ErrorAppend(ErrCriteriaNotMet, "record label must be right-holder")
@@ -992,13 +1250,13 @@ func ValidateRelease(releaseId string) (Data, []Data, []Data, error) {
 	return release, recordings, recordingRights, nil
 }
 
-func ProveRecordLabel(challenge string, priv crypto.PrivateKey, releaseId string) (crypto.Signature, error) {
-	release, _, _, err := ValidateRelease(releaseId)
+func ProveRecordLabel(cli *bigchain.Client, challenge string, priv crypto.PrivateKey, releaseId string) (Data, error) {
+	release, _, _, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return nil, err
 	}
 	recordLabelId := spec.GetRecordLabelId(release)
-	tx, err := bigchain.GetTx(recordLabelId)
+	tx, err := cli.GetTx(recordLabelId)
 	if err != nil {
 		return nil, err
 	}
@@ -1006,45 +1264,37 @@ func ProveRecordLabel(challenge string, priv crypto.PrivateKey, releaseId string
 	if pub := priv.Public(); !senderPub.Equals(pub) {
 		return nil, ErrorAppend(ErrInvalidKey, pub.String())
 	}
-	hash, err := DefaultBalloonHash(challenge)
-	if err != nil {
-		return nil, err
-	}
-	return priv.Sign(hash), nil
+	return SignProof(NewProof(challenge, ROLE_RECORD_LABEL, releaseId), priv)
 }
 
-func VerifyRecordLabel(challenge, releaseId string, sig crypto.Signature) error {
-	release, _, _, err := ValidateRelease(releaseId)
-	if err != nil {
+func VerifyRecordLabel(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_RECORD_LABEL); err != nil {
 		return err
 	}
-	recordLabelId := spec.GetRecordLabelId(release)
-	tx, err := bigchain.GetTx(recordLabelId)
+	releaseId := GetProofModelId(proof)
+	release, _, _, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return err
 	}
-	hash, err := DefaultBalloonHash(challenge)
+	recordLabelId := spec.GetRecordLabelId(release)
+	tx, err := cli.GetTx(recordLabelId)
 	if err != nil {
 		return err
 	}
-	senderPub := bigchain.DefaultGetTxSender(tx)
-	if !senderPub.Verify(hash, sig) {
-		return ErrorAppend(ErrInvalidSignature, sig.String())
-	}
-	return nil
+	return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 }
 
-func GetRecordLabel(data Data) (Data, error) {
+func GetRecordLabel(cli *bigchain.Client, data Data) (Data, error) {
 	recordLabelId := spec.GetRecordLabelId(data)
-	tx, err := bigchain.GetTx(recordLabelId)
+	tx, err := cli.GetTx(recordLabelId)
 	if err != nil {
 		return nil, err
 	}
 	return bigchain.GetTxData(tx), nil
 }
 
-func QueryReleaseField(field, releaseId string) (interface{}, error) {
-	release, recordings, recordingRights, err := ValidateRelease(releaseId)
+func QueryReleaseField(cli *bigchain.Client, field, releaseId string) (interface{}, error) {
+	release, recordings, recordingRights, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return nil, err
 	}
@@ -1054,20 +1304,20 @@ func QueryReleaseField(field, releaseId string) (interface{}, error) {
 	case "recording_rights":
 		return recordingRights, nil
 	case "record_label":
-		return GetRecordLabel(release)
+		return GetRecordLabel(cli, release)
 	}
 	return nil, ErrorAppend(ErrInvalidField, field)
 }
 
-func ValidateRecordingRightTransfer(recordingRightTransferId string) (Data, error) {
-	tx, err := QueryAndValidateModel(recordingRightTransferId, "recording_right_transfer")
+func ValidateRecordingRightTransfer(cli *bigchain.Client, recordingRightTransferId string) (Data, error) {
+	tx, err := QueryAndValidateModel(cli, recordingRightTransferId, "recording_right_transfer")
 	if err != nil {
 		return nil, err
 	}
 	recordingRightTransfer := bigchain.GetTxData(tx)
 	senderPub := bigchain.DefaultGetTxSender(tx)
 	recipientId := spec.GetRecipientId(recordingRightTransfer)
-	tx, err = QueryAndValidateModel(recipientId, "party")
+	tx, err = QueryAndValidateModel(cli, recipientId, "party")
 	if err != nil {
 		return nil, err
 	}
@@ -1076,7 +1326,7 @@ func ValidateRecordingRightTransfer(recordingRightTransferId string) (Data, erro
 		return nil, ErrorAppend(ErrCriteriaNotMet, "recipient and sender keys must be different")
 	}
 	senderId := spec.GetSenderId(recordingRightTransfer)
-	tx, err = QueryAndValidateModel(senderId, "party")
+	tx, err = QueryAndValidateModel(cli, senderId, "party")
 	if err != nil {
 		return nil, err
 	}
@@ -1084,29 +1334,33 @@ func ValidateRecordingRightTransfer(recordingRightTransferId string) (Data, erro
 		return nil, ErrorAppend(ErrInvalidKey, senderPub.String())
 	}
 	releaseId := spec.GetReleaseId(recordingRightTransfer)
-	_, _, recordingRights, err := ValidateRelease(releaseId)
+	_, _, recordingRights, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return nil, err
 	}
 	txId := spec.GetTxId(recordingRightTransfer)
-	tx, err = bigchain.GetTx(txId)
+	tx, err = cli.GetTx(txId)
 	if err != nil {
 		return nil, err
 	}
 	if bigchain.TRANSFER != bigchain.GetTxOperation(tx) {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "expected TRANSFER tx")
 	}
-	if !senderPub.Equals(bigchain.DefaultGetTxSender(tx)) {
-		return nil, ErrorAppend(ErrCriteriaNotMet, "sender is not signer of TRANSFER tx")
+	// A consolidated transfer has several inputs, all signed by sender
+	for _, pubs := range bigchain.GetTxSenders(tx) {
+		if len(pubs) != 1 || !senderPub.Equals(pubs[0]) {
+			return nil, ErrorAppend(ErrCriteriaNotMet, "sender is not signer of TRANSFER tx")
+		}
 	}
 	n := len(bigchain.GetTxOutputs(tx))
 	if n != 1 && n != 2 {
 		return nil, ErrorAppend(ErrInvalidSize, "tx outputs must have size 1 or 2")
 	}
-	if !recipientPub.Equals(bigchain.GetTxRecipient(tx, 1)) {
+	// Recipient holds the only output or the secondary one
+	if !recipientPub.Equals(bigchain.GetTxRecipient(tx, n-1)) {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "recipient does not hold secondary output of TRANSFER tx")
 	}
-	recipientShares := bigchain.GetTxOutputAmount(tx, 1)
+	recipientShares := bigchain.GetTxOutputAmount(tx, n-1)
 	if recipientShares <= 0 || recipientShares > 100 {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "recipient shares must be greater than 0 and less than/equal to 100")
 	}
@@ -1138,8 +1392,8 @@ func ValidateRecordingRightTransfer(recordingRightTransferId string) (Data, erro
 	return recordingRightTransfer, nil
 }
 
-func ProveRecordingRightTransferHolder(challenge, holderId string, priv crypto.PrivateKey, recordingRightTransferId, releaseId string) (crypto.Signature, error) {
-	recordingRightTransfer, err := ValidateRecordingRightTransfer(recordingRightTransferId)
+func ProveRecordingRightTransferHolder(cli *bigchain.Client, challenge, holderId string, priv crypto.PrivateKey, recordingRightTransferId, releaseId string) (Data, error) {
+	recordingRightTransfer, err := ValidateRecordingRightTransfer(cli, recordingRightTransferId)
 	if err != nil {
 		return nil, err
 	}
@@ -1153,13 +1407,13 @@ func ProveRecordingRightTransferHolder(challenge, holderId string, priv crypto.P
 	} else {
 		return nil, ErrorAppend(ErrCriteriaNotMet, "holder is not recipient or sender of transfer")
 	}
-	_, _, recordingRights, err := ValidateRelease(releaseId)
+	_, _, recordingRights, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return nil, err
 	}
 	for _, recordingRight := range recordingRights {
 		if recordingRightId == spec.GetId(recordingRight) {
-			tx, err := bigchain.GetTx(holderId)
+			tx, err := cli.GetTx(holderId)
 			if err != nil {
 				return nil, err
 			}
@@ -1167,18 +1421,23 @@ func ProveRecordingRightTransferHolder(challenge, holderId string, priv crypto.P
 			if pub := priv.Public(); !holderPub.Equals(pub) {
 				return nil, ErrorAppend(ErrInvalidKey, pub.String())
 			}
-			hash, err := DefaultBalloonHash(challenge)
-			if err != nil {
-				return nil, err
-			}
-			return priv.Sign(hash), nil
+			proof := NewProof(challenge, ROLE_RECORDING_RIGHT_TRANSFER_HOLDER, recordingRightTransferId)
+			proof.Set("holderId", holderId)
+			proof.Set("releaseId", releaseId)
+			return SignProof(proof, priv)
 		}
 	}
 	return nil, ErrorAppend(ErrCriteriaNotMet, "release does not link to underlying recording right")
 }
 
-func VerifyRecordingRightTransferHolder(challenge, holderId, recordingRightTransferId, releaseId string, sig crypto.Signature) error {
-	recordingRightTransfer, err := ValidateRecordingRightTransfer(recordingRightTransferId)
+func VerifyRecordingRightTransferHolder(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_RECORDING_RIGHT_TRANSFER_HOLDER); err != nil {
+		return err
+	}
+	recordingRightTransferId := GetProofModelId(proof)
+	holderId := proof.GetStr("holderId")
+	releaseId := proof.GetStr("releaseId")
+	recordingRightTransfer, err := ValidateRecordingRightTransfer(cli, recordingRightTransferId)
 	if err != nil {
 		return err
 	}
@@ -1192,55 +1451,71 @@ func VerifyRecordingRightTransferHolder(challenge, holderId, recordingRightTrans
 	} else {
 		return ErrorAppend(ErrCriteriaNotMet, "holder is not recipient or sender of transfer")
 	}
-	_, _, recordingRights, err := ValidateRelease(releaseId)
+	_, _, recordingRights, err := ValidateRelease(cli, releaseId)
 	if err != nil {
 		return err
 	}
 	for _, recordingRight := range recordingRights {
 		if recordingRightId == spec.GetId(recordingRight) {
-			tx, err := bigchain.GetTx(holderId)
-			if err != nil {
-				return err
-			}
-			hash, err := DefaultBalloonHash(challenge)
+			tx, err := cli.GetTx(holderId)
 			if err != nil {
 				return err
 			}
-			holderPub := bigchain.DefaultGetTxSender(tx)
-			if !holderPub.Verify(hash, sig) {
-				return ErrorAppend(ErrInvalidSignature, sig.String())
-			}
-			return nil
+			return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 		}
 	}
 	return ErrorAppend(ErrCriteriaNotMet, "release does not link to underlying recording right")
 }
 
-func QueryMasterLicenseField(field, masterLicenseId string) (interface{}, error) {
-	masterLicense, recordings, err := ValidateMasterLicense(masterLicenseId)
+func QueryMasterLicenseField(cli *bigchain.Client, field, masterLicenseId string) (interface{}, error) {
+	masterLicense, recordings, err := ValidateMasterLicense(cli, masterLicenseId)
 	if err != nil {
 		return nil, err
 	}
 	switch field {
 	case "recipient":
-		return GetRecipient(masterLicense)
+		return GetRecipient(cli, masterLicense)
 	case "recordings":
 		return recordings, nil
 	case "sender":
-		return GetSender(masterLicense)
+		return GetSender(cli, masterLicense)
 	}
 	return nil, ErrorAppend(ErrInvalidField, field)
 }
 
-func ValidateMasterLicense(masterLicenseId string) (Data, []Data, error) {
-	tx, err := QueryAndValidateModel(masterLicenseId, "master_license")
+func ValidateMasterLicense(cli *bigchain.Client, masterLicenseId string) (Data, []Data, error) {
+	return ValidateMasterLicenseAt(cli, masterLicenseId, Now())
+}
+
+// The license must be in force at the given time, now if zero,
+// and its window must fall within that of the right it was issued under
+
+func ValidateMasterLicenseAt(cli *bigchain.Client, masterLicenseId string, at time.Time) (Data, []Data, error) {
+	if at.IsZero() {
+		at = Now()
+	}
+	return validateMasterLicense(cli, masterLicenseId, at)
+}
+
+// The window is only checked against a non-zero time
+
+func validateMasterLicense(cli *bigchain.Client, masterLicenseId string, at time.Time) (Data, []Data, error) {
+	tx, err := QueryAndValidateModel(cli, masterLicenseId, "master_license")
 	if err != nil {
 		return nil, nil, err
 	}
 	masterLicense := bigchain.GetTxData(tx)
+	if at.IsZero() {
+		_, _, err = validWindow(masterLicense)
+	} else {
+		err = checkValidAt(masterLicense, at)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 	senderPub := bigchain.DefaultGetTxSender(tx)
 	senderId := spec.GetSenderId(masterLicense)
-	tx, err = QueryAndValidateModel(senderId, "party")
+	tx, err = QueryAndValidateModel(cli, senderId, "party")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1251,26 +1526,29 @@ func ValidateMasterLicense(masterLicenseId string) (Data, []Data, error) {
 	recordingIds := spec.GetRecordingIds(masterLicense)
 	seen := make(map[string]struct{})
 	if n := len(recordingIds); n > 0 {
-		recordings = make([]Data, n)
-		for i, recordingId := range recordingIds {
+		for _, recordingId := range recordingIds {
 			if _, ok := seen[recordingId]; ok {
 				return nil, nil, ErrorAppend(ErrCriteriaNotMet, "cannot license recording multiple times")
 			}
-			recording, err := ValidateRecording(recordingId)
-			if err != nil {
-				return nil, nil, err
-			}
+			seen[recordingId] = struct{}{}
+		}
+		recordings = make([]Data, n)
+		if err = validateEach(cli, "recordings", n, func(i int) (err error) {
+			recordings[i], err = ValidateRecording(cli, recordingIds[i])
+			return
+		}); err != nil {
+			return nil, nil, err
+		}
+		for i, recording := range recordings {
 			if senderId != spec.GetPerformerId(recording) {
 				return nil, nil, ErrorAppend(ErrCriteriaNotMet, "cannot license recording by another performer")
 			}
-			seen[recordingId] = struct{}{}
-			spec.SetId(recording, recordingId)
-			recordings[i] = recording
+			spec.SetId(recording, recordingIds[i])
 		}
 	}
 	releaseId := spec.GetReleaseId(masterLicense)
 	if !EmptyStr(releaseId) {
-		_, moreRecordings, recordingRights, err := ValidateRelease(releaseId)
+		_, moreRecordings, recordingRights, err := ValidateRelease(cli, releaseId)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -1278,7 +1556,7 @@ func ValidateMasterLicense(masterLicenseId string) (Data, []Data, error) {
 		recordingRightTransferHolder := false
 		if EmptyStr(recordingRightId) {
 			recordingRightTransferId := spec.GetRecordingRightTransferId(masterLicense)
-			recordingRightTransfer, err := ValidateRecordingRightTransfer(recordingRightTransferId)
+			recordingRightTransfer, err := ValidateRecordingRightTransfer(cli, recordingRightTransferId)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -1312,6 +1590,9 @@ func ValidateMasterLicense(masterLicenseId string) (Data, []Data, error) {
 		if recordingRight == nil {
 			return nil, nil, ErrorAppend(ErrCriteriaNotMet, "could not find recording right")
 		}
+		if err = checkValidWithin(masterLicense, recordingRight); err != nil {
+			return nil, nil, err
+		}
 		licenseTerritory := spec.GetTerritory(masterLicense)
 		rightTerritory := spec.GetTerritory(recordingRight)
 	OUTER:
@@ -1337,20 +1618,20 @@ func ValidateMasterLicense(masterLicenseId string) (Data, []Data, error) {
 		return nil, nil, ErrorAppend(ErrCriteriaNotMet, "empty master license; no recordings")
 	}
 	recipientId := spec.GetRecipientId(masterLicense)
-	tx, err = QueryAndValidateModel(recipientId, "party")
+	tx, err = QueryAndValidateModel(cli, recipientId, "party")
 	if err != nil {
 		return nil, nil, err
 	}
 	return masterLicense, recordings, nil
 }
 
-func ProveMasterLicenseHolder(challenge, masterLicenseId string, priv crypto.PrivateKey) (crypto.Signature, error) {
-	masterLicense, _, err := ValidateMasterLicense(masterLicenseId)
+func ProveMasterLicenseHolder(cli *bigchain.Client, challenge, masterLicenseId string, priv crypto.PrivateKey) (Data, error) {
+	masterLicense, _, err := ValidateMasterLicense(cli, masterLicenseId)
 	if err != nil {
 		return nil, err
 	}
 	recipientId := spec.GetRecipientId(masterLicense)
-	tx, err := bigchain.GetTx(recipientId)
+	tx, err := cli.GetTx(recipientId)
 	if err != nil {
 		return nil, err
 	}
@@ -1358,30 +1639,22 @@ func ProveMasterLicenseHolder(challenge, masterLicenseId string, priv crypto.Pri
 	if pub := priv.Public(); !recipientPub.Equals(pub) {
 		return nil, ErrorAppend(ErrInvalidKey, pub.String())
 	}
-	hash, err := DefaultBalloonHash(challenge)
-	if err != nil {
-		return nil, err
-	}
-	return priv.Sign(hash), nil
+	return SignProof(NewProof(challenge, ROLE_MASTER_LICENSE_HOLDER, masterLicenseId), priv)
 }
 
-func VerifyMasterLicenseHolder(challenge, masterLicenseId string, sig crypto.Signature) error {
-	masterLicense, _, err := ValidateMasterLicense(masterLicenseId)
-	if err != nil {
+func VerifyMasterLicenseHolder(cli *bigchain.Client, proof Data) error {
+	if err := checkProofRole(proof, ROLE_MASTER_LICENSE_HOLDER); err != nil {
 		return err
 	}
-	recipientId := spec.GetRecipientId(masterLicense)
-	tx, err := bigchain.GetTx(recipientId)
+	masterLicenseId := GetProofModelId(proof)
+	masterLicense, _, err := ValidateMasterLicense(cli, masterLicenseId)
 	if err != nil {
 		return err
 	}
-	hash, err := DefaultBalloonHash(challenge)
+	recipientId := spec.GetRecipientId(masterLicense)
+	tx, err := cli.GetTx(recipientId)
 	if err != nil {
 		return err
 	}
-	recipientPub := bigchain.DefaultGetTxSender(tx)
-	if !recipientPub.Verify(hash, sig) {
-		return ErrorAppend(ErrInvalidSignature, sig.String())
-	}
-	return nil
+	return verifyProofSignature(proof, bigchain.DefaultGetTxSender(tx))
 }
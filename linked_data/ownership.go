@@ -0,0 +1,263 @@
+package linked_data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/crypto/crypto"
+	"github.com/zbo14/envoke/spec"
+)
+
+// Holders of a right at the given time (now if zero), found by
+// walking every tx of the right's asset, as
+// Data{"holders", "inconsistencies", "rightId", "totalShares"}
+//
+// Holders are Data{"partyId", "publicKey", "shares"}, largest first;
+// shares in co-owned outputs are held by Data{"partyIds", "publicKeys", "shares"}
+// TRANSFER txs with an effectiveDate after the time don't apply, nor
+// do txs that consume their outputs. Since txs aren't timestamped, a
+// TRANSFER without a valid effectiveDate applies at every time and is flagged
+//
+// Inconsistencies are Data{"id", "message"}, e.g. for a transfer model
+// whose txId doesn't match a TRANSFER of the right, or whose sender or
+// recipient don't match the TRANSFER's keys. Transfer models are found
+// with the node's text search
+
+func ResolveOwnership(cli *bigchain.Client, rightId string, at time.Time) (Data, error) {
+	if at.IsZero() {
+		at = Now()
+	}
+	right, _, _, err := validateRight(cli, rightId, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	txs, err := cli.ListTxs(rightId, "")
+	if err != nil {
+		return nil, err
+	}
+	inconsistencies := []Data{}
+	flag := func(id, message string) {
+		inconsistencies = append(inconsistencies, Data{"id": id, "message": message})
+	}
+	// Apply txs once the txs they consume have been applied,
+	// since nodes needn't list txs in order
+	applied := make(map[string]Data)
+	skipped := make(map[string]struct{})
+	unspent := make(map[string]Data)
+	pending := txs
+	for len(pending) > 0 {
+		var next []Data
+	PENDING:
+		for _, tx := range pending {
+			txId := bigchain.GetId(tx)
+			if bigchain.GetTxOperation(tx) == bigchain.TRANSFER {
+				date := spec.GetEffectiveDate(bigchain.GetTxMetadata(tx))
+				effective, err := ParseDateStr(date)
+				if !EmptyStr(date) && err == nil && effective.After(at) {
+					skipped[txId] = struct{}{}
+					continue
+				}
+				inputs := bigchain.GetTxInputs(tx)
+				for _, input := range inputs {
+					consumeId, _ := bigchain.GetInputFulfills(input)
+					if _, ok := skipped[consumeId]; ok {
+						skipped[txId] = struct{}{}
+						continue PENDING
+					}
+					if _, ok := applied[consumeId]; !ok {
+						next = append(next, tx)
+						continue PENDING
+					}
+				}
+				// Flagged once its inputs are applied, so a tx that
+				// waits for them isn't flagged on every pass
+				if EmptyStr(date) {
+					flag(txId, "no effectiveDate, applies at every time")
+				} else if err != nil {
+					flag(txId, "invalid effectiveDate "+date+", applies at every time")
+				}
+				for _, input := range inputs {
+					consumeId, n := bigchain.GetInputFulfills(input)
+					key := outputKey(consumeId, n)
+					if _, ok := unspent[key]; !ok {
+						flag(txId, "consumes spent or missing output "+key)
+					}
+					delete(unspent, key)
+				}
+			}
+			for i, output := range bigchain.GetTxOutputs(tx) {
+				unspent[outputKey(txId, i)] = output
+			}
+			applied[txId] = tx
+		}
+		if len(next) == len(pending) {
+			for _, tx := range next {
+				flag(bigchain.GetId(tx), "consumes output of unknown tx")
+			}
+			break
+		}
+		pending = next
+	}
+	// Party ids by public key, from the right and its transfer models
+	partyIds := make(map[string]string)
+	partyPubs := make(map[string]crypto.PublicKey)
+	addParty := func(partyId string) crypto.PublicKey {
+		if pub, ok := partyPubs[partyId]; ok {
+			return pub
+		}
+		tx, err := QueryAndValidateModel(cli, partyId, "party")
+		if err != nil {
+			flag(partyId, "invalid party: "+err.Error())
+			partyPubs[partyId] = nil
+			return nil
+		}
+		pub := bigchain.DefaultGetTxSender(tx)
+		partyIds[pub.String()] = partyId
+		partyPubs[partyId] = pub
+		return pub
+	}
+	addParty(spec.GetRecipientId(right))
+	addParty(spec.GetSenderId(right))
+	transfers, err := queryTransferModels(cli, rightId)
+	if err != nil {
+		return nil, err
+	}
+	claimed := make(map[string]string)
+	for _, transfer := range transfers {
+		transferId := transfer.GetStr("id")
+		model := transfer.GetData("data")
+		senderPub := addParty(spec.GetSenderId(model))
+		recipientPub := addParty(spec.GetRecipientId(model))
+		txId := spec.GetTxId(model)
+		if otherId, ok := claimed[txId]; ok {
+			flag(transferId, "txId also claimed by transfer model "+otherId)
+		}
+		claimed[txId] = transferId
+		var tx Data
+		for _, t := range txs {
+			if txId == bigchain.GetId(t) && bigchain.GetTxOperation(t) == bigchain.TRANSFER {
+				tx = t
+				break
+			}
+		}
+		if tx == nil {
+			flag(transferId, "txId does not match a TRANSFER of the right")
+			continue
+		}
+		if senderPub != nil && !txHasSender(tx, senderPub) {
+			flag(transferId, "sender does not match TRANSFER inputs")
+		}
+		if recipientPub != nil && !txHasRecipient(tx, recipientPub) {
+			flag(transferId, "recipient does not match TRANSFER outputs")
+		}
+	}
+	// Sum unspent outputs by owners, in output order so flags come
+	// out the same on every run
+	holders := make(map[string]Data)
+	totalShares := 0
+	outputKeys := make([]string, 0, len(unspent))
+	for key := range unspent {
+		outputKeys = append(outputKeys, key)
+	}
+	sort.Strings(outputKeys)
+	for _, outKey := range outputKeys {
+		output := unspent[outKey]
+		pubs := bigchain.GetOutputPublicKeys(output)
+		shares := bigchain.GetOutputAmount(output)
+		totalShares += shares
+		keys := make([]string, len(pubs))
+		for i, pub := range pubs {
+			keys[i] = pub.String()
+		}
+		sort.Strings(keys)
+		owners := Sprintf("%v", keys)
+		if holder, ok := holders[owners]; ok {
+			holder.Set("shares", holder.GetInt("shares")+shares)
+			continue
+		}
+		ids := make([]string, len(keys))
+		for i, key := range keys {
+			if ids[i] = partyIds[key]; EmptyStr(ids[i]) {
+				flag(key, "no party with public key")
+			}
+		}
+		if len(keys) == 1 {
+			holders[owners] = Data{"partyId": ids[0], "publicKey": keys[0], "shares": shares}
+		} else {
+			holders[owners] = Data{"partyIds": ids, "publicKeys": keys, "shares": shares}
+		}
+	}
+	owners := make([]string, 0, len(holders))
+	for owner := range holders {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		si, sj := holders[owners[i]].GetInt("shares"), holders[owners[j]].GetInt("shares")
+		if si != sj {
+			return si > sj
+		}
+		return owners[i] < owners[j]
+	})
+	sorted := make([]Data, len(owners))
+	for i, owner := range owners {
+		sorted[i] = holders[owner]
+	}
+	return Data{
+		"holders":         sorted,
+		"inconsistencies": inconsistencies,
+		"rightId":         rightId,
+		"totalShares":     totalShares,
+	}, nil
+}
+
+func outputKey(txId string, n int) string {
+	return Sprintf("%s:%d", txId, n)
+}
+
+// Composition and recording right transfer models that link to the right
+
+func queryTransferModels(cli *bigchain.Client, rightId string) ([]Data, error) {
+	assets, err := cli.SearchAssets(rightId, 0)
+	if err != nil {
+		return nil, err
+	}
+	var transfers []Data
+	for _, asset := range assets {
+		model := asset.GetData("data")
+		var linkedId string
+		switch GetModelType(model) {
+		case "composition_right_transfer":
+			linkedId = spec.GetCompositionRightId(model)
+		case "recording_right_transfer":
+			linkedId = spec.GetRecordingRightId(model)
+		}
+		if linkedId == rightId {
+			transfers = append(transfers, asset)
+		}
+	}
+	return transfers, nil
+}
+
+func txHasSender(tx Data, pub crypto.PublicKey) bool {
+	for _, input := range bigchain.GetTxInputs(tx) {
+		for _, owner := range bigchain.GetInputPublicKeys(input) {
+			if pub.Equals(owner) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func txHasRecipient(tx Data, pub crypto.PublicKey) bool {
+	for _, output := range bigchain.GetTxOutputs(tx) {
+		for _, owner := range bigchain.GetOutputPublicKeys(output) {
+			if pub.Equals(owner) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package linked_data
+
+import (
+	"github.com/zbo14/balloon"
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/crypto/crypto"
+	"github.com/zbo14/envoke/crypto/ed25519"
+)
+
+// Proof that the holder of a role signed a challenge, e.g. that the
+// composer of a composition signed a verifier's challenge, as
+//
+//	Data{
+//		"balloon":   Data{"delta", "sCost", "tCost"},
+//		"challenge": base64url,
+//		"modelId":   id,
+//		"role":      role,
+//		"salt":      base64url,
+//		"signature": base58,
+//	}
+//
+// plus "holderId", "publicationId" or "releaseId" for roles that need them
+// The signature is over the balloon hash of the proof's canonical JSON
+// without the signature, salted and with the parameters in the proof,
+// so any verifier can check it and none of its fields can be changed
+
+const (
+	BALLOON_DELTA  = 2
+	BALLOON_S_COST = 256
+	BALLOON_T_COST = 32
+
+	// Bounds on the parameters a verifier will compute a hash with,
+	// close to the defaults since anyone can submit a proof to verify
+	MAX_BALLOON_DELTA  = 2 * BALLOON_DELTA
+	MAX_BALLOON_S_COST = 2 * BALLOON_S_COST
+	MAX_BALLOON_T_COST = 2 * BALLOON_T_COST
+	MAX_SALT_SIZE      = 64
+
+	ROLE_COMPOSER                          = "composer"
+	ROLE_COMPOSITION_RIGHT_HOLDER          = "composition_right_holder"
+	ROLE_COMPOSITION_RIGHT_TRANSFER_HOLDER = "composition_right_transfer_holder"
+	ROLE_MASTER_LICENSE_HOLDER             = "master_license_holder"
+	ROLE_MECHANICAL_LICENSE_HOLDER         = "mechanical_license_holder"
+	ROLE_PERFORMER                         = "performer"
+	ROLE_PUBLISHER                         = "publisher"
+	ROLE_RECORD_LABEL                      = "record_label"
+	ROLE_RECORDING_RIGHT_HOLDER            = "recording_right_holder"
+	ROLE_RECORDING_RIGHT_TRANSFER_HOLDER   = "recording_right_transfer_holder"
+)
+
+// Unsigned proof with a fresh salt and the default parameters
+
+func NewProof(challenge, role, modelId string) Data {
+	return Data{
+		"balloon": Data{
+			"delta": BALLOON_DELTA,
+			"sCost": BALLOON_S_COST,
+			"tCost": BALLOON_T_COST,
+		},
+		"challenge": challenge,
+		"modelId":   modelId,
+		"role":      role,
+		"salt":      Base64UrlEncode(balloon.GenerateSalt()),
+	}
+}
+
+func GetProofChallenge(proof Data) string {
+	return proof.GetStr("challenge")
+}
+
+func GetProofModelId(proof Data) string {
+	return proof.GetStr("modelId")
+}
+
+func GetProofRole(proof Data) string {
+	return proof.GetStr("role")
+}
+
+// The hash a proof is signed over, e.g. for a client to sign
+
+func ProofHash(proof Data) ([]byte, error) {
+	if _, err := Base64UrlDecode(GetProofChallenge(proof)); err != nil {
+		return nil, ErrorAppend(ErrInvalidField, "challenge")
+	}
+	salt, err := Base64UrlDecode(proof.GetStr("salt"))
+	if err != nil || len(salt) == 0 || len(salt) > MAX_SALT_SIZE {
+		return nil, ErrorAppend(ErrInvalidField, "salt")
+	}
+	params := proof.GetData("balloon")
+	if params == nil {
+		return nil, ErrorAppend(ErrInvalidField, "balloon")
+	}
+	delta, sCost, tCost := params.GetInt("delta"), params.GetInt("sCost"), params.GetInt("tCost")
+	if delta < 1 || delta > MAX_BALLOON_DELTA || sCost < 1 || sCost > MAX_BALLOON_S_COST || tCost < 1 || tCost > MAX_BALLOON_T_COST {
+		return nil, ErrorAppend(ErrInvalidField, "balloon parameters out of range")
+	}
+	unsigned := make(Data, len(proof))
+	for k, v := range proof {
+		if k != "signature" {
+			unsigned[k] = v
+		}
+	}
+	p, err := MarshalCanonicalJSON(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return balloon.BalloonHash(p, salt, sCost, tCost, delta), nil
+}
+
+func SignProof(proof Data, priv crypto.PrivateKey) (Data, error) {
+	hash, err := ProofHash(proof)
+	if err != nil {
+		return nil, err
+	}
+	proof.Set("signature", priv.Sign(hash).String())
+	return proof, nil
+}
+
+func checkProofRole(proof Data, role string) error {
+	if GetProofRole(proof) != role {
+		return ErrorAppend(ErrInvalidType, "expected "+role+" proof")
+	}
+	return nil
+}
+
+func verifyProofSignature(proof Data, pub crypto.PublicKey) error {
+	sig := new(ed25519.Signature)
+	if err := sig.FromString(proof.GetStr("signature")); err != nil {
+		return ErrorAppend(ErrInvalidSignature, proof.GetStr("signature"))
+	}
+	hash, err := ProofHash(proof)
+	if err != nil {
+		return err
+	}
+	if !pub.Verify(hash, sig) {
+		return ErrorAppend(ErrInvalidSignature, sig.String())
+	}
+	return nil
+}
+
+// Verifies a proof of any role against the ledger
+// Callers that issued the challenge should also check GetProofChallenge
+
+func VerifyProof(cli *bigchain.Client, proof Data) error {
+	switch GetProofRole(proof) {
+	case ROLE_COMPOSER:
+		return VerifyComposer(cli, proof)
+	case ROLE_COMPOSITION_RIGHT_HOLDER:
+		return VerifyCompositionRightHolder(cli, proof)
+	case ROLE_COMPOSITION_RIGHT_TRANSFER_HOLDER:
+		return VerifyCompositionRightTransferHolder(cli, proof)
+	case ROLE_MASTER_LICENSE_HOLDER:
+		return VerifyMasterLicenseHolder(cli, proof)
+	case ROLE_MECHANICAL_LICENSE_HOLDER:
+		return VerifyMechanicalLicenseHolder(cli, proof)
+	case ROLE_PERFORMER:
+		return VerifyPerformer(cli, proof)
+	case ROLE_PUBLISHER:
+		return VerifyPublisher(cli, proof)
+	case ROLE_RECORD_LABEL:
+		return VerifyRecordLabel(cli, proof)
+	case ROLE_RECORDING_RIGHT_HOLDER:
+		return VerifyRecordingRightHolder(cli, proof)
+	case ROLE_RECORDING_RIGHT_TRANSFER_HOLDER:
+		return VerifyRecordingRightTransferHolder(cli, proof)
+	}
+	return ErrorAppend(ErrInvalidType, GetProofRole(proof))
+}
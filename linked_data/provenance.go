@@ -0,0 +1,285 @@
+package linked_data
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/spec"
+)
+
+// Provenance graph of the models reachable from a model by its links,
+// e.g. master license -> release -> recording -> composition, and by
+// the links to it, e.g. composition <- recording <- release, found with
+// the node's text search. Links to parties are only followed back from
+// the start model, since every model a party touched links to it
+// Nodes are Data{"id", "type"} plus "name" if the model has one;
+// TRANSFER txs linked from transfer models have type "transfer"
+// and link to the asset they transfer
+// Edges are Data{"field", "from", "signer", "to", "txId"}, where txId
+// is the tx that asserts the link and signer the key that signed it
+
+type Graph struct {
+	Edges    []Data
+	Endpoint string
+	Nodes    []Data
+}
+
+// Vocabulary of envoke terms in JSON-LD
+
+const VOCAB_ENVOKE = "https://github.com/zbo14/envoke/spec#"
+
+// JSON-LD classes by model type
+
+var modelClasses = map[string]string{
+	"composition":                "schema:MusicComposition",
+	"composition_right_transfer": "envoke:CompositionRightTransfer",
+	"master_license":             "envoke:MasterLicense",
+	"mechanical_license":         "envoke:MechanicalLicense",
+	"party":                      "envoke:Party",
+	"publication":                "envoke:MusicPublication",
+	"recording":                  "schema:MusicRecording",
+	"recording_right_transfer":   "envoke:RecordingRightTransfer",
+	"release":                    "schema:MusicRelease",
+	"right":                      "envoke:Right",
+	TYPE_TRANSFER:                "envoke:Transfer",
+}
+
+func BuildProvenanceGraph(cli *bigchain.Client, modelId string) (*Graph, error) {
+	graph := &Graph{Endpoint: cli.Endpoint()}
+	seen := map[string]struct{}{modelId: {}}
+	queue := []string{modelId}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		tx, err := cli.GetTx(id)
+		if err != nil {
+			return nil, ErrorAppend(err, id)
+		}
+		node := Data{"id": id}
+		var links []modelLink
+		signer := txSigner(tx)
+		switch bigchain.GetTxOperation(tx) {
+		case bigchain.CREATE:
+			model := bigchain.GetTxData(tx)
+			_type := GetModelType(model)
+			node.Set("type", _type)
+			if name := spec.GetName(model); !EmptyStr(name) {
+				node.Set("name", name)
+			}
+			links = modelLinks(model)
+			if _type != "party" || id == modelId {
+				linking, err := linkingIds(cli, id)
+				if err != nil {
+					return nil, err
+				}
+				for _, linkingId := range linking {
+					if _, ok := seen[linkingId]; !ok {
+						seen[linkingId] = struct{}{}
+						queue = append(queue, linkingId)
+					}
+				}
+			}
+		case bigchain.TRANSFER:
+			node.Set("type", TYPE_TRANSFER)
+			links = []modelLink{{"asset", bigchain.GetTxAssetId(tx)}}
+		}
+		graph.Nodes = append(graph.Nodes, node)
+		for _, link := range links {
+			graph.Edges = append(graph.Edges, Data{
+				"field":  link.field,
+				"from":   id,
+				"signer": signer,
+				"to":     link.id,
+				"txId":   id,
+			})
+			if _, ok := seen[link.id]; !ok {
+				seen[link.id] = struct{}{}
+				queue = append(queue, link.id)
+			}
+		}
+	}
+	return graph, nil
+}
+
+// Ids of models that link to id, whose edges are added when they're
+// visited. Search results aren't verified, their txs are
+
+func linkingIds(cli *bigchain.Client, id string) ([]string, error) {
+	assets, err := cli.SearchAssets(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, asset := range assets {
+		for _, link := range modelLinks(asset.GetData("data")) {
+			if link.id == id {
+				ids = append(ids, asset.GetStr("id"))
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Comma-separated keys of the tx's first input
+
+func txSigner(tx Data) string {
+	inputs := bigchain.GetTxInputs(tx)
+	if len(inputs) == 0 {
+		return ""
+	}
+	pubs := bigchain.GetInputPublicKeys(inputs[0])
+	keys := make([]string, len(pubs))
+	for i, pub := range pubs {
+		keys[i] = pub.String()
+	}
+	return strings.Join(keys, ",")
+}
+
+type modelLink struct {
+	field string
+	id    string
+}
+
+// Links anywhere in the model, e.g. {"id": id} in "recordingOf" or in
+// the item list of "composition", named by their top-level field
+
+func modelLinks(model Data) []modelLink {
+	fields := make([]string, 0, len(model))
+	for field := range model {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	var links []modelLink
+	for _, field := range fields {
+		for _, id := range collectIds(model[field]) {
+			links = append(links, modelLink{field, id})
+		}
+	}
+	return links
+}
+
+func collectIds(value interface{}) []string {
+	switch value := value.(type) {
+	case Data:
+		return collectIds(map[string]interface{}(value))
+	case map[string]interface{}:
+		if id, ok := value["id"].(string); ok && spec.MatchId(id) {
+			return []string{id}
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var ids []string
+		for _, key := range keys {
+			ids = append(ids, collectIds(value[key])...)
+		}
+		return ids
+	case []Data:
+		var ids []string
+		for _, v := range value {
+			ids = append(ids, collectIds(v)...)
+		}
+		return ids
+	case []interface{}:
+		var ids []string
+		for _, v := range value {
+			ids = append(ids, collectIds(v)...)
+		}
+		return ids
+	}
+	return nil
+}
+
+// Quotes and joins lines of a DOT label or id
+
+func dotQuote(lines ...string) string {
+	for i, line := range lines {
+		line = strings.Replace(line, `\`, `\\`, -1)
+		lines[i] = strings.Replace(line, `"`, `\"`, -1)
+	}
+	return `"` + strings.Join(lines, `\n`) + `"`
+}
+
+func shortId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// Graphviz DOT, e.g. for dot -Tsvg
+
+func (graph *Graph) DOT() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("digraph provenance {\n\trankdir=LR;\n")
+	for _, node := range graph.Nodes {
+		label := []string{node.GetStr("type"), shortId(node.GetStr("id"))}
+		if name := node.GetStr("name"); !EmptyStr(name) {
+			label = append(label, name)
+		}
+		buf.WriteString(Sprintf("\t%s [label=%s];\n", dotQuote(node.GetStr("id")), dotQuote(label...)))
+	}
+	for _, edge := range graph.Edges {
+		label := dotQuote(edge.GetStr("field"), "tx "+shortId(edge.GetStr("txId")), "signer "+shortId(edge.GetStr("signer")))
+		buf.WriteString(Sprintf("\t%s -> %s [label=%s];\n", dotQuote(edge.GetStr("from")), dotQuote(edge.GetStr("to")), label))
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// JSON-LD with node ids relative to the ledger's transactions/ and
+// each edge as an envoke:Link carrying its evidence
+
+func (graph *Graph) JSONLD() Data {
+	items := make([]Data, 0, len(graph.Nodes)+len(graph.Edges))
+	nodes := make(map[string]Data)
+	for _, node := range graph.Nodes {
+		item := Data{"id": node.GetStr("id")}
+		if class, ok := modelClasses[node.GetStr("type")]; ok {
+			item.Set("type", class)
+		}
+		if name := node.GetStr("name"); !EmptyStr(name) {
+			item.Set("schema:name", name)
+		}
+		nodes[node.GetStr("id")] = item
+		items = append(items, item)
+	}
+	for _, edge := range graph.Edges {
+		field := "envoke:" + edge.GetStr("field")
+		to := Data{"id": edge.GetStr("to")}
+		if from, ok := nodes[edge.GetStr("from")]; ok {
+			switch linked := from.Get(field).(type) {
+			case nil:
+				from.Set(field, to)
+			case Data:
+				from.Set(field, []Data{linked, to})
+			case []Data:
+				from.Set(field, append(linked, to))
+			}
+		}
+		items = append(items, Data{
+			"type":               "envoke:Link",
+			"envoke:property":    field,
+			"envoke:signer":      edge.GetStr("signer"),
+			"envoke:source":      Data{"id": edge.GetStr("from")},
+			"envoke:target":      to,
+			"envoke:transaction": Data{"id": edge.GetStr("txId")},
+		})
+	}
+	return Data{
+		"@context": Data{
+			"@base":  graph.Endpoint + "transactions/",
+			"envoke": VOCAB_ENVOKE,
+			"id":     "@id",
+			"schema": "http://schema.org/",
+			"type":   "@type",
+		},
+		"@graph": items,
+	}
+}
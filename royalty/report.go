@@ -0,0 +1,166 @@
+package royalty
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	. "github.com/zbo14/envoke/common"
+	"github.com/zbo14/envoke/regex"
+)
+
+// Usage of a recording in a territory over a period, e.g. a row of a
+// distributor's report. Revenue is in minor units (e.g. cents) of the
+// report's currency
+
+type Usage struct {
+	Isrc      string
+	Period    string
+	Revenue   int64
+	Territory string
+}
+
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_JSON = "json"
+)
+
+func ReadReport(r io.Reader, format string) ([]*Usage, error) {
+	switch format {
+	case FORMAT_CSV:
+		return ReadCSVReport(r)
+	case FORMAT_JSON:
+		return ReadJSONReport(r)
+	}
+	return nil, ErrorAppend(ErrInvalidType, format)
+}
+
+// CSV with a header row naming the isrc, period, revenue and territory
+// columns in any order and case; other columns are ignored
+// Revenue is a decimal amount, e.g. 12.34
+
+func ReadCSVReport(r io.Reader) ([]*Usage, error) {
+	rd := csv.NewReader(r)
+	rd.TrimLeadingSpace = true
+	header, err := rd.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{"isrc": -1, "period": -1, "revenue": -1, "territory": -1}
+	for i, name := range header {
+		name = ToLower(strings.TrimSpace(name))
+		if _, ok := columns[name]; ok {
+			columns[name] = i
+		}
+	}
+	for name, i := range columns {
+		if i < 0 {
+			return nil, ErrorAppend(ErrInvalidField, "missing column "+name)
+		}
+	}
+	var usages []*Usage
+	for line := 2; ; line++ {
+		record, err := rd.Read()
+		if err == io.EOF {
+			return usages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		usage, err := newUsage(
+			record[columns["isrc"]],
+			record[columns["period"]],
+			record[columns["revenue"]],
+			record[columns["territory"]],
+		)
+		if err != nil {
+			return nil, ErrorAppend(err, "line "+Itoa(line))
+		}
+		usages = append(usages, usage)
+	}
+}
+
+// JSON array of Data{"isrc", "period", "revenue", "territory"}, where
+// revenue is a decimal number or string, e.g. 12.34 or "12.34"
+
+func ReadJSONReport(r io.Reader) ([]*Usage, error) {
+	var rows []struct {
+		Isrc      string      `json:"isrc"`
+		Period    string      `json:"period"`
+		Revenue   json.Number `json:"revenue"`
+		Territory string      `json:"territory"`
+	}
+	if err := ReadJSON(r, &rows); err != nil {
+		return nil, err
+	}
+	usages := make([]*Usage, len(rows))
+	for i, row := range rows {
+		usage, err := newUsage(row.Isrc, row.Period, row.Revenue.String(), row.Territory)
+		if err != nil {
+			return nil, ErrorAppend(err, "item "+Itoa(i))
+		}
+		usages[i] = usage
+	}
+	return usages, nil
+}
+
+func newUsage(isrc, period, revenue, territory string) (*Usage, error) {
+	isrc = NormalizeIsrc(isrc)
+	if !MatchStr(regex.ISRC, isrc) {
+		return nil, ErrorAppend(ErrInvalidField, "isrc "+isrc)
+	}
+	territory = strings.ToUpper(strings.TrimSpace(territory))
+	if !MatchStr(regex.TERRITORY, territory) {
+		return nil, ErrorAppend(ErrInvalidTerritory, territory)
+	}
+	amount, err := parseRevenue(revenue)
+	if err != nil {
+		return nil, err
+	}
+	return &Usage{
+		Isrc:      isrc,
+		Period:    strings.TrimSpace(period),
+		Revenue:   amount,
+		Territory: territory,
+	}, nil
+}
+
+// ISRCs are often reported without hyphens, e.g. USS1Z9900001 -> US-S1Z-99-00001
+
+func NormalizeIsrc(isrc string) string {
+	isrc = strings.ToUpper(strings.Replace(strings.TrimSpace(isrc), "-", "", -1))
+	if len(isrc) != 12 {
+		return isrc
+	}
+	return isrc[:2] + "-" + isrc[2:5] + "-" + isrc[5:7] + "-" + isrc[7:]
+}
+
+// Decimal amount to minor units, rounded half up on the decimal digits
+// so amounts like 1.005 aren't skewed by binary floating point
+
+func parseRevenue(revenue string) (int64, error) {
+	revenue = strings.TrimSpace(revenue)
+	if strings.HasPrefix(revenue, "-") {
+		return 0, ErrorAppend(ErrInvalidField, "revenue must be non-negative")
+	}
+	if !MatchStr(`^[0-9]+(\.[0-9]+)?$`, revenue) {
+		return 0, ErrorAppend(ErrInvalidField, "revenue "+revenue)
+	}
+	whole, frac := revenue, ""
+	if i := strings.IndexByte(revenue, '.'); i >= 0 {
+		whole, frac = revenue[:i], revenue[i+1:]
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > (math.MaxInt64-100)/100 {
+		return 0, ErrorAppend(ErrInvalidField, "revenue "+revenue)
+	}
+	cents, _ := strconv.ParseInt((frac + "00")[:2], 10, 64)
+	amount := units*100 + cents
+	if len(frac) > 2 && frac[2] >= '5' {
+		amount++
+	}
+	return amount, nil
+}
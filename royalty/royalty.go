@@ -0,0 +1,281 @@
+package royalty
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zbo14/envoke/bigchain"
+	. "github.com/zbo14/envoke/common"
+	ld "github.com/zbo14/envoke/linked_data"
+	"github.com/zbo14/envoke/spec"
+)
+
+// Payout statements for a usage report, as Data{"statements", "unpaid"}
+//
+// Each ISRC is resolved to a recording and the composition it records;
+// revenue is split between the composition and recording rights, with
+// compositionPercent going to the former, and each part among the
+// current holders of the rights in force in the usage's territory,
+// by shares. Composition rights are found in publications of the
+// composition and recording rights in releases of the recording
+//
+// Statements are Data{"lines", "partyId", "total"}, one per party by partyId
+// Lines are Data{"amount", "isrc", "period", "recordingId", "rightId",
+// "shares", "territory", "totalShares"}; shares co-owned by several
+// parties are paid to each equally. Amounts are in the report's minor units
+//
+// Revenue that can't be paid, e.g. for an unknown ISRC or with no right
+// in the territory, is Data{"amount", "isrc", "message", "period", "territory"}
+
+func Calculate(cli *bigchain.Client, compositionPercent int, usages []*Usage) (Data, error) {
+	if compositionPercent < 0 || compositionPercent > 100 {
+		return nil, ErrorAppend(ErrCriteriaNotMet, "composition percent must be between 0 and 100")
+	}
+	calc := &calculator{
+		cli:        cli,
+		lines:      make(map[string][]Data),
+		now:        Now(),
+		ownerships: make(map[string]Data),
+		recordings: make(map[string]*recordingRights),
+		unpaid:     []Data{},
+	}
+	for _, usage := range usages {
+		rights, ok := calc.recordings[usage.Isrc]
+		if !ok {
+			rights = calc.resolveRecording(usage.Isrc)
+			calc.recordings[usage.Isrc] = rights
+		}
+		if rights.err != nil {
+			calc.addUnpaid(usage, usage.Revenue, rights.err.Error())
+			continue
+		}
+		compositionAmount := usage.Revenue * int64(compositionPercent) / 100
+		if err := calc.pay(usage, rights.recordingId, compositionAmount, rights.composition, "composition"); err != nil {
+			return nil, err
+		}
+		if err := calc.pay(usage, rights.recordingId, usage.Revenue-compositionAmount, rights.recording, "recording"); err != nil {
+			return nil, err
+		}
+	}
+	partyIds := make([]string, 0, len(calc.lines))
+	for partyId := range calc.lines {
+		partyIds = append(partyIds, partyId)
+	}
+	sort.Strings(partyIds)
+	statements := make([]Data, len(partyIds))
+	for i, partyId := range partyIds {
+		var total int64
+		for _, line := range calc.lines[partyId] {
+			total += line.GetInt64("amount")
+		}
+		statements[i] = Data{
+			"lines":   calc.lines[partyId],
+			"partyId": partyId,
+			"total":   total,
+		}
+	}
+	return Data{
+		"statements": statements,
+		"unpaid":     calc.unpaid,
+	}, nil
+}
+
+type calculator struct {
+	cli        *bigchain.Client
+	lines      map[string][]Data
+	now        time.Time
+	ownerships map[string]Data
+	recordings map[string]*recordingRights
+	unpaid     []Data
+}
+
+type recordingRights struct {
+	composition []Data
+	err         error
+	recording   []Data
+	recordingId string
+}
+
+func (calc *calculator) addUnpaid(usage *Usage, amount int64, message string) {
+	calc.unpaid = append(calc.unpaid, Data{
+		"amount":    amount,
+		"isrc":      usage.Isrc,
+		"message":   message,
+		"period":    usage.Period,
+		"territory": usage.Territory,
+	})
+}
+
+// Rights of the recording with the ISRC and of the composition it records
+
+func (calc *calculator) resolveRecording(isrc string) *recordingRights {
+	recordings, err := ld.SearchRecordings(calc.cli, isrc, 0)
+	if err != nil {
+		return &recordingRights{err: err}
+	}
+	var recordingId string
+	for _, recording := range recordings {
+		if recording.GetData("data").GetStr("isrcCode") != isrc {
+			continue
+		}
+		if !EmptyStr(recordingId) {
+			return &recordingRights{err: ErrorAppend(ErrCriteriaNotMet, "multiple recordings with isrc")}
+		}
+		recordingId = recording.GetStr("id")
+	}
+	if EmptyStr(recordingId) {
+		return &recordingRights{err: ErrorAppend(ErrCriteriaNotMet, "no recording with isrc")}
+	}
+	recording, err := ld.ValidateRecording(calc.cli, recordingId)
+	if err != nil {
+		return &recordingRights{err: err}
+	}
+	compositionId := spec.GetRecordingOfId(recording)
+	rights := &recordingRights{recordingId: recordingId}
+	publications, err := ld.SearchModels(calc.cli, compositionId, 0, "publication")
+	if err != nil {
+		return &recordingRights{err: err}
+	}
+	for _, publication := range publications {
+		if !hasId(spec.GetCompositionIds(publication.GetData("data")), compositionId) {
+			continue
+		}
+		_, _, compositionRights, err := ld.ValidatePublication(calc.cli, publication.GetStr("id"))
+		if err != nil {
+			continue
+		}
+		rights.composition = append(rights.composition, compositionRights...)
+	}
+	releases, err := ld.SearchModels(calc.cli, recordingId, 0, "release")
+	if err != nil {
+		return &recordingRights{err: err}
+	}
+	for _, release := range releases {
+		if !hasId(spec.GetRecordingIds(release.GetData("data")), recordingId) {
+			continue
+		}
+		_, _, recordingRights, err := ld.ValidateRelease(calc.cli, release.GetStr("id"))
+		if err != nil {
+			continue
+		}
+		rights.recording = append(rights.recording, recordingRights...)
+	}
+	return rights
+}
+
+func hasId(ids []string, id string) bool {
+	for _, other := range ids {
+		if id == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits the amount among the current holders of the rights in force
+// in the usage's territory
+
+func (calc *calculator) pay(usage *Usage, recordingId string, amount int64, rights []Data, kind string) error {
+	if amount == 0 {
+		return nil
+	}
+	var holders []Data
+	var rightIds []string
+	var totalShares int64
+	seen := make(map[string]struct{})
+	for _, right := range rights {
+		rightId := right.GetStr("id")
+		if _, ok := seen[rightId]; ok {
+			continue
+		}
+		seen[rightId] = struct{}{}
+		if !hasId(spec.GetTerritory(right), usage.Territory) {
+			continue
+		}
+		if _, _, _, err := ld.ValidateRightAt(calc.cli, rightId, calc.now); err != nil {
+			continue
+		}
+		ownership, ok := calc.ownerships[rightId]
+		if !ok {
+			var err error
+			ownership, err = ld.ResolveOwnership(calc.cli, rightId, calc.now)
+			if err != nil {
+				return err
+			}
+			calc.ownerships[rightId] = ownership
+		}
+		for _, holder := range ownership.GetDataSlice("holders") {
+			holders = append(holders, holder)
+			rightIds = append(rightIds, rightId)
+		}
+		totalShares += int64(ownership.GetInt("totalShares"))
+	}
+	if totalShares == 0 {
+		calc.addUnpaid(usage, amount, "no "+kind+" right in territory")
+		return nil
+	}
+	weights := make([]int64, len(holders))
+	for i, holder := range holders {
+		weights[i] = int64(holder.GetInt("shares"))
+	}
+	for i, holderAmount := range allocate(amount, weights) {
+		holder := holders[i]
+		partyIds := []string{holder.GetStr("partyId")}
+		if holder.Get("partyIds") != nil {
+			partyIds = holder.GetStrSlice("partyIds")
+		}
+		equal := make([]int64, len(partyIds))
+		for j := range equal {
+			equal[j] = 1
+		}
+		for j, partyAmount := range allocate(holderAmount, equal) {
+			partyId := partyIds[j]
+			if EmptyStr(partyId) {
+				calc.addUnpaid(usage, partyAmount, "no party holding "+kind+" right "+rightIds[i])
+				continue
+			}
+			calc.lines[partyId] = append(calc.lines[partyId], Data{
+				"amount":      partyAmount,
+				"isrc":        usage.Isrc,
+				"period":      usage.Period,
+				"recordingId": recordingId,
+				"rightId":     rightIds[i],
+				"shares":      holder.GetInt("shares"),
+				"territory":   usage.Territory,
+				"totalShares": int(totalShares),
+			})
+		}
+	}
+	return nil
+}
+
+// Splits the amount in proportion to the weights, giving the cents
+// lost to rounding to the largest remainders so the parts sum to it
+
+func allocate(amount int64, weights []int64) []int64 {
+	var total int64
+	for _, weight := range weights {
+		total += weight
+	}
+	parts := make([]int64, len(weights))
+	if total == 0 {
+		return parts
+	}
+	remainders := make([]int64, len(weights))
+	order := make([]int, len(weights))
+	left := amount
+	for i, weight := range weights {
+		parts[i] = amount * weight / total
+		remainders[i] = amount * weight % total
+		order[i] = i
+		left -= parts[i]
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for i := 0; left > 0; i++ {
+		parts[order[i]]++
+		left--
+	}
+	return parts
+}
@@ -0,0 +1,50 @@
+package royalty
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	usages, err := ReadReport(bytes.NewBufferString("Revenue,ISRC,Store,Territory,Period\n12.345,uss1z9900001,web,gb,2017-Q1\n"), FORMAT_CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 || *usages[0] != (Usage{"US-S1Z-99-00001", "2017-Q1", 1235, "GB"}) {
+		t.Errorf("Expected usage from CSV, got %v", usages[0])
+	}
+	usages, err = ReadReport(bytes.NewBufferString(`[{"isrc":"US-S1Z-99-00001","period":"2017-Q1","revenue":7,"territory":"US"},{"isrc":"US-S1Z-99-00002","revenue":"0.5","territory":"US"}]`), FORMAT_JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 2 || usages[0].Revenue != 700 || usages[1].Revenue != 50 {
+		t.Errorf("Expected usages from JSON, got %v", usages)
+	}
+	usages, err = ReadCSVReport(bytes.NewBufferString("isrc,revenue,territory,period\nUS-S1Z-99-00001,1.005,US,2017-Q1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 || usages[0].Revenue != 101 {
+		t.Errorf("Expected revenue rounded half up, got %v", usages)
+	}
+	if _, err = ReadCSVReport(bytes.NewBufferString("isrc,revenue,territory,period\nUS-S1Z-99-00001,-1,US,2017-Q1\n")); err == nil {
+		t.Error("Expected error for negative revenue")
+	}
+	if _, err = ReadCSVReport(bytes.NewBufferString("isrc,revenue,period\n")); err == nil {
+		t.Error("Expected error for missing column")
+	}
+	if _, err = ReadJSONReport(bytes.NewBufferString(`[{"isrc":"US-S1Z-99-00001","revenue":1,"territory":"USA"}]`)); err == nil {
+		t.Error("Expected error for invalid territory")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	parts := allocate(100, []int64{1, 1, 1})
+	if parts[0] != 34 || parts[1] != 33 || parts[2] != 33 {
+		t.Errorf("Expected remainder to first part, got %v", parts)
+	}
+	parts = allocate(1001, []int64{70, 25, 5})
+	if parts[0]+parts[1]+parts[2] != 1001 || parts[0] != 701 {
+		t.Errorf("Expected parts to sum to amount, got %v", parts)
+	}
+}
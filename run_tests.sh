@@ -1,10 +1,6 @@
 #!/bin/sh
 
-source init.sh
-
-read -p "Enter path to audio file: " path
-
-export PATH_TO_AUDIO_FILE=$path
+# Tests run against an in-memory ledger, no endpoint needed
 
 cd ~/go/src/github.com/zbo14/envoke/crypto
 go test -v
@@ -12,5 +8,14 @@ go test -v
 cd ~/go/src/github.com/zbo14/envoke/bigchain 
 go test -v
 
+cd ~/go/src/github.com/zbo14/envoke/linked_data
+go test -v
+
+cd ~/go/src/github.com/zbo14/envoke/store
+go test -v
+
+cd ~/go/src/github.com/zbo14/envoke/royalty
+go test -v
+
 cd ~/go/src/github.com/zbo14/envoke/api
 go test -v
\ No newline at end of file
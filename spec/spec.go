@@ -386,6 +386,14 @@ func GetTerritory(data Data) []string {
 	return data.GetStrSlice("territory")
 }
 
+func GetValidFrom(data Data) string {
+	return data.GetStr("validFrom")
+}
+
+func GetValidThrough(data Data) string {
+	return data.GetStr("validThrough")
+}
+
 // Note: txId is the hex id of a TRANSFER tx in Bigchain/IPDB
 // the output amount(s) will specify shares transferred/kept
 
@@ -530,3 +538,67 @@ func GetRecordingRightId(data Data) string {
 	recordingRight := data.GetData("recordingRight")
 	return GetId(recordingRight)
 }
+
+func GetUsage(data Data) []string {
+	return data.GetStrSlice("usage")
+}
+
+// Tx metadata
+// Empty fields are left out and nil is returned if there are none
+
+func NewCreateMetadata(client, clientVersion string) Data {
+	metadata := Data{}
+	if !EmptyStr(client) {
+		metadata.Set("client", client)
+	}
+	if !EmptyStr(clientVersion) {
+		metadata.Set("clientVersion", clientVersion)
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+func GetClient(data Data) string {
+	return data.GetStr("client")
+}
+
+func GetClientVersion(data Data) string {
+	return data.GetStr("clientVersion")
+}
+
+// A malformed effective date is an error, since it decides when the
+// transfer applies
+
+func NewTransferMetadata(contract, effectiveDate, reason string) (Data, error) {
+	metadata := Data{}
+	if !EmptyStr(contract) {
+		metadata.Set("contract", contract)
+	}
+	if !EmptyStr(effectiveDate) {
+		if !MatchStr(regex.DATE, effectiveDate) {
+			return nil, ErrorAppend(ErrInvalidField, "effectiveDate "+effectiveDate)
+		}
+		metadata.Set("effectiveDate", effectiveDate)
+	}
+	if !EmptyStr(reason) {
+		metadata.Set("reason", reason)
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return metadata, nil
+}
+
+func GetContract(data Data) string {
+	return data.GetStr("contract")
+}
+
+func GetEffectiveDate(data Data) string {
+	return data.GetStr("effectiveDate")
+}
+
+func GetReason(data Data) string {
+	return data.GetStr("reason")
+}
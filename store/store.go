@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/zbo14/envoke/common"
+)
+
+// Embedded key-value store backed by an append-only log
+// Keys map to the last value put; the keys and values are held in memory
+// and rebuilt from the log on Open. A torn record at the end of the log,
+// e.g. after a crash, is truncated
+//
+// Record: key length (4) | value length (4) | key | value | crc32 (4)
+
+const MAX_RECORD_LEN = 1 << 26
+
+var errTornRecord = Error("Torn record")
+
+type Store struct {
+	file   *os.File
+	mtx    sync.RWMutex
+	values map[string][]byte
+}
+
+func Open(path string) (*Store, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{
+		file:   file,
+		values: make(map[string][]byte),
+	}
+	size, err := store.load()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err = file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Returns the size of the log up to the last whole record
+
+func (store *Store) load() (int64, error) {
+	rd := bufio.NewReader(store.file)
+	var size int64
+	for {
+		key, value, n, err := readRecord(rd)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errTornRecord {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		store.values[string(key)] = value
+		size += n
+	}
+}
+
+func readRecord(r io.Reader) (key, value []byte, n int64, err error) {
+	header := make([]byte, 8)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	keyLen := binary.BigEndian.Uint32(header)
+	valueLen := binary.BigEndian.Uint32(header[4:])
+	if uint64(keyLen)+uint64(valueLen) > MAX_RECORD_LEN {
+		err = errTornRecord
+		return
+	}
+	p := make([]byte, keyLen+valueLen+4)
+	if _, err = io.ReadFull(r, p); err != nil {
+		return
+	}
+	sum := crc32.NewIEEE()
+	sum.Write(header)
+	sum.Write(p[:keyLen+valueLen])
+	if sum.Sum32() != binary.BigEndian.Uint32(p[keyLen+valueLen:]) {
+		err = errTornRecord
+		return
+	}
+	return p[:keyLen], p[keyLen : keyLen+valueLen], int64(len(header) + len(p)), nil
+}
+
+func record(key string, value []byte) []byte {
+	p := make([]byte, 8, 8+len(key)+len(value)+4)
+	binary.BigEndian.PutUint32(p, uint32(len(key)))
+	binary.BigEndian.PutUint32(p[4:], uint32(len(value)))
+	p = append(p, key...)
+	p = append(p, value...)
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(p))
+	return append(p, sum...)
+}
+
+func (store *Store) Close() error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	return store.file.Close()
+}
+
+func (store *Store) Get(key string) ([]byte, bool) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+	value, ok := store.values[key]
+	return value, ok
+}
+
+func (store *Store) Has(key string) bool {
+	_, ok := store.Get(key)
+	return ok
+}
+
+// The record is synced to disk before Put returns
+
+func (store *Store) Put(key string, value []byte) error {
+	if len(key)+len(value) > MAX_RECORD_LEN {
+		return ErrorAppend(ErrInvalidSize, "record too large")
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	if _, err := store.file.Write(record(key, value)); err != nil {
+		return err
+	}
+	if err := store.file.Sync(); err != nil {
+		return err
+	}
+	store.values[key] = value
+	return nil
+}
+
+// Sorted keys with the prefix
+
+func (store *Store) Keys(prefix string) []string {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+	var keys []string
+	for key := range store.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
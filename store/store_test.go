@@ -0,0 +1,64 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"b/2", "a/1", "b/1", "c"} {
+		if err = store.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Put("c", []byte("cc")); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Torn write at the end of the log
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(record("d", []byte("d"))[:10])
+	file.Close()
+	if store, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, ok := store.Get("c"); !ok || string(value) != "cc" {
+		t.Error("Expected last value put")
+	}
+	if store.Has("d") {
+		t.Error("Expected torn record to be dropped")
+	}
+	keys := store.Keys("b/")
+	if len(keys) != 2 || keys[0] != "b/1" || keys[1] != "b/2" {
+		t.Errorf("Expected sorted keys with prefix, got %v", keys)
+	}
+	// Appends continue after the truncated record
+	if err = store.Put("d", []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if store, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Has("d") || len(store.Keys("")) != 5 {
+		t.Error("Expected record put after truncation")
+	}
+}